@@ -0,0 +1,373 @@
+// ktxtool inspects, validates and converts KTX textures.
+//
+//	ktxtool info file.ktx
+//	ktxtool validate file.ktx...
+//	ktxtool extract [-o dir] file.ktx
+//	ktxtool create -o out.ktx [-srgb] [-cube|-array] [-mips gl|none|box|kaiser] [-flip] image...
+//	ktxtool atlas -o out.ktx [-json out.json] [-go out.go] [-size n] [-padding n] [-extrude n] [-array] dir
+package main
+
+import (
+	"flag"
+	"fmt"
+	gl "github.com/chsc/gogl/gl42"
+	"github.com/ginuerzh/gogl/utils"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+var targetNames = map[gl.Enum]string{
+	gl.TEXTURE_1D:             "TEXTURE_1D",
+	gl.TEXTURE_2D:             "TEXTURE_2D",
+	gl.TEXTURE_3D:             "TEXTURE_3D",
+	gl.TEXTURE_1D_ARRAY:       "TEXTURE_1D_ARRAY",
+	gl.TEXTURE_2D_ARRAY:       "TEXTURE_2D_ARRAY",
+	gl.TEXTURE_CUBE_MAP:       "TEXTURE_CUBE_MAP",
+	gl.TEXTURE_CUBE_MAP_ARRAY: "TEXTURE_CUBE_MAP_ARRAY",
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: ktxtool info|validate|extract|create|atlas [flags] file...")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "info":
+		err = info(args)
+	case "validate":
+		err = validate(args)
+	case "extract":
+		err = extract(args)
+	case "create":
+		err = create(args)
+	case "atlas":
+		err = atlas(args)
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ktxtool:", err)
+		os.Exit(1)
+	}
+}
+
+func parseFile(name string) (*utils.KTXFile, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	f, err := utils.ParseKtx(file)
+	if e, ok := err.(*utils.KtxFormatError); ok {
+		e.File = name
+	}
+	return f, err
+}
+
+func printable(v []byte) string {
+	s := strings.TrimSuffix(string(v), "\x00")
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return fmt.Sprintf("% x", v)
+		}
+	}
+	return fmt.Sprintf("%q", s)
+}
+
+func info(args []string) error {
+	if len(args) != 1 {
+		usage()
+	}
+	f, err := parseFile(args[0])
+	if err != nil {
+		return err
+	}
+	h := &f.Header
+
+	version := "1.1"
+	if f.KTX2 != nil {
+		version = "2.0"
+	}
+	fmt.Printf("file:                 %s (KTX %s)\n", args[0], version)
+	fmt.Printf("target:               %s\n", targetNames[f.Target])
+	fmt.Printf("glType:               %#x\n", h.Gltype)
+	fmt.Printf("glTypeSize:           %d\n", h.Gltypesize)
+	fmt.Printf("glFormat:             %#x\n", h.Glformat)
+	fmt.Printf("glInternalFormat:     %#x\n", h.Glinternalformat)
+	fmt.Printf("glBaseInternalFormat: %#x\n", h.Glbaseinternalformat)
+	fmt.Printf("pixelSize:            %d x %d x %d\n", h.Pixelwidth, h.Pixelheight, h.Pixeldepth)
+	fmt.Printf("arrayElements:        %d\n", h.Arrayelements)
+	fmt.Printf("faces:                %d\n", h.Faces)
+	fmt.Printf("mipLevels:            %d\n", h.Miplevels)
+	fmt.Printf("compressed:           %v\n", h.IsCompressed())
+	fmt.Printf("rowAlignment:         %d\n", f.Alignment)
+	if f.KTX2 != nil {
+		k := &f.KTX2.Header
+		fmt.Printf("vkFormat:             %d\n", k.VkFormat)
+		fmt.Printf("supercompression:     %d\n", k.SupercompressionScheme)
+	}
+
+	if len(f.KeyValue) > 0 {
+		fmt.Println("metadata:")
+		for _, kv := range f.KeyValue {
+			fmt.Printf("  %s = %s\n", kv.Key, printable(kv.Value))
+		}
+	}
+
+	fmt.Println("levels:")
+	for i, l := range f.Levels {
+		fmt.Printf("  %2d: %d x %d x %d, %d images, %d bytes\n", i, l.Width, l.Height, l.Depth, len(l.Images), len(l.Data))
+	}
+	return nil
+}
+
+func validate(args []string) error {
+	if len(args) == 0 {
+		usage()
+	}
+
+	failed := 0
+	for _, name := range args {
+		f, err := parseFile(name)
+		if err != nil {
+			fmt.Println(err)
+			failed++
+			continue
+		}
+		errs := f.Validate()
+		for _, e := range errs {
+			fmt.Printf("%s: %v\n", name, e)
+		}
+		if len(errs) > 0 {
+			failed++
+		} else {
+			fmt.Printf("%s: ok\n", name)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed validation", failed, len(args))
+	}
+	return nil
+}
+
+func extract(args []string) error {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	dir := fs.String("o", ".", "output directory")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	name := fs.Arg(0)
+	f, err := parseFile(name)
+	if err != nil {
+		return err
+	}
+
+	base := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	for i, l := range f.Levels {
+		for _, img := range l.Images {
+			out, err := utils.KtxImage(f, i, img.Layer, img.Face)
+			if err != nil {
+				return err
+			}
+			file := filepath.Join(*dir, fmt.Sprintf("%s_level%d_layer%d_face%d.png", base, i, img.Layer, img.Face))
+			if err := writePng(file, out); err != nil {
+				return err
+			}
+			fmt.Println(file)
+		}
+	}
+	return nil
+}
+
+func writePng(name string, img image.Image) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func create(args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	out := fs.String("o", "", "output KTX file")
+	srgb := fs.Bool("srgb", false, "store as SRGB8_ALPHA8")
+	cube := fs.Bool("cube", false, "six images are the +X, -X, +Y, -Y, +Z, -Z faces of a cube map")
+	array := fs.Bool("array", false, "images are the layers of a 2D array")
+	mips := fs.String("mips", "gl", "mip generation: gl, none, box or kaiser")
+	flip := fs.Bool("flip", false, "store the bottom row first")
+	fs.Parse(args)
+
+	if *out == "" || fs.NArg() == 0 || (*cube && *array) {
+		usage()
+	}
+	if *cube && fs.NArg() != 6 {
+		return fmt.Errorf("a cube map needs 6 images, got %d", fs.NArg())
+	}
+	if *cube && *flip {
+		return fmt.Errorf("cube map faces are stored top row first, -flip doesn't apply")
+	}
+	if !*cube && !*array && fs.NArg() != 1 {
+		return fmt.Errorf("use -array to combine %d images", fs.NArg())
+	}
+
+	opts := &utils.ImageOptions{SRGB: *srgb, FlipY: *flip}
+	var err error
+	if opts.Mipmaps, err = mipFilter(*mips); err != nil {
+		return err
+	}
+
+	var files []*utils.KTXFile
+	for _, name := range fs.Args() {
+		img, err := decodeImage(name)
+		if err != nil {
+			return err
+		}
+		f, err := utils.NewImageKtx(img, opts)
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 && (f.Header.Pixelwidth != files[0].Header.Pixelwidth ||
+			f.Header.Pixelheight != files[0].Header.Pixelheight) {
+			return fmt.Errorf("%s: size differs from %s", name, fs.Arg(0))
+		}
+		files = append(files, f)
+	}
+
+	// Every image has the same levels, so layers and faces are the images
+	// of each level back to back.
+	h := files[0].Header
+	if *cube {
+		h.Faces = 6
+	} else if *array {
+		h.Arrayelements = uint32(len(files))
+	}
+	var levels [][]byte
+	for i := range files[0].Levels {
+		var data []byte
+		for _, f := range files {
+			data = append(data, f.Levels[i].Data...)
+		}
+		levels = append(levels, data)
+	}
+
+	f, err := utils.NewKtx(h, levels)
+	if err != nil {
+		return err
+	}
+	f.KeyValue = files[0].KeyValue
+	if *cube {
+		// GL defines its own orientation for cube map faces.
+		f.KeyValue.Delete("KTXorientation")
+	}
+	f.KeyValue.SetString("KTXwriter", "ktxtool")
+	return utils.SaveKtxFile(*out, f)
+}
+
+func mipFilter(name string) (utils.MipFilter, error) {
+	switch name {
+	case "gl":
+		return utils.MipGenerate, nil
+	case "none":
+		return utils.MipNone, nil
+	case "box":
+		return utils.MipBox, nil
+	case "kaiser":
+		return utils.MipKaiser, nil
+	}
+	return 0, fmt.Errorf("unknown mip filter %q", name)
+}
+
+func atlas(args []string) error {
+	fs := flag.NewFlagSet("atlas", flag.ExitOnError)
+	out := fs.String("o", "", "output KTX file")
+	jsonOut := fs.String("json", "", "write the UV table as JSON")
+	goOut := fs.String("go", "", "write the UV table as Go source")
+	pkg := fs.String("pkg", "main", "package of the Go source")
+	name := fs.String("name", "Atlas", "variable name in the Go source")
+	size := fs.Int("size", 1024, "page size")
+	padding := fs.Int("padding", 2, "texels between images")
+	extrude := fs.Int("extrude", 1, "texels of edge extrusion")
+	array := fs.Bool("array", false, "spill onto more layers of a 2D array")
+	srgb := fs.Bool("srgb", false, "store as SRGB8_ALPHA8")
+	mips := fs.String("mips", "box", "mip generation: gl, none, box or kaiser")
+	fs.Parse(args)
+
+	if *out == "" || fs.NArg() != 1 {
+		usage()
+	}
+	opts := &utils.ImageOptions{SRGB: *srgb}
+	var err error
+	if opts.Mipmaps, err = mipFilter(*mips); err != nil {
+		return err
+	}
+
+	a, err := utils.LoadAtlasDir(fs.Arg(0), &utils.AtlasOptions{
+		Size: *size, Padding: *padding, Extrude: *extrude, Array: *array,
+	})
+	if err != nil {
+		return err
+	}
+	f, err := a.Ktx(opts)
+	if err != nil {
+		return err
+	}
+	f.KeyValue.SetString("KTXwriter", "ktxtool")
+	if err := utils.SaveKtxFile(*out, f); err != nil {
+		return err
+	}
+
+	if *jsonOut != "" {
+		if err := writeFile(*jsonOut, a.WriteJSON); err != nil {
+			return err
+		}
+	}
+	if *goOut != "" {
+		return writeFile(*goOut, func(w io.Writer) error { return a.WriteGo(w, *pkg, *name) })
+	}
+	return nil
+}
+
+func writeFile(name string, write func(io.Writer) error) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func decodeImage(name string) (image.Image, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return img, nil
+}
@@ -51,7 +51,11 @@ func startup() {
 		0.25, 0.25, 0.5, 1.0,
 	}
 	size := len(data)
-	program = utils.CompileShaders(utils.ShaderString, vss, fss)
+	var err error
+	program, err = utils.CompileShaders(utils.ShaderString, vss, fss)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	gl.GenVertexArrays(1, &vao)
 	gl.BindVertexArray(vao)
@@ -93,7 +97,9 @@ func shutdown() {
 }
 
 func main() {
-	utils.GlfwInit(width, height, title, majorVersion, minorVersion, debug)
+	if err := utils.GlfwInit(width, height, title, majorVersion, minorVersion, debug); err != nil {
+		log.Fatal(err)
+	}
 	defer utils.GlfwDestroy()
 
 	startup()
@@ -4,10 +4,11 @@ package main
 import (
 	gl "github.com/chsc/gogl/gl42"
 	"github.com/ginuerzh/gogl/utils"
+	"log"
 )
 
 var (
-	program gl.Uint
+	program *utils.ReloadableProgram
 	texture gl.Uint
 	vao     gl.Uint
 )
@@ -36,14 +37,18 @@ func startup() {
 	data := genTexture(256, 256)
 	gl.TexSubImage2D(gl.TEXTURE_2D, gl.Int(0), gl.Int(0), gl.Int(0), gl.Sizei(256), gl.Sizei(256), gl.RGBA, gl.FLOAT, gl.Pointer(&data[0]))
 
-	program = utils.CompileShaders(utils.ShaderFile, "./shaders/simpletexture_vs.glsl", "./shaders/simpletexture_fs.glsl")
+	var err error
+	program, err = utils.WatchShaders("./shaders/simpletexture_vs.glsl", "./shaders/simpletexture_fs.glsl")
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	gl.GenVertexArrays(1, &vao)
 	gl.BindVertexArray(vao)
 }
 
 func shutdown() {
-	gl.DeleteProgram(program)
+	program.Delete()
 	gl.DeleteVertexArrays(1, &vao)
 	gl.DeleteTextures(1, &texture)
 }
@@ -52,13 +57,15 @@ func render() {
 	green := []gl.Float{0.0, 0.25, 0.0, 1.0}
 	gl.ClearBufferfv(gl.COLOR, 0, &green[0])
 
-	gl.UseProgram(program)
+	gl.UseProgram(program.Program())
 
 	gl.DrawArrays(gl.TRIANGLES, 0, 3)
 }
 
 func main() {
-	utils.GlfwInit(640, 480, "OpenGL SuperBible - Simple Texturing", 3, 0)
+	if err := utils.GlfwInit(640, 480, "OpenGL SuperBible - Simple Texturing", 3, 0, false); err != nil {
+		log.Fatal(err)
+	}
 	defer utils.GlfwDestroy()
 
 	startup()
@@ -4,6 +4,7 @@ import (
 	gl "github.com/chsc/gogl/gl42"
 	"github.com/ginuerzh/gogl/utils"
 	"github.com/ginuerzh/math3d"
+	"log"
 	"math"
 )
 
@@ -17,7 +18,7 @@ const (
 )
 
 var (
-	program  gl.Uint
+	program  *utils.ReloadableProgram
 	vao      gl.Uint
 	vbuffer  gl.Uint
 	ubuffer  gl.Uint
@@ -78,10 +79,16 @@ func startup() {
 		-0.25, 0.25, -0.25,
 	}
 	size := len(vertex_positions)
-	program = utils.CompileShaders(utils.ShaderFile, "./shaders/spinnycube_vs.glsl", "./shaders/spinnycube_fs.glsl")
-
-	mv_loc = gl.GetUniformLocation(program, gl.GLString("mv_matrix"))
-	proj_loc = gl.GetUniformLocation(program, gl.GLString("proj_matrix"))
+	var err error
+	program, err = utils.WatchShaders("./shaders/spinnycube_vs.glsl", "./shaders/spinnycube_fs.glsl")
+	if err != nil {
+		log.Fatal(err)
+	}
+	program.OnReload = func(p gl.Uint) {
+		mv_loc = gl.GetUniformLocation(p, gl.GLString("mv_matrix"))
+		proj_loc = gl.GetUniformLocation(p, gl.GLString("proj_matrix"))
+	}
+	program.OnReload(program.Program())
 
 	gl.GenVertexArrays(1, &vao)
 	gl.BindVertexArray(vao)
@@ -112,6 +119,8 @@ func render(currentTime float64) {
 	gl.ClearDepth(1.0)
 	gl.Clear(gl.DEPTH_BUFFER_BIT)
 
+	gl.UseProgram(program.Program())
+
 	data := utils.ToGLFloat(proj_matrix.ToSlice32())
 	gl.UniformMatrix4fv(proj_loc, 1, gl.GLBool(false), &data[0])
 
@@ -135,12 +144,14 @@ func render(currentTime float64) {
 
 func shutdown() {
 	gl.DeleteBuffers(1, &vbuffer)
-	gl.DeleteProgram(program)
+	program.Delete()
 	gl.DeleteVertexArrays(1, &vao)
 }
 
 func main() {
-	utils.GlfwInit(width, height, title, majorVersion, minorVersion, debug)
+	if err := utils.GlfwInit(width, height, title, majorVersion, minorVersion, debug); err != nil {
+		log.Fatal(err)
+	}
 	defer utils.GlfwDestroy()
 
 	startup()
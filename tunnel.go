@@ -5,6 +5,7 @@ import (
 	gl "github.com/chsc/gogl/gl42"
 	"github.com/ginuerzh/gogl/utils"
 	"github.com/ginuerzh/math3d"
+	"log"
 )
 
 const (
@@ -17,11 +18,9 @@ const (
 )
 
 var (
-	program gl.Uint
-	vao     gl.Uint
-
-	locMvp    gl.Int
-	locOffset gl.Int
+	program  *utils.ReloadableProgram
+	uniforms *utils.Program
+	vao      gl.Uint
 
 	texWall    gl.Uint
 	texCeiling gl.Uint
@@ -30,28 +29,33 @@ var (
 
 func startup() {
 
-	program = utils.CompileShaders(utils.ShaderFile, "./shaders/tunnel_vs.glsl", "./shaders/tunnel_fs.glsl")
-
-	locMvp = gl.GetUniformLocation(program, gl.GLString("mvp"))
-	locOffset = gl.GetUniformLocation(program, gl.GLString("offset"))
+	var err error
+	program, err = utils.WatchShaders("./shaders/tunnel_vs.glsl", "./shaders/tunnel_fs.glsl")
+	if err != nil {
+		log.Fatal(err)
+	}
+	program.OnReload = func(p gl.Uint) {
+		uniforms = utils.NewProgram(p)
+	}
+	program.OnReload(program.Program())
 
 	gl.GenVertexArrays(1, &vao)
 	gl.BindVertexArray(vao)
 
-	texWall = utils.LoadKtx("./media/textures/brick.ktx", 0)
-	texCeiling = utils.LoadKtx("./media/textures/ceiling.ktx", 0)
-	texFloor = utils.LoadKtx("./media/textures/floor.ktx", 0)
-
-	textures := []gl.Uint{texWall, texCeiling, texFloor}
-	for _, v := range textures {
-		gl.BindTexture(gl.TEXTURE_2D, v)
-		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR_MIPMAP_LINEAR)
-		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	sampler := &utils.SamplerDesc{MinFilter: gl.LINEAR_MIPMAP_LINEAR, MagFilter: gl.LINEAR}
+	if texWall, err = utils.LoadKtxSampler("./media/textures/brick.ktx", 0, sampler); err != nil {
+		log.Fatal(err)
+	}
+	if texCeiling, err = utils.LoadKtxSampler("./media/textures/ceiling.ktx", 0, sampler); err != nil {
+		log.Fatal(err)
+	}
+	if texFloor, err = utils.LoadKtxSampler("./media/textures/floor.ktx", 0, sampler); err != nil {
+		log.Fatal(err)
 	}
 }
 
 func shutdown() {
-	gl.DeleteProgram(program)
+	program.Delete()
 	gl.DeleteVertexArrays(1, &vao)
 }
 
@@ -66,12 +70,14 @@ func render(currentTime float64) {
 		gl.Clear(gl.COLOR_BUFFER_BIT)
 	}
 
-	gl.UseProgram(program)
+	gl.UseProgram(program.Program())
 
 	aspect := float64(width) / float64(height)
 	proj_matrix := math3d.Perspective(60, aspect, 0.1, 100)
 
-	gl.Uniform1f(locOffset, gl.Float(currentTime*0.003))
+	if err := uniforms.Set("offset", currentTime*0.003); err != nil {
+		log.Println(err)
+	}
 
 	textures := []gl.Uint{texWall, texFloor, texWall, texCeiling}
 	for i, v := range textures {
@@ -80,9 +86,9 @@ func render(currentTime float64) {
 		mv_matrix = mv_matrix.MultiM(math3d.Rotate(90, 0, 1, 0))
 		mv_matrix = mv_matrix.MultiM(math3d.Scale(30, 1, 1))
 
-		data := utils.ToGLFloat(proj_matrix.MultiM(mv_matrix).ToSlice32())
-
-		gl.UniformMatrix4fv(locMvp, 1, gl.GLBool(false), &data[0])
+		if err := uniforms.Set("mvp", proj_matrix.MultiM(mv_matrix)); err != nil {
+			log.Println(err)
+		}
 
 		gl.BindTexture(gl.TEXTURE_2D, v)
 		gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
@@ -91,7 +97,9 @@ func render(currentTime float64) {
 }
 
 func main() {
-	utils.GlfwInit(width, height, title, majorVersion, minorVersion, debug)
+	if err := utils.GlfwInit(width, height, title, majorVersion, minorVersion, debug); err != nil {
+		log.Fatal(err)
+	}
 	defer utils.GlfwDestroy()
 
 	startup()
@@ -0,0 +1,340 @@
+// atlas
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// AtlasOptions controls how PackAtlas lays out images.
+type AtlasOptions struct {
+	Size    int  // page width and height, 1024 if 0
+	Padding int  // transparent texels between sprites and around the page
+	Extrude int  // texels of each sprite edge repeated outward
+	Array   bool // spill onto more pages, for a TEXTURE_2D_ARRAY
+}
+
+// AtlasImage is a named input image.
+type AtlasImage struct {
+	Name  string
+	Image image.Image
+}
+
+// AtlasRect locates one image in the atlas. X, Y, W and H are in texels with
+// the origin at the top left of the page; U0, V0, U1 and V1 are texture
+// coordinates with the origin at the bottom left, as LoadKtx uploads it.
+type AtlasRect struct {
+	Name           string
+	Layer          int
+	X, Y, W, H     int
+	U0, V0, U1, V1 float32
+}
+
+// Atlas is the result of PackAtlas.
+type Atlas struct {
+	Size  int
+	Pages []*image.NRGBA
+	Rects []AtlasRect
+}
+
+// skyline is a bottom-left skyline packer for one page.
+type skyline struct {
+	size  int
+	nodes []skylineNode
+}
+
+type skylineNode struct {
+	x, y, w int
+}
+
+func newSkyline(size, margin int) *skyline {
+	return &skyline{size: size, nodes: []skylineNode{{margin, margin, size - margin}}}
+}
+
+// fit returns the height the skyline reaches if a w wide rect sits on node i,
+// or -1 if it does not fit.
+func (s *skyline) fit(i, w, h int) int {
+	x := s.nodes[i].x
+	if x+w > s.nodes[len(s.nodes)-1].x+s.nodes[len(s.nodes)-1].w {
+		return -1
+	}
+	y := 0
+	for left := w; left > 0; i++ {
+		if s.nodes[i].y > y {
+			y = s.nodes[i].y
+		}
+		left -= s.nodes[i].w
+	}
+	if y+h > s.size {
+		return -1
+	}
+	return y
+}
+
+// insert places a w x h rect, returning its position or false if the page
+// is full.
+func (s *skyline) insert(w, h int) (x, y int, ok bool) {
+	best, bestY, bestW := -1, s.size, 0
+	for i := range s.nodes {
+		if y := s.fit(i, w, h); y >= 0 && (y < bestY || (y == bestY && s.nodes[i].w < bestW)) {
+			best, bestY, bestW = i, y, s.nodes[i].w
+		}
+	}
+	if best < 0 {
+		return 0, 0, false
+	}
+
+	x, y = s.nodes[best].x, bestY
+	node := skylineNode{x, y + h, w}
+	s.nodes = append(s.nodes[:best], append([]skylineNode{node}, s.nodes[best:]...)...)
+
+	// Trim the nodes now covered by the new one.
+	for i := best + 1; i < len(s.nodes); i++ {
+		prev := &s.nodes[i-1]
+		if s.nodes[i].x >= prev.x+prev.w {
+			break
+		}
+		shrink := prev.x + prev.w - s.nodes[i].x
+		s.nodes[i].x += shrink
+		s.nodes[i].w -= shrink
+		if s.nodes[i].w > 0 {
+			break
+		}
+		s.nodes = append(s.nodes[:i], s.nodes[i+1:]...)
+		i--
+	}
+
+	// Merge neighbours at the same height.
+	for i := 0; i < len(s.nodes)-1; i++ {
+		if s.nodes[i].y == s.nodes[i+1].y {
+			s.nodes[i].w += s.nodes[i+1].w
+			s.nodes = append(s.nodes[:i+1], s.nodes[i+2:]...)
+			i--
+		}
+	}
+	return x, y, true
+}
+
+// PackAtlas packs images onto square pages, tallest first. Without
+// opts.Array everything must fit on one page.
+func PackAtlas(images []AtlasImage, opts *AtlasOptions) (*Atlas, error) {
+	if opts == nil {
+		opts = &AtlasOptions{}
+	}
+	size := opts.Size
+	if size == 0 {
+		size = 1024
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("atlas: no images")
+	}
+	if opts.Padding < 0 || opts.Extrude < 0 {
+		return nil, fmt.Errorf("atlas: negative padding or extrusion")
+	}
+
+	order := make([]int, len(images))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		bi, bj := images[order[i]].Image.Bounds(), images[order[j]].Image.Bounds()
+		if bi.Dy() != bj.Dy() {
+			return bi.Dy() > bj.Dy()
+		}
+		return bi.Dx() > bj.Dx()
+	})
+
+	a := &Atlas{Size: size, Rects: make([]AtlasRect, len(images))}
+	var pages []*skyline
+	border := opts.Extrude*2 + opts.Padding
+	for _, i := range order {
+		img := images[i]
+		b := img.Image.Bounds()
+		if b.Empty() {
+			return nil, fmt.Errorf("atlas: %s is empty", img.Name)
+		}
+		w, h := b.Dx()+border, b.Dy()+border
+
+		var x, y int
+		layer, ok := 0, false
+		for ; layer < len(pages) && !ok; layer++ {
+			x, y, ok = pages[layer].insert(w, h)
+		}
+		if !ok {
+			if len(pages) > 0 && !opts.Array {
+				return nil, fmt.Errorf("atlas: %s does not fit on a %dx%d page", img.Name, size, size)
+			}
+			pages = append(pages, newSkyline(size, opts.Padding))
+			a.Pages = append(a.Pages, image.NewNRGBA(image.Rect(0, 0, size, size)))
+			if x, y, ok = pages[len(pages)-1].insert(w, h); !ok {
+				return nil, fmt.Errorf("atlas: %s is larger than a %dx%d page", img.Name, size, size)
+			}
+			layer = len(pages)
+		}
+		layer--
+
+		x += opts.Extrude
+		y += opts.Extrude
+		r := image.Rect(x, y, x+b.Dx(), y+b.Dy())
+		blitExtruded(a.Pages[layer], r, img.Image, opts.Extrude)
+
+		s := float32(size)
+		a.Rects[i] = AtlasRect{
+			Name:  img.Name,
+			Layer: layer,
+			X:     x, Y: y, W: b.Dx(), H: b.Dy(),
+			U0: float32(r.Min.X) / s, V0: 1 - float32(r.Max.Y)/s,
+			U1: float32(r.Max.X) / s, V1: 1 - float32(r.Min.Y)/s,
+		}
+	}
+	return a, nil
+}
+
+// blitExtruded draws src into r of dst and repeats its edge texels n texels
+// outward, so filtering and mip levels never pull in a neighbour.
+func blitExtruded(dst *image.NRGBA, r image.Rectangle, src image.Image, n int) {
+	draw.Draw(dst, r, src, src.Bounds().Min, draw.Src)
+	if r.Empty() {
+		return
+	}
+	clamp := func(v, lo, hi int) int {
+		if v < lo {
+			return lo
+		}
+		if v >= hi {
+			return hi - 1
+		}
+		return v
+	}
+	for y := r.Min.Y - n; y < r.Max.Y+n; y++ {
+		for x := r.Min.X - n; x < r.Max.X+n; x++ {
+			if (image.Point{x, y}).In(r) {
+				continue
+			}
+			dst.SetNRGBA(x, y, dst.NRGBAAt(clamp(x, r.Min.X, r.Max.X), clamp(y, r.Min.Y, r.Max.Y)))
+		}
+	}
+}
+
+// LoadAtlasDir packs every PNG, JPEG and GIF in dir, named by file name
+// without extension.
+func LoadAtlasDir(dir string, opts *AtlasOptions) (*Atlas, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []AtlasImage
+	for _, fi := range infos {
+		ext := strings.ToLower(filepath.Ext(fi.Name()))
+		if fi.IsDir() || (ext != ".png" && ext != ".jpg" && ext != ".jpeg" && ext != ".gif") {
+			continue
+		}
+		file, err := os.Open(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		img, _, err := image.Decode(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fi.Name(), err)
+		}
+		images = append(images, AtlasImage{strings.TrimSuffix(fi.Name(), filepath.Ext(fi.Name())), img})
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("%s: no images", dir)
+	}
+	return PackAtlas(images, opts)
+}
+
+// Ktx converts the atlas into a KTX file: a TEXTURE_2D for one page, a
+// TEXTURE_2D_ARRAY with a layer per page otherwise. opts.FlipY is ignored,
+// the pages are stored top row first and flipped by LoadKtx.
+func (a *Atlas) Ktx(opts *ImageOptions) (*KTXFile, error) {
+	o := ImageOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	o.FlipY = false
+
+	var pages []*KTXFile
+	for _, p := range a.Pages {
+		f, err := NewImageKtx(p, &o)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, f)
+	}
+	if len(pages) == 1 {
+		return pages[0], nil
+	}
+
+	h := pages[0].Header
+	h.Arrayelements = uint32(len(pages))
+	var levels [][]byte
+	for i := range pages[0].Levels {
+		var data []byte
+		for _, p := range pages {
+			data = append(data, p.Levels[i].Data...)
+		}
+		levels = append(levels, data)
+	}
+	f, err := NewKtx(h, levels)
+	if err != nil {
+		return nil, err
+	}
+	f.KeyValue = pages[0].KeyValue
+	return f, nil
+}
+
+// WriteJSON writes the rectangles as a JSON object keyed by image name.
+func (a *Atlas) WriteJSON(w io.Writer) error {
+	type rect struct {
+		Layer int        `json:"layer"`
+		Rect  [4]int     `json:"rect"`
+		UV    [4]float32 `json:"uv"`
+	}
+	m := make(map[string]rect, len(a.Rects))
+	for _, r := range a.Rects {
+		m[r.Name] = rect{r.Layer, [4]int{r.X, r.Y, r.W, r.H}, [4]float32{r.U0, r.V0, r.U1, r.V1}}
+	}
+	out := struct {
+		Size   int             `json:"size"`
+		Layers int             `json:"layers"`
+		Rects  map[string]rect `json:"rects"`
+	}{a.Size, len(a.Pages), m}
+
+	d, err := json.MarshalIndent(out, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(d, '\n'))
+	return err
+}
+
+// WriteGo writes the rectangles as a Go source file declaring a
+// map[string]utils.AtlasRect named name in package pkg.
+func (a *Atlas) WriteGo(w io.Writer, pkg, name string) error {
+	rects := append([]AtlasRect(nil), a.Rects...)
+	sort.Slice(rects, func(i, j int) bool { return rects[i].Name < rects[j].Name })
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Code generated by PackAtlas. DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	fmt.Fprintf(&b, "import \"github.com/ginuerzh/gogl/utils\"\n\n")
+	fmt.Fprintf(&b, "var %s = map[string]utils.AtlasRect{\n", name)
+	for _, r := range rects {
+		fmt.Fprintf(&b, "\t%q: {Name: %q, Layer: %d, X: %d, Y: %d, W: %d, H: %d, U0: %v, V0: %v, U1: %v, V1: %v},\n",
+			r.Name, r.Name, r.Layer, r.X, r.Y, r.W, r.H, r.U0, r.V0, r.U1, r.V1)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,110 @@
+// atlas_test.go
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	gl "github.com/chsc/gogl/gl42"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func solid(w, h int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+func TestPackAtlas(t *testing.T) {
+	var images []AtlasImage
+	for i := 0; i < 20; i++ {
+		c := color.NRGBA{uint8(i * 10), 0, 0, 255}
+		images = append(images, AtlasImage{string(rune('a' + i)), solid(5+i%7, 3+i%5, c)})
+	}
+
+	a, err := PackAtlas(images, &AtlasOptions{Size: 64, Padding: 1, Extrude: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Pages) != 1 {
+		t.Fatalf("%d pages", len(a.Pages))
+	}
+
+	// Rectangles, grown by extrusion and padding, never overlap and stay on
+	// the page.
+	for i, r := range a.Rects {
+		ri := image.Rect(r.X-2, r.Y-2, r.X+r.W+3, r.Y+r.H+3)
+		if !ri.In(image.Rect(0, 0, 64, 64)) || r.X < 3 || r.Y < 3 {
+			t.Errorf("%s at %v leaves the page", r.Name, ri)
+		}
+		for _, s := range a.Rects[i+1:] {
+			if ri.Overlaps(image.Rect(s.X-2, s.Y-2, s.X+s.W+3, s.Y+s.H+3)) {
+				t.Errorf("%s overlaps %s", r.Name, s.Name)
+			}
+		}
+		want := images[i].Image.(*image.NRGBA).Pix[0]
+		if got := a.Pages[0].NRGBAAt(r.X-2, r.Y+r.H+1).R; got != want {
+			t.Errorf("%s extruded corner is %d, want %d", r.Name, got, want)
+		}
+		if r.U0 != float32(r.X)/64 || r.V1 != 1-float32(r.Y)/64 || r.V0 != 1-float32(r.Y+r.H)/64 {
+			t.Errorf("%s uv %v %v %v %v", r.Name, r.U0, r.V0, r.U1, r.V1)
+		}
+	}
+
+	if _, err := PackAtlas(append(images, AtlasImage{"big", solid(60, 60, color.NRGBA{})}),
+		&AtlasOptions{Size: 64}); err == nil {
+		t.Error("overflow accepted without Array")
+	}
+}
+
+func TestPackAtlasArray(t *testing.T) {
+	var images []AtlasImage
+	for i := 0; i < 5; i++ {
+		images = append(images, AtlasImage{string(rune('a' + i)), solid(20, 20, color.NRGBA{255, 255, 255, 255})})
+	}
+	a, err := PackAtlas(images, &AtlasOptions{Size: 32, Array: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Pages) != 5 {
+		t.Fatalf("%d pages", len(a.Pages))
+	}
+
+	f, err := a.Ktx(&ImageOptions{Mipmaps: MipBox})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Target != gl.TEXTURE_2D_ARRAY || f.Header.Arrayelements != 5 || len(f.Levels) != 6 {
+		t.Fatalf("target %#x, %d layers, %d levels", f.Target, f.Header.Arrayelements, len(f.Levels))
+	}
+	if errs := f.Validate(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	var js bytes.Buffer
+	if err := a.WriteJSON(&js); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Layers int
+		Rects  map[string]struct{ Layer int }
+	}
+	if err := json.Unmarshal(js.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Layers != 5 || out.Rects["e"].Layer != 4 {
+		t.Errorf("json %s", js.String())
+	}
+
+	var src strings.Builder
+	if err := a.WriteGo(&src, "sprites", "Rects"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(src.String(), `"e": {Name: "e", Layer: 4, X: 0, Y: 0, W: 20, H: 20, U0: 0, V0: 0.375, U1: 0.625, V1: 1}`) {
+		t.Errorf("go source\n%s", src.String())
+	}
+}
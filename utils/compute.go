@@ -0,0 +1,244 @@
+// compute
+package utils
+
+import (
+	"fmt"
+	gl "github.com/chsc/gogl/gl42"
+	gl43 "github.com/chsc/gogl/gl43"
+	"sync"
+	"unsafe"
+)
+
+var (
+	gl43Once sync.Once
+	gl43Err  error
+)
+
+// initGL43 loads the GL 4.3 entry points gl42 lacks, once, on first use.
+func initGL43() error {
+	gl43Once.Do(func() {
+		if err := gl43.Init(); err != nil {
+			gl43Err = fmt.Errorf("compute: OpenGL 4.3 required: %v", err)
+		}
+	})
+	return gl43Err
+}
+
+// ComputeProgram is a linked compute shader and its work group size.
+type ComputeProgram struct {
+	Program   gl.Uint
+	LocalSize [3]int // local_size_x, _y and _z declared by the shader
+}
+
+// NewComputeProgram builds a compute program from a source string or a file,
+// as CompileShaders does for vertex and fragment shaders.
+func NewComputeProgram(shaderType int, src string) (*ComputeProgram, error) {
+	if err := initGL43(); err != nil {
+		return nil, err
+	}
+	b := NewProgramBuilder()
+	if shaderType == ShaderFile {
+		b.File(gl43.COMPUTE_SHADER, src)
+	} else {
+		b.Source(gl43.COMPUTE_SHADER, src)
+	}
+	program, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ComputeProgram{Program: program}
+	var size [3]gl.Int
+	gl.GetProgramiv(program, gl43.COMPUTE_WORK_GROUP_SIZE, &size[0])
+	for i, v := range size {
+		c.LocalSize[i] = int(v)
+	}
+	return c, nil
+}
+
+// Use makes the program current.
+func (c *ComputeProgram) Use() {
+	gl.UseProgram(c.Program)
+}
+
+// BindStorage binds buf to the shader storage block called name, through
+// binding point binding.
+func (c *ComputeProgram) BindStorage(name string, binding int, buf *StorageBuffer) error {
+	cname := gl43.GLString(name)
+	defer gl43.GLStringFree(cname)
+	index := gl43.GetProgramResourceIndex(gl43.Uint(c.Program), gl43.SHADER_STORAGE_BLOCK, cname)
+	if index == gl43.INVALID_INDEX {
+		return fmt.Errorf("compute: no shader storage block %q", name)
+	}
+	gl43.ShaderStorageBlockBinding(gl43.Uint(c.Program), index, gl43.Uint(binding))
+	gl.BindBufferBase(gl43.SHADER_STORAGE_BUFFER, gl.Uint(binding), buf.ID)
+	return nil
+}
+
+// BindImage binds level of tex, all layers, to the image uniform called name
+// through image unit unit. format is the sized format the shader accesses
+// it as, access one of READ_ONLY, WRITE_ONLY or READ_WRITE.
+func (c *ComputeProgram) BindImage(name string, unit int, tex gl.Uint, level int, access, format gl.Enum) error {
+	cname := gl.GLString(name)
+	defer gl.GLStringFree(cname)
+	loc := gl.GetUniformLocation(c.Program, cname)
+	if loc < 0 {
+		return fmt.Errorf("compute: no image uniform %q", name)
+	}
+	gl.UseProgram(c.Program)
+	gl.Uniform1i(loc, gl.Int(unit))
+	gl.BindImageTexture(gl.Uint(unit), tex, gl.Int(level), gl.TRUE, 0, access, format)
+	return nil
+}
+
+// Dispatch runs x*y*z work groups.
+func (c *ComputeProgram) Dispatch(x, y, z int) {
+	gl.UseProgram(c.Program)
+	gl43.DispatchCompute(gl43.Uint(x), gl43.Uint(y), gl43.Uint(z))
+}
+
+// DispatchSize runs enough work groups to cover width*height*depth
+// invocations, rounding up to whole groups. Shaders must check their
+// gl_GlobalInvocationID against the size.
+func (c *ComputeProgram) DispatchSize(width, height, depth int) {
+	g := c.Groups(width, height, depth)
+	c.Dispatch(g[0], g[1], g[2])
+}
+
+// Groups returns the number of work groups DispatchSize runs.
+func (c *ComputeProgram) Groups(width, height, depth int) [3]int {
+	var g [3]int
+	for i, n := range [3]int{width, height, depth} {
+		local := c.LocalSize[i]
+		if local < 1 {
+			local = 1
+		}
+		g[i] = (n + local - 1) / local
+	}
+	return g
+}
+
+// Barrier waits for the writes of previous dispatches to be visible to the
+// accesses in bits, such as gl43.SHADER_STORAGE_BARRIER_BIT or
+// gl.TEXTURE_FETCH_BARRIER_BIT.
+func Barrier(bits gl.Bitfield) {
+	gl.MemoryBarrier(bits)
+}
+
+// Delete deletes the program.
+func (c *ComputeProgram) Delete() {
+	gl.DeleteProgram(c.Program)
+}
+
+// StorageBuffer is a shader storage buffer.
+type StorageBuffer struct {
+	ID   gl.Uint
+	Size int // bytes
+}
+
+// NewStorageBuffer creates a buffer of size bytes, zeroed.
+func NewStorageBuffer(size int, usage gl.Enum) *StorageBuffer {
+	b := &StorageBuffer{Size: size}
+	zero := make([]byte, size)
+	gl.GenBuffers(1, &b.ID)
+	gl.BindBuffer(gl43.SHADER_STORAGE_BUFFER, b.ID)
+	gl.BufferData(gl43.SHADER_STORAGE_BUFFER, gl.Sizeiptr(size), ptr(zero), usage)
+	return b
+}
+
+// NewStorageBufferData creates a buffer holding a copy of data, whose
+// elements must be laid out as the shader's std430 block expects.
+func NewStorageBufferData[T any](data []T, usage gl.Enum) *StorageBuffer {
+	size := len(data) * int(unsafe.Sizeof(*new(T)))
+	b := &StorageBuffer{Size: size}
+	gl.GenBuffers(1, &b.ID)
+	gl.BindBuffer(gl43.SHADER_STORAGE_BUFFER, b.ID)
+	gl.BufferData(gl43.SHADER_STORAGE_BUFFER, gl.Sizeiptr(size), ptr(data), usage)
+	return b
+}
+
+// WriteStorage copies data into b at byte offset.
+func WriteStorage[T any](b *StorageBuffer, offset int, data []T) error {
+	size := len(data) * int(unsafe.Sizeof(*new(T)))
+	if offset < 0 || offset+size > b.Size {
+		return fmt.Errorf("compute: write of %d bytes at %d overruns a %d byte buffer", size, offset, b.Size)
+	}
+	if size == 0 {
+		return nil
+	}
+	gl.BindBuffer(gl43.SHADER_STORAGE_BUFFER, b.ID)
+	gl.BufferSubData(gl43.SHADER_STORAGE_BUFFER, gl.Intptr(offset), gl.Sizeiptr(size), ptr(data))
+	return nil
+}
+
+// ReadStorage fills dst from b at byte offset, after a barrier making shader
+// writes visible.
+func ReadStorage[T any](b *StorageBuffer, offset int, dst []T) error {
+	size := len(dst) * int(unsafe.Sizeof(*new(T)))
+	if offset < 0 || offset+size > b.Size {
+		return fmt.Errorf("compute: read of %d bytes at %d overruns a %d byte buffer", size, offset, b.Size)
+	}
+	if size == 0 {
+		return nil
+	}
+	gl.MemoryBarrier(gl.BUFFER_UPDATE_BARRIER_BIT)
+	gl.BindBuffer(gl43.SHADER_STORAGE_BUFFER, b.ID)
+	gl.GetBufferSubData(gl43.SHADER_STORAGE_BUFFER, gl.Intptr(offset), gl.Sizeiptr(size), ptr(dst))
+	return nil
+}
+
+// ReadImage fills dst with level of the texture bound to target, converted
+// to format and typ, after a barrier making image stores visible. dst must
+// hold the whole level.
+func ReadImage[T any](target gl.Enum, level int, format, typ gl.Enum, dst []T) error {
+	var w, h, d gl.Int
+	gl.GetTexLevelParameteriv(target, gl.Int(level), gl.TEXTURE_WIDTH, &w)
+	gl.GetTexLevelParameteriv(target, gl.Int(level), gl.TEXTURE_HEIGHT, &h)
+	gl.GetTexLevelParameteriv(target, gl.Int(level), gl.TEXTURE_DEPTH, &d)
+	size, err := imageBytes(format, typ, int(w), int(h), int(d))
+	if err != nil {
+		return err
+	}
+	if n := len(dst) * int(unsafe.Sizeof(*new(T))); n < size {
+		return fmt.Errorf("compute: read of a %d byte image into %d bytes", size, n)
+	}
+	gl.MemoryBarrier(gl.TEXTURE_UPDATE_BARRIER_BIT)
+	gl.PixelStorei(gl.PACK_ALIGNMENT, 1)
+	gl.GetTexImage(target, gl.Int(level), format, typ, ptr(dst))
+	return nil
+}
+
+// imageBytes returns the size of a w x h x d image of format and typ with
+// tightly packed rows.
+func imageBytes(format, typ gl.Enum, w, h, d int) (int, error) {
+	pixel := int(typeBytes(uint32(typ)) * formatComponents(uint32(format)))
+	if isPackedType(uint32(typ)) {
+		switch typ {
+		case gl.UNSIGNED_BYTE_3_3_2:
+			pixel = 1
+		case gl.UNSIGNED_INT_8_8_8_8, gl.UNSIGNED_INT_8_8_8_8_REV, gl.UNSIGNED_INT_10_10_10_2,
+			gl.UNSIGNED_INT_2_10_10_10_REV, gl.UNSIGNED_INT_10F_11F_11F_REV, gl.UNSIGNED_INT_5_9_9_9_REV,
+			gl.UNSIGNED_INT_24_8:
+			pixel = 4
+		default:
+			pixel = 2
+		}
+	}
+	if pixel == 0 {
+		return 0, fmt.Errorf("compute: unsupported read format %#x/%#x", uint32(format), uint32(typ))
+	}
+	return pixel * w * h * d, nil
+}
+
+// Delete deletes the buffer.
+func (b *StorageBuffer) Delete() {
+	gl.DeleteBuffers(1, &b.ID)
+}
+
+// ptr returns a pointer to the first element of s, nil if s is empty.
+func ptr[T any](s []T) gl.Pointer {
+	if len(s) == 0 {
+		return nil
+	}
+	return gl.Pointer(&s[0])
+}
@@ -0,0 +1,54 @@
+// compute_test.go
+package utils
+
+import (
+	gl "github.com/chsc/gogl/gl42"
+	"testing"
+)
+
+func TestComputeGroups(t *testing.T) {
+	c := &ComputeProgram{LocalSize: [3]int{64, 1, 1}}
+	if g := c.Groups(1000, 1, 1); g != [3]int{16, 1, 1} {
+		t.Errorf("groups %v", g)
+	}
+	c.LocalSize = [3]int{8, 8, 0}
+	if g := c.Groups(17, 16, 3); g != [3]int{3, 2, 3} {
+		t.Errorf("groups %v", g)
+	}
+}
+
+func TestStorageBounds(t *testing.T) {
+	b := &StorageBuffer{Size: 16}
+	if err := WriteStorage(b, 8, make([]float32, 3)); err == nil {
+		t.Error("write past the end should fail")
+	}
+	if err := ReadStorage(b, -4, make([]uint32, 1)); err == nil {
+		t.Error("negative offset should fail")
+	}
+	if err := ReadStorage(b, 4, make([][4]float32, 1)); err == nil {
+		t.Error("read past the end should fail")
+	}
+	if err := WriteStorage(b, 16, []float32{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImageBytes(t *testing.T) {
+	for _, c := range []struct {
+		format, typ gl.Enum
+		size        int
+	}{
+		{gl.RGBA, gl.FLOAT, 16 * 6},
+		{gl.RED_INTEGER, gl.UNSIGNED_INT, 4 * 6},
+		{gl.RGB, gl.UNSIGNED_BYTE, 3 * 6},
+		{gl.RGBA, gl.UNSIGNED_INT_2_10_10_10_REV, 4 * 6},
+		{gl.RGB, gl.UNSIGNED_SHORT_5_6_5, 2 * 6},
+	} {
+		if n, err := imageBytes(c.format, c.typ, 3, 2, 1); err != nil || n != c.size {
+			t.Errorf("%#x/%#x: %d, %v", c.format, c.typ, n, err)
+		}
+	}
+	if _, err := imageBytes(gl.RGBA, 0x1234, 1, 1, 1); err == nil {
+		t.Error("unknown type accepted")
+	}
+}
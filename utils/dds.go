@@ -0,0 +1,334 @@
+// dds
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	gl "github.com/chsc/gogl/gl42"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	ddsHeaderSize     = 124
+	ddsHeaderDX10Size = 20
+)
+
+const (
+	ddsdMipMapCount = 0x20000
+
+	ddpfAlphaPixels = 0x1
+	ddpfFourCC      = 0x4
+	ddpfRGB         = 0x40
+	ddpfLuminance   = 0x20000
+
+	ddsCaps2Cubemap         = 0x200
+	ddsCaps2CubemapAllFaces = 0xFC00
+	ddsCaps2Volume          = 0x200000
+
+	ddsDimensionTexture1D  = 2
+	ddsDimensionTexture3D  = 4
+	ddsResourceMiscTexCube = 0x4
+)
+
+var (
+	ddsMagic = []byte("DDS ")
+)
+
+type ddsPixelFormat struct {
+	Size        uint32
+	Flags       uint32
+	FourCC      uint32
+	RGBBitCount uint32
+	RBitMask    uint32
+	GBitMask    uint32
+	BBitMask    uint32
+	ABitMask    uint32
+}
+
+type ddsHeader struct {
+	Size              uint32
+	Flags             uint32
+	Height            uint32
+	Width             uint32
+	PitchOrLinearSize uint32
+	Depth             uint32
+	MipMapCount       uint32
+	Reserved1         [11]uint32
+	PixelFormat       ddsPixelFormat
+	Caps              uint32
+	Caps2             uint32
+	Caps3             uint32
+	Caps4             uint32
+	Reserved2         uint32
+}
+
+type ddsHeaderDX10 struct {
+	DxgiFormat        uint32
+	ResourceDimension uint32
+	MiscFlag          uint32
+	ArraySize         uint32
+	MiscFlags2        uint32
+}
+
+func fourCC(s string) uint32 {
+	return binary.LittleEndian.Uint32([]byte(s))
+}
+
+// ddsFourCCFormats maps legacy FourCC codes, including the numeric D3DFMT
+// values used for float formats, to GL formats.
+var ddsFourCCFormats = map[uint32]vkFormatInfo{
+	fourCC("DXT1"): {glCompressedRGBAS3TCDXT1, 0, 0, 1},
+	fourCC("DXT2"): {glCompressedRGBAS3TCDXT3, 0, 0, 1},
+	fourCC("DXT3"): {glCompressedRGBAS3TCDXT3, 0, 0, 1},
+	fourCC("DXT4"): {glCompressedRGBAS3TCDXT5, 0, 0, 1},
+	fourCC("DXT5"): {glCompressedRGBAS3TCDXT5, 0, 0, 1},
+	fourCC("ATI1"): {gl.COMPRESSED_RED_RGTC1, 0, 0, 1},
+	fourCC("BC4U"): {gl.COMPRESSED_RED_RGTC1, 0, 0, 1},
+	fourCC("BC4S"): {gl.COMPRESSED_SIGNED_RED_RGTC1, 0, 0, 1},
+	fourCC("ATI2"): {gl.COMPRESSED_RG_RGTC2, 0, 0, 1},
+	fourCC("BC5U"): {gl.COMPRESSED_RG_RGTC2, 0, 0, 1},
+	fourCC("BC5S"): {gl.COMPRESSED_SIGNED_RG_RGTC2, 0, 0, 1},
+
+	36:  {gl.RGBA16, gl.RGBA, gl.UNSIGNED_SHORT, 2}, // D3DFMT_A16B16G16R16
+	111: {gl.R16F, gl.RED, gl.HALF_FLOAT, 2},        // D3DFMT_R16F
+	112: {gl.RG16F, gl.RG, gl.HALF_FLOAT, 2},        // D3DFMT_G16R16F
+	113: {gl.RGBA16F, gl.RGBA, gl.HALF_FLOAT, 2},    // D3DFMT_A16B16G16R16F
+	114: {gl.R32F, gl.RED, gl.FLOAT, 4},             // D3DFMT_R32F
+	115: {gl.RG32F, gl.RG, gl.FLOAT, 4},             // D3DFMT_G32R32F
+	116: {gl.RGBA32F, gl.RGBA, gl.FLOAT, 4},         // D3DFMT_A32B32G32R32F
+}
+
+// ddsMaskFormats matches uncompressed legacy files by their channel masks.
+var ddsMaskFormats = []struct {
+	bits, r, g, b, a uint32
+	info             vkFormatInfo
+}{
+	{32, 0xff, 0xff00, 0xff0000, 0xff000000, vkFormatInfo{gl.RGBA8, gl.RGBA, gl.UNSIGNED_BYTE, 1}},
+	{32, 0xff0000, 0xff00, 0xff, 0xff000000, vkFormatInfo{gl.RGBA8, gl.BGRA, gl.UNSIGNED_BYTE, 1}},
+	{32, 0xff, 0xff00, 0xff0000, 0, vkFormatInfo{gl.RGB8, gl.RGBA, gl.UNSIGNED_BYTE, 1}},
+	{32, 0xff0000, 0xff00, 0xff, 0, vkFormatInfo{gl.RGB8, gl.BGRA, gl.UNSIGNED_BYTE, 1}},
+	{32, 0x3ff, 0xffc00, 0x3ff00000, 0xc0000000, vkFormatInfo{gl.RGB10_A2, gl.RGBA, gl.UNSIGNED_INT_2_10_10_10_REV, 4}},
+	{32, 0xffff, 0xffff0000, 0, 0, vkFormatInfo{gl.RG16, gl.RG, gl.UNSIGNED_SHORT, 2}},
+	{24, 0xff0000, 0xff00, 0xff, 0, vkFormatInfo{gl.RGB8, gl.BGR, gl.UNSIGNED_BYTE, 1}},
+	{24, 0xff, 0xff00, 0xff0000, 0, vkFormatInfo{gl.RGB8, gl.RGB, gl.UNSIGNED_BYTE, 1}},
+	{16, 0xf800, 0x7e0, 0x1f, 0, vkFormatInfo{gl.RGB565, gl.RGB, gl.UNSIGNED_SHORT_5_6_5, 2}},
+	{16, 0xff, 0, 0, 0xff00, vkFormatInfo{gl.RG8, gl.RG, gl.UNSIGNED_BYTE, 1}},
+	{16, 0xffff, 0, 0, 0, vkFormatInfo{gl.R16, gl.RED, gl.UNSIGNED_SHORT, 2}},
+	{8, 0xff, 0, 0, 0, vkFormatInfo{gl.R8, gl.RED, gl.UNSIGNED_BYTE, 1}},
+}
+
+// dxgiFormats maps the DXGI_FORMAT of DX10 headers to GL formats.
+var dxgiFormats = map[uint32]vkFormatInfo{
+	2:  {gl.RGBA32F, gl.RGBA, gl.FLOAT, 4},
+	6:  {gl.RGB32F, gl.RGB, gl.FLOAT, 4},
+	10: {gl.RGBA16F, gl.RGBA, gl.HALF_FLOAT, 2},
+	11: {gl.RGBA16, gl.RGBA, gl.UNSIGNED_SHORT, 2},
+	16: {gl.RG32F, gl.RG, gl.FLOAT, 4},
+	24: {gl.RGB10_A2, gl.RGBA, gl.UNSIGNED_INT_2_10_10_10_REV, 4},
+	26: {gl.R11F_G11F_B10F, gl.RGB, gl.UNSIGNED_INT_10F_11F_11F_REV, 4},
+	28: {gl.RGBA8, gl.RGBA, gl.UNSIGNED_BYTE, 1},
+	29: {gl.SRGB8_ALPHA8, gl.RGBA, gl.UNSIGNED_BYTE, 1},
+	34: {gl.RG16F, gl.RG, gl.HALF_FLOAT, 2},
+	35: {gl.RG16, gl.RG, gl.UNSIGNED_SHORT, 2},
+	41: {gl.R32F, gl.RED, gl.FLOAT, 4},
+	49: {gl.RG8, gl.RG, gl.UNSIGNED_BYTE, 1},
+	54: {gl.R16F, gl.RED, gl.HALF_FLOAT, 2},
+	56: {gl.R16, gl.RED, gl.UNSIGNED_SHORT, 2},
+	61: {gl.R8, gl.RED, gl.UNSIGNED_BYTE, 1},
+	67: {gl.RGB9_E5, gl.RGB, gl.UNSIGNED_INT_5_9_9_9_REV, 4},
+	87: {gl.RGBA8, gl.BGRA, gl.UNSIGNED_BYTE, 1},
+	88: {gl.RGB8, gl.BGRA, gl.UNSIGNED_BYTE, 1},
+	91: {gl.SRGB8_ALPHA8, gl.BGRA, gl.UNSIGNED_BYTE, 1},
+
+	71: {glCompressedRGBAS3TCDXT1, 0, 0, 1},      // BC1_UNORM
+	72: {glCompressedSRGBAlphaS3TCDXT1, 0, 0, 1}, // BC1_UNORM_SRGB
+	74: {glCompressedRGBAS3TCDXT3, 0, 0, 1},      // BC2_UNORM
+	75: {glCompressedSRGBAlphaS3TCDXT3, 0, 0, 1}, // BC2_UNORM_SRGB
+	77: {glCompressedRGBAS3TCDXT5, 0, 0, 1},      // BC3_UNORM
+	78: {glCompressedSRGBAlphaS3TCDXT5, 0, 0, 1}, // BC3_UNORM_SRGB
+	80: {gl.COMPRESSED_RED_RGTC1, 0, 0, 1},
+	81: {gl.COMPRESSED_SIGNED_RED_RGTC1, 0, 0, 1},
+	83: {gl.COMPRESSED_RG_RGTC2, 0, 0, 1},
+	84: {gl.COMPRESSED_SIGNED_RG_RGTC2, 0, 0, 1},
+	95: {gl.COMPRESSED_RGB_BPTC_UNSIGNED_FLOAT, 0, 0, 1},
+	96: {gl.COMPRESSED_RGB_BPTC_SIGNED_FLOAT, 0, 0, 1},
+	98: {gl.COMPRESSED_RGBA_BPTC_UNORM, 0, 0, 1},
+	99: {gl.COMPRESSED_SRGB_ALPHA_BPTC_UNORM, 0, 0, 1},
+}
+
+func ddsPixelFormatInfo(pf *ddsPixelFormat) (vkFormatInfo, error) {
+	if pf.Flags&ddpfFourCC != 0 {
+		if info, ok := ddsFourCCFormats[pf.FourCC]; ok {
+			return info, nil
+		}
+		return vkFormatInfo{}, ddsErrorf("unsupported FourCC %#x", pf.FourCC)
+	}
+
+	if pf.Flags&(ddpfRGB|ddpfLuminance) != 0 {
+		a := pf.ABitMask
+		if pf.Flags&ddpfAlphaPixels == 0 {
+			a = 0
+		}
+		for _, m := range ddsMaskFormats {
+			if m.bits == pf.RGBBitCount && m.r == pf.RBitMask && m.g == pf.GBitMask && m.b == pf.BBitMask && m.a == a {
+				return m.info, nil
+			}
+		}
+	}
+	return vkFormatInfo{}, ddsErrorf("unsupported %d bit pixel format %#x/%#x/%#x/%#x",
+		pf.RGBBitCount, pf.RBitMask, pf.GBitMask, pf.BBitMask, pf.ABitMask)
+}
+
+// ParseDds decodes a DDS file, legacy or with a DX10 header, into the same
+// representation ParseKtx produces. Rows are tightly packed and, as DDS
+// stores them, top row first, which KTXorientation records.
+func ParseDds(r io.Reader) (*KTXFile, error) {
+	d, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(d) < 4+ddsHeaderSize || !bytes.Equal(d[:4], ddsMagic) {
+		return nil, ddsErrorf("invalid file header")
+	}
+	d = d[4:]
+
+	var dh ddsHeader
+	if err := binary.Read(bytes.NewReader(d[:ddsHeaderSize]), binary.LittleEndian, &dh); err != nil {
+		return nil, err
+	}
+	if dh.Size != ddsHeaderSize {
+		return nil, ddsErrorf("invalid header size %d", dh.Size)
+	}
+	d = d[ddsHeaderSize:]
+
+	h := KTXHeader{
+		Endianness:  0x04030201,
+		Pixelwidth:  dh.Width,
+		Pixelheight: dh.Height,
+		Faces:       1,
+		Miplevels:   1,
+	}
+	if dh.Flags&ddsdMipMapCount != 0 {
+		h.Miplevels = max1(dh.MipMapCount)
+	}
+
+	var info vkFormatInfo
+	if dh.PixelFormat.Flags&ddpfFourCC != 0 && dh.PixelFormat.FourCC == fourCC("DX10") {
+		if len(d) < ddsHeaderDX10Size {
+			return nil, ddsErrorf("file too short")
+		}
+		var dx ddsHeaderDX10
+		if err := binary.Read(bytes.NewReader(d[:ddsHeaderDX10Size]), binary.LittleEndian, &dx); err != nil {
+			return nil, err
+		}
+		d = d[ddsHeaderDX10Size:]
+
+		var ok bool
+		if info, ok = dxgiFormats[dx.DxgiFormat]; !ok {
+			return nil, ddsErrorf("unsupported DXGI format %d", dx.DxgiFormat)
+		}
+		switch dx.ResourceDimension {
+		case ddsDimensionTexture1D:
+			h.Pixelheight = 0
+		case ddsDimensionTexture3D:
+			h.Pixeldepth = dh.Depth
+		}
+		if dx.ArraySize > 1 {
+			h.Arrayelements = dx.ArraySize
+		}
+		if dx.MiscFlag&ddsResourceMiscTexCube != 0 {
+			h.Faces = 6
+		}
+	} else {
+		if info, err = ddsPixelFormatInfo(&dh.PixelFormat); err != nil {
+			return nil, err
+		}
+		if dh.Caps2&ddsCaps2Volume != 0 {
+			h.Pixeldepth = dh.Depth
+		}
+		if dh.Caps2&ddsCaps2Cubemap != 0 {
+			if dh.Caps2&ddsCaps2CubemapAllFaces != ddsCaps2CubemapAllFaces {
+				return nil, ddsErrorf("cube map with missing faces")
+			}
+			h.Faces = 6
+		}
+	}
+
+	h.Gltype = uint32(info.typ)
+	h.Gltypesize = info.typesize
+	h.Glformat = uint32(info.format)
+	h.Glinternalformat = uint32(info.internalformat)
+	h.Glbaseinternalformat = uint32(baseFormat(info.internalformat))
+
+	f := &KTXFile{
+		Header:    h,
+		Target:    guessTarget(&h),
+		Alignment: 1,
+	}
+	if err := checkLayout(&h, uint64(len(d)), ddsErrorf); err != nil {
+		return nil, err
+	}
+
+	// DDS stores every mip chain of a layer (and face) in turn, KTX keeps
+	// every layer of a mip level together.
+	mips := int(h.Mips())
+	levels := make([][]byte, mips)
+	for image := uint32(0); image < h.Layers()*h.FaceCount(); image++ {
+		for i := 0; i < mips; i++ {
+			size := faceSize(&h, i, 1)
+			if size > uint64(len(d)) {
+				return nil, ddsErrorf("image %d level %d truncated", image, i)
+			}
+			levels[i] = append(levels[i], d[:size]...)
+			d = d[size:]
+		}
+	}
+	for i, data := range levels {
+		size := faceSize(&h, i, 1)
+		f.Levels = append(f.Levels, newLevel(&h, i, data, size, size))
+	}
+	f.KeyValue.SetString(orientationKey, "S=r,T=d")
+
+	return f, nil
+}
+
+// LoadDds loads a DDS file into tex the way LoadKtx does, generating a new
+// texture name if tex is 0. Malformed files are reported as *DdsFormatError.
+//
+// Like LoadKtx, it flips uncompressed images to put the bottom row first but
+// leaves cube maps top-down, as GL wants them. Block compressed (BC1-BC7)
+// images can't be flipped and stay top-down as well, so their t coordinate
+// runs the other way.
+func LoadDds(filename string, tex gl.Uint) (gl.Uint, error) {
+	f, err := readDds(filename)
+	if err != nil {
+		return 0, err
+	}
+	return UploadKtx(f, tex)
+}
+
+// readDds parses a DDS file and puts the bottom row of uncompressed 2D, array
+// and 3D images first.
+func readDds(filename string) (*KTXFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	f, err := ParseDds(file)
+	if err != nil {
+		if e, ok := err.(*DdsFormatError); ok {
+			e.File = filename
+		}
+		return nil, err
+	}
+
+	if f.TopDown() {
+		f.FlipRows()
+	}
+	return f, nil
+}
@@ -0,0 +1,147 @@
+// dds_test.go
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	gl "github.com/chsc/gogl/gl42"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// buildDds encodes images, given in DDS order (every mip of a layer or face
+// in turn), behind dh and, if dx is not nil, a DX10 header.
+func buildDds(dh ddsHeader, dx *ddsHeaderDX10, images [][]byte) []byte {
+	dh.Size = ddsHeaderSize
+	dh.PixelFormat.Size = 32
+
+	var buf bytes.Buffer
+	buf.Write(ddsMagic)
+	binary.Write(&buf, binary.LittleEndian, &dh)
+	if dx != nil {
+		binary.Write(&buf, binary.LittleEndian, dx)
+	}
+	for _, img := range images {
+		buf.Write(img)
+	}
+	return buf.Bytes()
+}
+
+func TestParseDdsLegacy(t *testing.T) {
+	// 8x4 DXT1 with mips of 2x1 and 1x1 blocks.
+	dh := ddsHeader{Flags: ddsdMipMapCount, Width: 8, Height: 4, MipMapCount: 3}
+	dh.PixelFormat.Flags = ddpfFourCC
+	dh.PixelFormat.FourCC = fourCC("DXT1")
+	levels := [][]byte{fill(16, 0), fill(8, 50), fill(8, 100)}
+
+	f, err := ParseDds(bytes.NewReader(buildDds(dh, nil, levels)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Target != gl.TEXTURE_2D || !f.Header.IsCompressed() || len(f.Levels) != 3 {
+		t.Fatalf("target %#x, header %+v", f.Target, f.Header)
+	}
+	for i := range levels {
+		if !bytes.Equal(f.Levels[i].Data, levels[i]) {
+			t.Errorf("level %d differs", i)
+		}
+	}
+
+	// 3x1 BGR rows are tightly packed.
+	dh = ddsHeader{Width: 3, Height: 1}
+	dh.PixelFormat = ddsPixelFormat{Flags: ddpfRGB, RGBBitCount: 24, RBitMask: 0xff0000, GBitMask: 0xff00, BBitMask: 0xff}
+	f, err = ParseDds(bytes.NewReader(buildDds(dh, nil, [][]byte{fill(9, 0)})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Header.Glformat != gl.BGR || f.Alignment != 1 || len(f.Levels[0].Data) != 9 || !f.TopDown() {
+		t.Errorf("header %+v alignment %d", f.Header, f.Alignment)
+	}
+}
+
+func TestParseDdsDX10(t *testing.T) {
+	// Two BC7 cubes of 4x4 with two mips, stored cube by cube, face by face.
+	dh := ddsHeader{Flags: ddsdMipMapCount, Width: 4, Height: 4, MipMapCount: 2}
+	dh.PixelFormat.Flags = ddpfFourCC
+	dh.PixelFormat.FourCC = fourCC("DX10")
+	dx := &ddsHeaderDX10{DxgiFormat: 98, ResourceDimension: 3, MiscFlag: ddsResourceMiscTexCube, ArraySize: 2}
+
+	var images [][]byte
+	for i := 0; i < 12*2; i++ {
+		images = append(images, fill(16, byte(i)))
+	}
+
+	f, err := ParseDds(bytes.NewReader(buildDds(dh, dx, images)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Target != gl.TEXTURE_CUBE_MAP_ARRAY || f.Header.Glinternalformat != gl.COMPRESSED_RGBA_BPTC_UNORM {
+		t.Fatalf("target %#x, header %+v", f.Target, f.Header)
+	}
+	for layer := 0; layer < 2; layer++ {
+		for face := 0; face < 6; face++ {
+			for level := 0; level < 2; level++ {
+				want := images[(layer*6+face)*2+level]
+				if img := f.Image(level, layer, face); img == nil || !bytes.Equal(img.Data, want) {
+					t.Errorf("layer %d face %d level %d differs", layer, face, level)
+				}
+			}
+		}
+	}
+}
+
+func TestReadDdsOrientation(t *testing.T) {
+	dh := ddsHeader{Width: 1, Height: 2}
+	dh.PixelFormat = ddsPixelFormat{Flags: ddpfRGB | ddpfAlphaPixels, RGBBitCount: 32,
+		RBitMask: 0xff, GBitMask: 0xff00, BBitMask: 0xff0000, ABitMask: 0xff000000}
+	name := filepath.Join(t.TempDir(), "t.dds")
+
+	if err := ioutil.WriteFile(name, buildDds(dh, nil, [][]byte{fill(8, 0)}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := readDds(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := f.Levels[0].Data; f.TopDown() || !bytes.Equal(d[:4], fill(4, 4)) {
+		t.Errorf("2D rows %v", d)
+	}
+
+	dh.Width, dh.Caps2 = 2, ddsCaps2Cubemap|ddsCaps2CubemapAllFaces
+	if err := ioutil.WriteFile(name, buildDds(dh, nil, [][]byte{fill(6*16, 0)}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = readDds(name); err != nil {
+		t.Fatal(err)
+	}
+	if !f.TopDown() || !bytes.Equal(f.Levels[0].Data, fill(6*16, 0)) {
+		t.Error("cube map flipped")
+	}
+}
+
+func TestParseDdsErrors(t *testing.T) {
+	if _, err := ParseDds(bytes.NewReader([]byte("DDS "))); err == nil {
+		t.Error("short file should fail")
+	}
+
+	dh := ddsHeader{Width: 4, Height: 4}
+	dh.PixelFormat.Flags = ddpfFourCC
+	dh.PixelFormat.FourCC = fourCC("DXT1")
+	d := buildDds(dh, nil, [][]byte{fill(8, 0)})
+	if _, err := ParseDds(bytes.NewReader(d[:len(d)-1])); err == nil {
+		t.Error("truncated file should fail")
+	}
+
+	dh.PixelFormat.FourCC = fourCC("ETC9")
+	_, err := ParseDds(bytes.NewReader(buildDds(dh, nil, [][]byte{fill(8, 0)})))
+	if _, ok := err.(*DdsFormatError); !ok {
+		t.Errorf("unknown FourCC: %v", err)
+	}
+
+	dh.PixelFormat.FourCC = fourCC("DXT1")
+	dh.Caps2 = ddsCaps2Cubemap | 0x400
+	if _, err := ParseDds(bytes.NewReader(buildDds(dh, nil, [][]byte{fill(8, 0)}))); err == nil {
+		t.Error("partial cube map should fail")
+	}
+}
@@ -0,0 +1,353 @@
+// envmap
+package utils
+
+import (
+	gl "github.com/chsc/gogl/gl42"
+	"math"
+)
+
+// CubeMap is a floating-point cube map. Levels[i] holds the six faces of mip
+// level i in GL order (+X, -X, +Y, -Y, +Z, -Z), laid out as GL expects them,
+// so the first row of a face is t = 0.
+type CubeMap struct {
+	Levels [][6]*FloatImage
+}
+
+type vec3 [3]float64
+
+func (a vec3) add(b vec3) vec3      { return vec3{a[0] + b[0], a[1] + b[1], a[2] + b[2]} }
+func (a vec3) scale(s float64) vec3 { return vec3{a[0] * s, a[1] * s, a[2] * s} }
+func (a vec3) dot(b vec3) float64   { return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] }
+
+func (a vec3) cross(b vec3) vec3 {
+	return vec3{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func (a vec3) normalize() vec3 {
+	return a.scale(1 / math.Sqrt(a.dot(a)))
+}
+
+// cubeDir returns the direction through texel (x, y) of a face size texels
+// wide, as GL maps cube map coordinates to faces.
+func cubeDir(face, x, y, size int) vec3 {
+	sc := 2*(float64(x)+0.5)/float64(size) - 1
+	tc := 2*(float64(y)+0.5)/float64(size) - 1
+
+	var d vec3
+	switch face {
+	case 0:
+		d = vec3{1, -tc, -sc}
+	case 1:
+		d = vec3{-1, -tc, sc}
+	case 2:
+		d = vec3{sc, 1, tc}
+	case 3:
+		d = vec3{sc, -1, -tc}
+	case 4:
+		d = vec3{sc, -tc, 1}
+	case 5:
+		d = vec3{-sc, -tc, -1}
+	}
+	return d.normalize()
+}
+
+// cubeFace returns the face d points at and the face coordinates in [0, 1].
+func cubeFace(d vec3) (face int, s, t float64) {
+	ax, ay, az := math.Abs(d[0]), math.Abs(d[1]), math.Abs(d[2])
+
+	var sc, tc, ma float64
+	switch {
+	case ax >= ay && ax >= az:
+		ma = ax
+		if d[0] > 0 {
+			face, sc, tc = 0, -d[2], -d[1]
+		} else {
+			face, sc, tc = 1, d[2], -d[1]
+		}
+	case ay >= az:
+		ma = ay
+		if d[1] > 0 {
+			face, sc, tc = 2, d[0], d[2]
+		} else {
+			face, sc, tc = 3, d[0], -d[2]
+		}
+	default:
+		ma = az
+		if d[2] > 0 {
+			face, sc, tc = 4, d[0], -d[1]
+		} else {
+			face, sc, tc = 5, -d[0], -d[1]
+		}
+	}
+	return face, (sc/ma + 1) / 2, (tc/ma + 1) / 2
+}
+
+// bilinear samples m at (u, v) in [0, 1], wrapping u if wrapU is set and
+// clamping otherwise.
+func (m *FloatImage) bilinear(u, v float64, wrapU bool) vec3 {
+	x := u*float64(m.Width) - 0.5
+	y := v*float64(m.Height) - 0.5
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+
+	texel := func(x, y int) vec3 {
+		if wrapU {
+			x = ((x % m.Width) + m.Width) % m.Width
+		} else if x < 0 {
+			x = 0
+		} else if x >= m.Width {
+			x = m.Width - 1
+		}
+		if y < 0 {
+			y = 0
+		} else if y >= m.Height {
+			y = m.Height - 1
+		}
+		p := m.Pix[(y*m.Width+x)*4:]
+		return vec3{float64(p[0]), float64(p[1]), float64(p[2])}
+	}
+
+	ix, iy := int(x0), int(y0)
+	top := texel(ix, iy).scale(1 - fx).add(texel(ix+1, iy).scale(fx))
+	bottom := texel(ix, iy+1).scale(1 - fx).add(texel(ix+1, iy+1).scale(fx))
+	return top.scale(1 - fy).add(bottom.scale(fy))
+}
+
+func (m *FloatImage) set(x, y int, c vec3) {
+	p := m.Pix[(y*m.Width+x)*4:]
+	p[0], p[1], p[2], p[3] = float32(c[0]), float32(c[1]), float32(c[2]), 1
+}
+
+// newCubeLevel fills six size x size faces with fn of each texel direction.
+func newCubeLevel(size int, fn func(d vec3) vec3) [6]*FloatImage {
+	var faces [6]*FloatImage
+	for f := range faces {
+		faces[f] = NewFloatImage(size, size)
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				faces[f].set(x, y, fn(cubeDir(f, x, y, size)))
+			}
+		}
+	}
+	return faces
+}
+
+// EquirectToCube resamples an equirectangular panorama, +Y up with -Z at
+// its center, into a cube map with faces size texels wide.
+func EquirectToCube(img *FloatImage, size int) *CubeMap {
+	level := newCubeLevel(size, func(d vec3) vec3 {
+		u := 0.5 + math.Atan2(d[0], -d[2])/(2*math.Pi)
+		v := math.Acos(math.Max(-1, math.Min(1, d[1]))) / math.Pi
+		return img.bilinear(u, v, true)
+	})
+	return &CubeMap{Levels: [][6]*FloatImage{level}}
+}
+
+// Size returns the width of the faces of the base level.
+func (c *CubeMap) Size() int {
+	return c.Levels[0][0].Width
+}
+
+// sample returns the bilinearly filtered color of mip level in direction d.
+func (c *CubeMap) sample(d vec3, level int) vec3 {
+	face, s, t := cubeFace(d)
+	return c.Levels[level][face].bilinear(s, t, false)
+}
+
+// withMips returns c with a box filtered mip chain below its base level.
+func (c *CubeMap) withMips() *CubeMap {
+	var chains [6][]*FloatImage
+	for f, face := range c.Levels[0] {
+		chains[f] = face.mipChain(boxKernel, 0.5)
+	}
+	m := &CubeMap{}
+	for i := range chains[0] {
+		var level [6]*FloatImage
+		for f := range level {
+			level[f] = chains[f][i]
+		}
+		m.Levels = append(m.Levels, level)
+	}
+	return m
+}
+
+func radicalInverse(i uint32) float64 {
+	i = (i << 16) | (i >> 16)
+	i = ((i & 0x55555555) << 1) | ((i & 0xAAAAAAAA) >> 1)
+	i = ((i & 0x33333333) << 2) | ((i & 0xCCCCCCCC) >> 2)
+	i = ((i & 0x0F0F0F0F) << 4) | ((i & 0xF0F0F0F0) >> 4)
+	i = ((i & 0x00FF00FF) << 8) | ((i & 0xFF00FF00) >> 8)
+	return float64(i) / (1 << 32)
+}
+
+// PrefilterGGX builds a specular cube map of levels mips, stopping at 1x1
+// faces, from the base level of c. Mip i is convolved with the GGX lobe of
+// roughness i/(levels-1) under the N = V = R assumption. samples importance
+// samples are taken per texel, read from a mip of c chosen by their solid
+// angle to avoid aliasing.
+func PrefilterGGX(c *CubeMap, levels, samples int) *CubeMap {
+	src := c.withMips()
+	size := c.Size()
+	if levels > len(src.Levels) {
+		levels = len(src.Levels)
+	}
+	texelAngle := 4 * math.Pi / (6 * float64(size*size))
+
+	out := &CubeMap{Levels: [][6]*FloatImage{c.Levels[0]}}
+	for l := 1; l < levels; l++ {
+		roughness := float64(l) / float64(levels-1)
+		a := roughness * roughness
+		faceSize := size >> uint(l)
+
+		out.Levels = append(out.Levels, newCubeLevel(faceSize, func(n vec3) vec3 {
+			up := vec3{0, 0, 1}
+			if math.Abs(n[2]) > 0.999 {
+				up = vec3{1, 0, 0}
+			}
+			tx := up.cross(n).normalize()
+			ty := n.cross(tx)
+
+			var sum vec3
+			var weight float64
+			for i := 0; i < samples; i++ {
+				xi1, xi2 := float64(i)/float64(samples), radicalInverse(uint32(i))
+				phi := 2 * math.Pi * xi1
+				cosTheta := math.Sqrt((1 - xi2) / (1 + (a*a-1)*xi2))
+				sinTheta := math.Sqrt(1 - cosTheta*cosTheta)
+				h := tx.scale(sinTheta * math.Cos(phi)).add(ty.scale(sinTheta * math.Sin(phi))).add(n.scale(cosTheta))
+				ld := h.scale(2 * n.dot(h)).add(n.scale(-1))
+
+				nl := n.dot(ld)
+				if nl <= 0 {
+					continue
+				}
+
+				// With N = V the pdf of ld is D(h) / 4.
+				dd := cosTheta*cosTheta*(a*a-1) + 1
+				pdf := a * a / (math.Pi * dd * dd) / 4
+				sampleAngle := 1 / (float64(samples)*pdf + 1e-6)
+				mip := math.Max(0, 0.5*math.Log2(sampleAngle/texelAngle)+1)
+				mip = math.Min(mip, float64(len(src.Levels)-1))
+
+				lo := int(mip)
+				col := src.sample(ld, lo)
+				if lo+1 < len(src.Levels) {
+					f := mip - float64(lo)
+					col = col.scale(1 - f).add(src.sample(ld, lo+1).scale(f))
+				}
+				sum = sum.add(col.scale(nl))
+				weight += nl
+			}
+			if weight == 0 {
+				return src.sample(n, 0)
+			}
+			return sum.scale(1 / weight)
+		}))
+	}
+	return out
+}
+
+// SH9 holds the RGB coefficients of an order 2 spherical harmonic.
+type SH9 [9][3]float32
+
+func shBasis(d vec3) [9]float64 {
+	x, y, z := d[0], d[1], d[2]
+	return [9]float64{
+		0.282095,
+		0.488603 * y,
+		0.488603 * z,
+		0.488603 * x,
+		1.092548 * x * y,
+		1.092548 * y * z,
+		0.315392 * (3*z*z - 1),
+		1.092548 * x * z,
+		0.546274 * (x*x - y*y),
+	}
+}
+
+// IrradianceSH projects the base level of c onto spherical harmonics and
+// convolves it with the clamped cosine lobe. Evaluating the result in
+// direction n gives the irradiance at a surface facing n divided by pi,
+// the outgoing radiance of a white Lambertian surface.
+func IrradianceSH(c *CubeMap) SH9 {
+	var acc [9]vec3
+	var total float64
+	size := c.Size()
+
+	for f, face := range c.Levels[0] {
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				sc := 2*(float64(x)+0.5)/float64(size) - 1
+				tc := 2*(float64(y)+0.5)/float64(size) - 1
+				dw := 1 / math.Pow(1+sc*sc+tc*tc, 1.5)
+				total += dw
+
+				p := face.Pix[(y*size+x)*4:]
+				col := vec3{float64(p[0]), float64(p[1]), float64(p[2])}
+				for i, b := range shBasis(cubeDir(f, x, y, size)) {
+					acc[i] = acc[i].add(col.scale(b * dw))
+				}
+			}
+		}
+	}
+
+	// Cosine lobe band factors, divided by pi.
+	band := [9]float64{1, 2.0 / 3, 2.0 / 3, 2.0 / 3, 0.25, 0.25, 0.25, 0.25, 0.25}
+	norm := 4 * math.Pi / total
+
+	var sh SH9
+	for i := range sh {
+		for ch := 0; ch < 3; ch++ {
+			sh[i][ch] = float32(acc[i][ch] * norm * band[i])
+		}
+	}
+	return sh
+}
+
+// eval returns the value of sh in direction d.
+func (sh *SH9) eval(d vec3) vec3 {
+	var c vec3
+	for i, b := range shBasis(d) {
+		c = c.add(vec3{float64(sh[i][0]), float64(sh[i][1]), float64(sh[i][2])}.scale(b))
+	}
+	return c
+}
+
+// IrradianceCube renders sh into a single level cube map with faces size
+// texels wide, for shaders that prefer a texture lookup to evaluating SH.
+func IrradianceCube(sh SH9, size int) *CubeMap {
+	return &CubeMap{Levels: [][6]*FloatImage{newCubeLevel(size, sh.eval)}}
+}
+
+// Ktx converts c into an RGBA16F (half) or RGBA32F TEXTURE_CUBE_MAP KTX file
+// with every mip level of c.
+func (c *CubeMap) Ktx(half bool) (*KTXFile, error) {
+	size := uint32(c.Size())
+	h := KTXHeader{
+		Gltype:           gl.FLOAT,
+		Glformat:         gl.RGBA,
+		Glinternalformat: gl.RGBA32F,
+		Pixelwidth:       size,
+		Pixelheight:      size,
+		Faces:            6,
+		Miplevels:        uint32(len(c.Levels)),
+	}
+	if half {
+		h.Gltype = gl.HALF_FLOAT
+		h.Glinternalformat = gl.RGBA16F
+	}
+
+	var levels [][]byte
+	for _, faces := range c.Levels {
+		var data []byte
+		for _, face := range faces {
+			if half {
+				data = append(data, HalfBytes(face.Pix)...)
+			} else {
+				data = append(data, Float32Bytes(face.Pix)...)
+			}
+		}
+		levels = append(levels, data)
+	}
+	return NewKtx(h, levels)
+}
@@ -0,0 +1,108 @@
+// envmap_test.go
+package utils
+
+import (
+	"bytes"
+	gl "github.com/chsc/gogl/gl42"
+	"math"
+	"testing"
+)
+
+// skyImage is an equirectangular image, red above the horizon and blue
+// below it.
+func skyImage(w, h int) *FloatImage {
+	img := NewFloatImage(w, h)
+	for y := 0; y < h; y++ {
+		c := vec3{1, 0, 0}
+		if y >= h/2 {
+			c = vec3{0, 0, 1}
+		}
+		for x := 0; x < w; x++ {
+			img.set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestCubeFace(t *testing.T) {
+	for f := 0; f < 6; f++ {
+		for _, xy := range [][2]int{{0, 0}, {3, 1}, {7, 7}} {
+			face, s, tc := cubeFace(cubeDir(f, xy[0], xy[1], 8))
+			x, y := int(s*8), int(tc*8)
+			if face != f || x != xy[0] || y != xy[1] {
+				t.Errorf("face %d texel %v maps back to face %d texel %d,%d", f, xy, face, x, y)
+			}
+		}
+	}
+}
+
+func TestEquirectToCube(t *testing.T) {
+	c := EquirectToCube(skyImage(64, 32), 8)
+	if c.Size() != 8 {
+		t.Fatalf("size = %d", c.Size())
+	}
+	if p := c.Levels[0][2].Pix[:3]; p[0] != 1 || p[2] != 0 {
+		t.Errorf("+Y = %v, want red", p)
+	}
+	if p := c.Levels[0][3].Pix[:3]; p[0] != 0 || p[2] != 1 {
+		t.Errorf("-Y = %v, want blue", p)
+	}
+	// Rows of the side faces start at the top.
+	side := c.Levels[0][4]
+	if top, bottom := side.Pix[0], side.Pix[7*8*4]; top != 1 || bottom != 0 {
+		t.Errorf("+Z top %v bottom %v", top, bottom)
+	}
+}
+
+func TestPrefilterGGX(t *testing.T) {
+	// A constant environment stays constant at every roughness.
+	img := NewFloatImage(32, 16)
+	for i := range img.Pix {
+		img.Pix[i] = 0.5
+	}
+	c := PrefilterGGX(EquirectToCube(img, 16), 10, 64)
+	if len(c.Levels) != 5 || c.Levels[4][0].Width != 1 {
+		t.Fatalf("%d levels", len(c.Levels))
+	}
+	for l, faces := range c.Levels {
+		for f, face := range faces {
+			if v := face.Pix[0]; math.Abs(float64(v)-0.5) > 1e-3 {
+				t.Errorf("level %d face %d = %v", l, f, v)
+			}
+		}
+	}
+
+	f, err := c.Ktx(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := roundTrip(t, f)
+	if g.Target != gl.TEXTURE_CUBE_MAP || len(g.Levels) != 5 || g.TopDown() {
+		t.Fatalf("target %#x with %d levels", g.Target, len(g.Levels))
+	}
+	for i := range f.Levels {
+		if !bytes.Equal(f.Levels[i].Data, g.Levels[i].Data) {
+			t.Errorf("level %d differs", i)
+		}
+	}
+}
+
+func TestIrradianceSH(t *testing.T) {
+	sh := IrradianceSH(EquirectToCube(skyImage(64, 32), 16))
+
+	// Facing straight up the red sky fills the hemisphere, sideways it is
+	// half red and half blue.
+	up := sh.eval(vec3{0, 1, 0})
+	side := sh.eval(vec3{1, 0, 0})
+	if up[0] < 0.9 || up[2] > 0.1 {
+		t.Errorf("up = %v, want ~red", up)
+	}
+	if math.Abs(side[0]-0.5) > 0.05 || math.Abs(side[2]-0.5) > 0.05 {
+		t.Errorf("side = %v, want half red, half blue", side)
+	}
+
+	c := IrradianceCube(sh, 4)
+	if len(c.Levels) != 1 || c.Size() != 4 {
+		t.Errorf("irradiance cube %d levels of %d", len(c.Levels), c.Size())
+	}
+}
@@ -0,0 +1,172 @@
+// errors
+package utils
+
+import (
+	"fmt"
+	gl "github.com/chsc/gogl/gl42"
+	gl43 "github.com/chsc/gogl/gl43"
+)
+
+// KtxFormatError reports a KTX file that is malformed or uses a feature the
+// loader does not support.
+type KtxFormatError struct {
+	File string
+	Msg  string
+}
+
+func (e *KtxFormatError) Error() string {
+	if e.File != "" {
+		return "ktx: " + e.File + ": " + e.Msg
+	}
+	return "ktx: " + e.Msg
+}
+
+func ktxErrorf(format string, a ...interface{}) error {
+	return &KtxFormatError{Msg: fmt.Sprintf(format, a...)}
+}
+
+// DdsFormatError reports a DDS file that is malformed or uses a feature the
+// loader does not support.
+type DdsFormatError struct {
+	File string
+	Msg  string
+}
+
+func (e *DdsFormatError) Error() string {
+	if e.File != "" {
+		return "dds: " + e.File + ": " + e.Msg
+	}
+	return "dds: " + e.Msg
+}
+
+func ddsErrorf(format string, a ...interface{}) error {
+	return &DdsFormatError{Msg: fmt.Sprintf(format, a...)}
+}
+
+// HdrFormatError reports a Radiance HDR file that is malformed or uses a
+// feature the importer does not support.
+type HdrFormatError struct {
+	File string
+	Msg  string
+}
+
+func (e *HdrFormatError) Error() string {
+	if e.File != "" {
+		return "hdr: " + e.File + ": " + e.Msg
+	}
+	return "hdr: " + e.Msg
+}
+
+func hdrErrorf(format string, a ...interface{}) error {
+	return &HdrFormatError{Msg: fmt.Sprintf(format, a...)}
+}
+
+// ExrFormatError reports an OpenEXR file that is malformed or uses a
+// feature the importer does not support.
+type ExrFormatError struct {
+	File string
+	Msg  string
+}
+
+func (e *ExrFormatError) Error() string {
+	if e.File != "" {
+		return "exr: " + e.File + ": " + e.Msg
+	}
+	return "exr: " + e.Msg
+}
+
+func exrErrorf(format string, a ...interface{}) error {
+	return &ExrFormatError{Msg: fmt.Sprintf(format, a...)}
+}
+
+// ShaderCompileError carries the info log of a shader stage that failed to
+// compile.
+type ShaderCompileError struct {
+	Stage gl.Enum
+	File  string
+	Log   string
+}
+
+func (e *ShaderCompileError) Error() string {
+	name := StageName(e.Stage)
+	if e.File != "" {
+		name += " (" + e.File + ")"
+	}
+	return fmt.Sprintf("%s shader: compile failed: %s", name, e.Log)
+}
+
+// ShaderIncludeError reports a bad #include or other directive found by the
+// Preprocessor.
+type ShaderIncludeError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *ShaderIncludeError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("glsl: %s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	return "glsl: " + e.File + ": " + e.Msg
+}
+
+// ProgramLinkError carries the info log of a program that failed to link.
+type ProgramLinkError struct {
+	Log string
+}
+
+func (e *ProgramLinkError) Error() string {
+	return "program: link failed: " + e.Log
+}
+
+// PipelineValidateError carries the info log of a program pipeline that
+// failed validation.
+type PipelineValidateError struct {
+	Log string
+}
+
+func (e *PipelineValidateError) Error() string {
+	return "pipeline: validation failed: " + e.Log
+}
+
+// UniformError reports a value Program.Set can't give a uniform.
+type UniformError struct {
+	Name string
+	Msg  string
+}
+
+func (e *UniformError) Error() string {
+	return "uniform " + e.Name + ": " + e.Msg
+}
+
+// GlfwError reports a failure to set up the window or the GL context.
+type GlfwError struct {
+	Msg string
+	Err error
+}
+
+func (e *GlfwError) Error() string {
+	if e.Err != nil {
+		return "glfw: " + e.Msg + ": " + e.Err.Error()
+	}
+	return "glfw: " + e.Msg
+}
+
+// StageName returns the GLSL name of a shader stage.
+func StageName(stage gl.Enum) string {
+	switch stage {
+	case gl.VERTEX_SHADER:
+		return "vertex"
+	case gl.TESS_CONTROL_SHADER:
+		return "tess control"
+	case gl.TESS_EVALUATION_SHADER:
+		return "tess evaluation"
+	case gl.GEOMETRY_SHADER:
+		return "geometry"
+	case gl.FRAGMENT_SHADER:
+		return "fragment"
+	case gl43.COMPUTE_SHADER:
+		return "compute"
+	}
+	return fmt.Sprintf("stage %#x", uint32(stage))
+}
@@ -0,0 +1,322 @@
+// exr
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+)
+
+const (
+	exrMagic = 20000630
+
+	exrFlagTiled     = 0x200
+	exrFlagNonImage  = 0x800
+	exrFlagMultipart = 0x1000
+
+	exrCompressionNone = 0
+	exrCompressionZIPS = 2
+	exrCompressionZIP  = 3
+
+	exrPixelUint  = 0
+	exrPixelHalf  = 1
+	exrPixelFloat = 2
+)
+
+type exrChannel struct {
+	name      string
+	pixelType int32
+	xSampling int32
+	ySampling int32
+}
+
+func (c *exrChannel) size() int {
+	if c.pixelType == exrPixelHalf {
+		return 2
+	}
+	return 4
+}
+
+// exrReader walks the little-endian fields of an OpenEXR file.
+type exrReader struct {
+	d   []byte
+	off int
+	err error
+}
+
+func (r *exrReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.d)-r.off {
+		r.err = exrErrorf("file truncated")
+		return nil
+	}
+	b := r.d[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *exrReader) int32() int32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.LittleEndian.Uint32(b))
+}
+
+func (r *exrReader) uint64() uint64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (r *exrReader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+	n := bytes.IndexByte(r.d[r.off:], 0)
+	if n < 0 {
+		r.err = exrErrorf("unterminated string")
+		return ""
+	}
+	s := string(r.d[r.off : r.off+n])
+	r.off += n + 1
+	return s
+}
+
+func parseExrChannels(d []byte) ([]exrChannel, error) {
+	r := &exrReader{d: d}
+	var chs []exrChannel
+	for {
+		name := r.cstring()
+		if r.err != nil || name == "" {
+			break
+		}
+		c := exrChannel{name: name, pixelType: r.int32()}
+		r.bytes(4) // pLinear and reserved
+		c.xSampling, c.ySampling = r.int32(), r.int32()
+		if c.pixelType < exrPixelUint || c.pixelType > exrPixelFloat {
+			return nil, exrErrorf("channel %s has unknown pixel type %d", name, c.pixelType)
+		}
+		if c.xSampling != 1 || c.ySampling != 1 {
+			return nil, exrErrorf("channel %s is subsampled", name)
+		}
+		chs = append(chs, c)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	// Scanline data stores channels in alphabetical order.
+	sort.Slice(chs, func(i, j int) bool { return chs[i].name < chs[j].name })
+	return chs, nil
+}
+
+// DecodeExr decodes a single part scanline OpenEXR image, uncompressed or
+// ZIP compressed. R, G, B and A channels are used, Y alone is taken as grey
+// and a missing alpha is 1.
+func DecodeExr(rd io.Reader) (*FloatImage, error) {
+	d, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &exrReader{d: d}
+	if r.int32() != exrMagic {
+		return nil, exrErrorf("invalid file header")
+	}
+	version := r.int32()
+	if version&0xff != 2 {
+		return nil, exrErrorf("unsupported version %d", version&0xff)
+	}
+	if version&(exrFlagTiled|exrFlagNonImage|exrFlagMultipart) != 0 {
+		return nil, exrErrorf("only single part scanline files are supported")
+	}
+
+	var chs []exrChannel
+	var compression byte
+	var window [4]int32
+	var haveWindow bool
+	for {
+		name := r.cstring()
+		if r.err != nil || name == "" {
+			break
+		}
+		r.cstring() // type
+		size := r.int32()
+		value := r.bytes(int(size))
+		if r.err != nil {
+			break
+		}
+
+		switch name {
+		case "channels":
+			if chs, err = parseExrChannels(value); err != nil {
+				return nil, err
+			}
+		case "compression":
+			if len(value) != 1 {
+				return nil, exrErrorf("bad compression attribute")
+			}
+			compression = value[0]
+		case "dataWindow":
+			if len(value) != 16 {
+				return nil, exrErrorf("bad dataWindow attribute")
+			}
+			for i := range window {
+				window[i] = int32(binary.LittleEndian.Uint32(value[i*4:]))
+			}
+			haveWindow = true
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(chs) == 0 || !haveWindow {
+		return nil, exrErrorf("missing channels or dataWindow")
+	}
+
+	linesPerChunk := 1
+	switch compression {
+	case exrCompressionNone, exrCompressionZIPS:
+	case exrCompressionZIP:
+		linesPerChunk = 16
+	default:
+		return nil, exrErrorf("unsupported compression %d", compression)
+	}
+
+	width := int64(window[2]) - int64(window[0]) + 1
+	height := int64(window[3]) - int64(window[1]) + 1
+	if width <= 0 || height <= 0 || width*height > 1<<28 {
+		return nil, exrErrorf("invalid dataWindow %v", window)
+	}
+
+	lineSize := 0
+	for i := range chs {
+		lineSize += chs[i].size() * int(width)
+	}
+
+	// Channel offsets into the RGBA texel, -1 for channels we drop.
+	dst := make([]int, len(chs))
+	grey := -1
+	hasAlpha := false
+	for i, c := range chs {
+		dst[i] = -1
+		switch c.name {
+		case "R":
+			dst[i] = 0
+		case "G":
+			dst[i] = 1
+		case "B":
+			dst[i] = 2
+		case "A":
+			dst[i] = 3
+			hasAlpha = true
+		case "Y":
+			grey = i
+		}
+	}
+
+	img := NewFloatImage(int(width), int(height))
+	chunks := (int(height) + linesPerChunk - 1) / linesPerChunk
+	offsets := make([]uint64, chunks)
+	for i := range offsets {
+		offsets[i] = r.uint64()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	for _, off := range offsets {
+		if off > uint64(len(d)) {
+			return nil, exrErrorf("chunk offset out of range")
+		}
+		cr := &exrReader{d: d, off: int(off)}
+		y := int64(cr.int32()) - int64(window[1])
+		size := cr.int32()
+		data := cr.bytes(int(size))
+		if cr.err != nil {
+			return nil, cr.err
+		}
+		if y < 0 || y >= height || y%int64(linesPerChunk) != 0 {
+			return nil, exrErrorf("chunk at line %d out of range", y)
+		}
+
+		lines := int(height - y)
+		if lines > linesPerChunk {
+			lines = linesPerChunk
+		}
+		want := lines * lineSize
+		if compression != exrCompressionNone && len(data) < want {
+			if data, err = inflateExr(data, want); err != nil {
+				return nil, err
+			}
+		}
+		if len(data) != want {
+			return nil, exrErrorf("chunk at line %d is %d bytes, want %d", y, len(data), want)
+		}
+
+		for l := 0; l < lines; l++ {
+			pix := img.Pix[(int(y)+l)*int(width)*4:]
+			for i := range chs {
+				c := &chs[i]
+				for x := 0; x < int(width); x++ {
+					var v float32
+					switch c.pixelType {
+					case exrPixelHalf:
+						v = HalfToFloat32(binary.LittleEndian.Uint16(data[x*2:]))
+					case exrPixelFloat:
+						v = math.Float32frombits(binary.LittleEndian.Uint32(data[x*4:]))
+					case exrPixelUint:
+						v = float32(binary.LittleEndian.Uint32(data[x*4:]))
+					}
+					if dst[i] >= 0 {
+						pix[x*4+dst[i]] = v
+					} else if i == grey {
+						pix[x*4], pix[x*4+1], pix[x*4+2] = v, v, v
+					}
+				}
+				data = data[c.size()*int(width):]
+			}
+			if !hasAlpha {
+				for x := 0; x < int(width); x++ {
+					pix[x*4+3] = 1
+				}
+			}
+		}
+	}
+
+	return img, nil
+}
+
+// inflateExr undoes ZIP compression: zlib, then the byte delta predictor,
+// then the split of even and odd bytes into two halves.
+func inflateExr(src []byte, size int) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, exrErrorf("bad ZIP chunk: %v", err)
+	}
+	tmp := make([]byte, size)
+	if _, err := io.ReadFull(zr, tmp); err != nil {
+		return nil, exrErrorf("bad ZIP chunk: %v", err)
+	}
+
+	for i := 1; i < len(tmp); i++ {
+		tmp[i] = tmp[i-1] + tmp[i] - 128
+	}
+
+	out := make([]byte, size)
+	half := (size + 1) / 2
+	for i := range out {
+		if i%2 == 0 {
+			out[i] = tmp[i/2]
+		} else {
+			out[i] = tmp[half+i/2]
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,135 @@
+// exr_test.go
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"testing"
+)
+
+func exrAttr(buf *bytes.Buffer, name, typ string, value []byte) {
+	buf.WriteString(name + "\x00" + typ + "\x00")
+	binary.Write(buf, binary.LittleEndian, int32(len(value)))
+	buf.Write(value)
+}
+
+// deflateExr applies ZIP compression the way OpenEXR writes it.
+func deflateExr(raw []byte) []byte {
+	tmp := make([]byte, len(raw))
+	half := (len(raw) + 1) / 2
+	for i, b := range raw {
+		if i%2 == 0 {
+			tmp[i/2] = b
+		} else {
+			tmp[half+i/2] = b
+		}
+	}
+	for i := len(tmp) - 1; i > 0; i-- {
+		tmp[i] = tmp[i] - tmp[i-1] + 128
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(tmp)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// buildExr encodes a width x height image with half B, float G and half R
+// channels, texel (x, y) = (x, y, x+y).
+func buildExr(width, height int, compression byte) []byte {
+	var hdr bytes.Buffer
+	binary.Write(&hdr, binary.LittleEndian, []int32{exrMagic, 2})
+
+	var chlist bytes.Buffer
+	for _, c := range []struct {
+		name string
+		typ  int32
+	}{{"B", exrPixelHalf}, {"G", exrPixelFloat}, {"R", exrPixelHalf}} {
+		chlist.WriteString(c.name + "\x00")
+		binary.Write(&chlist, binary.LittleEndian, []int32{c.typ, 0, 1, 1})
+	}
+	chlist.WriteByte(0)
+	exrAttr(&hdr, "channels", "chlist", chlist.Bytes())
+	exrAttr(&hdr, "compression", "compression", []byte{compression})
+	window := make([]byte, 16)
+	binary.LittleEndian.PutUint32(window[8:], uint32(width-1))
+	binary.LittleEndian.PutUint32(window[12:], uint32(height-1))
+	exrAttr(&hdr, "dataWindow", "box2i", window)
+	exrAttr(&hdr, "displayWindow", "box2i", window)
+	exrAttr(&hdr, "lineOrder", "lineOrder", []byte{0})
+	hdr.WriteByte(0)
+
+	lines := 1
+	if compression == exrCompressionZIP {
+		lines = 16
+	}
+	chunks := (height + lines - 1) / lines
+
+	var data bytes.Buffer
+	offsets := make([]uint64, chunks)
+	for c := 0; c < chunks; c++ {
+		var raw bytes.Buffer
+		for y := c * lines; y < height && y < (c+1)*lines; y++ {
+			for x := 0; x < width; x++ {
+				binary.Write(&raw, binary.LittleEndian, Float32ToHalf(float32(x+y)))
+			}
+			for x := 0; x < width; x++ {
+				binary.Write(&raw, binary.LittleEndian, float32(y))
+			}
+			for x := 0; x < width; x++ {
+				binary.Write(&raw, binary.LittleEndian, Float32ToHalf(float32(x)))
+			}
+		}
+		chunk := raw.Bytes()
+		// Writers keep chunks that don't shrink uncompressed.
+		if z := deflateExr(chunk); compression != exrCompressionNone && len(z) < len(chunk) {
+			chunk = z
+		}
+		offsets[c] = uint64(hdr.Len() + chunks*8 + data.Len())
+		binary.Write(&data, binary.LittleEndian, []int32{int32(c * lines), int32(len(chunk))})
+		data.Write(chunk)
+	}
+
+	binary.Write(&hdr, binary.LittleEndian, offsets)
+	hdr.Write(data.Bytes())
+	return hdr.Bytes()
+}
+
+func TestDecodeExr(t *testing.T) {
+	for _, compression := range []byte{exrCompressionNone, exrCompressionZIPS, exrCompressionZIP} {
+		img, err := DecodeExr(bytes.NewReader(buildExr(5, 20, compression)))
+		if err != nil {
+			t.Fatalf("compression %d: %v", compression, err)
+		}
+		if img.Width != 5 || img.Height != 20 {
+			t.Fatalf("compression %d: size %dx%d", compression, img.Width, img.Height)
+		}
+		for y := 0; y < 20; y++ {
+			for x := 0; x < 5; x++ {
+				px := img.Pix[(y*5+x)*4:]
+				if px[0] != float32(x) || px[1] != float32(y) || px[2] != float32(x+y) || px[3] != 1 {
+					t.Fatalf("compression %d: texel %d,%d = %v", compression, x, y, px[:4])
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeExrErrors(t *testing.T) {
+	d := buildExr(4, 4, exrCompressionZIPS)
+	if _, err := DecodeExr(bytes.NewReader(d[:len(d)-4])); err == nil {
+		t.Error("truncated file should fail")
+	}
+
+	bad := append([]byte(nil), d...)
+	binary.LittleEndian.PutUint32(bad[4:], 2|exrFlagTiled)
+	if _, err := DecodeExr(bytes.NewReader(bad)); err == nil {
+		t.Error("tiled file should be rejected")
+	}
+
+	if _, err := DecodeExr(bytes.NewReader([]byte{1, 2, 3})); err == nil {
+		t.Error("short file should fail")
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	gl "github.com/chsc/gogl/gl42"
 	glfw "github.com/go-gl/glfw3"
-	"io/ioutil"
 	"log"
 	"time"
 )
@@ -16,7 +15,8 @@ const (
 )
 
 var (
-	window *glfw.Window
+	window      *glfw.Window
+	windowTitle string
 )
 
 func init() {
@@ -29,10 +29,6 @@ func errorCallback(err glfw.ErrorCode, desc string) {
 
 func getShaderInfoLog(s gl.Uint) string {
 	var length gl.Int
-	var status gl.Int
-
-	gl.GetShaderiv(s, gl.COMPILE_STATUS, &status)
-	log.Println("shader compile status:", status)
 
 	gl.GetShaderiv(s, gl.INFO_LOG_LENGTH, &length)
 	if length > 0 {
@@ -46,7 +42,6 @@ func getShaderInfoLog(s gl.Uint) string {
 }
 
 func getProgramInfoLog(program gl.Uint) string {
-	var length gl.Int
 	var status gl.Int
 
 	// GL_DELETE_STATUS, GL_LINK_STATUS, GL_INFO_LOG_LENGTH, GL_ATTACHED_SHADERS
@@ -57,6 +52,12 @@ func getProgramInfoLog(program gl.Uint) string {
 	gl.GetProgramiv(program, gl.ACTIVE_UNIFORMS, &status)
 	log.Println("active uniforms:", status)
 
+	return programInfoLog(program)
+}
+
+func programInfoLog(program gl.Uint) string {
+	var length gl.Int
+
 	gl.GetProgramiv(program, gl.INFO_LOG_LENGTH, &length)
 	if length > 0 {
 		info := gl.GLStringAlloc(gl.Sizei(length))
@@ -84,53 +85,43 @@ func printGLParams() {
 	log.Println("MAX_COMBINED_TEXTURE_IMAGE_UNITS", v)
 }
 
-func CompileShaders(shaderType int, vert, frag string) gl.Uint {
-	vss := vert
-	if shaderType == ShaderFile {
-		vsf, err := ioutil.ReadFile(vert)
-		if err != nil {
-			log.Fatal(err)
-		}
-		vss = string(vsf)
+func compileShader(stage gl.Enum, src, file string) (gl.Uint, error) {
+	s := gl.CreateShader(stage)
+	cs := gl.GLString(src)
+	defer gl.GLStringFree(cs)
+	slen := gl.Int(len(src))
+	gl.ShaderSource(s, 1, &cs, &slen)
+	gl.CompileShader(s)
+
+	var status gl.Int
+	gl.GetShaderiv(s, gl.COMPILE_STATUS, &status)
+	if status == gl.FALSE {
+		err := &ShaderCompileError{Stage: stage, File: file, Log: getShaderInfoLog(s)}
+		gl.DeleteShader(s)
+		return 0, err
 	}
 
-	fss := frag
+	return s, nil
+}
+
+// CompileShaders builds a program from a vertex and a fragment shader, given
+// either as source strings or file names. Compile failures are reported as
+// *ShaderCompileError and link failures as *ProgramLinkError. ProgramBuilder
+// handles the other stages.
+func CompileShaders(shaderType int, vert, frag string) (gl.Uint, error) {
+	b := NewProgramBuilder()
 	if shaderType == ShaderFile {
-		fsf, err := ioutil.ReadFile(frag)
-		if err != nil {
-			log.Fatal(err)
-		}
-		fss = string(fsf)
+		b.File(gl.VERTEX_SHADER, vert).File(gl.FRAGMENT_SHADER, frag)
+	} else {
+		b.Source(gl.VERTEX_SHADER, vert).Source(gl.FRAGMENT_SHADER, frag)
+	}
+	program, err := b.Build()
+	if errs, ok := err.(ShaderCompileErrors); ok {
+		return 0, errs[0]
+	}
+	if err != nil {
+		return 0, err
 	}
-
-	vs := gl.CreateShader(gl.VERTEX_SHADER)
-	cs := gl.GLString(vss)
-	slen := gl.Int(len(vss))
-	gl.ShaderSource(vs, 1, &cs, &slen)
-	gl.CompileShader(vs)
-	defer gl.DeleteShader(vs)
-	log.Println("vertex shader info log:", getShaderInfoLog(vs))
-
-	fs := gl.CreateShader(gl.FRAGMENT_SHADER)
-	cs = gl.GLString(fss)
-	slen = gl.Int(len(fss))
-	gl.ShaderSource(fs, 1, &cs, &slen)
-	/*
-		size := 1024
-		var length gl.Sizei = 0
-		c := gl.GLStringAlloc(gl.Sizei(size))
-		gl.GetShaderSource(fs, gl.Sizei(size), &length, c)
-		log.Println(len(fss), length, gl.GoString(c))
-		gl.GLStringFree(c)
-	*/
-	gl.CompileShader(fs)
-	defer gl.DeleteShader(fs)
-	log.Println("frag shader info log:", getShaderInfoLog(fs))
-
-	program := gl.CreateProgram()
-	gl.AttachShader(program, vs)
-	gl.AttachShader(program, fs)
-	gl.LinkProgram(program)
 	gl.UseProgram(program)
 
 	gl.ValidateProgram(program)
@@ -142,14 +133,14 @@ func CompileShaders(shaderType int, vert, frag string) gl.Uint {
 	//Validation warning! - Sampler value s has not been set.
 	log.Println("program info log:", getProgramInfoLog(program))
 
-	return gl.Uint(program)
+	return gl.Uint(program), nil
 }
 
-func GlfwInit(width, height int, title string, major, minor int, debug bool) {
+func GlfwInit(width, height int, title string, major, minor int, debug bool) error {
 	glfw.SetErrorCallback(errorCallback)
 
 	if !glfw.Init() {
-		panic("Can't init glfw!")
+		return &GlfwError{Msg: "can't init glfw"}
 	}
 
 	glfw.WindowHint(glfw.Resizable, glfw.False)
@@ -166,14 +157,20 @@ func GlfwInit(width, height int, title string, major, minor int, debug bool) {
 	var err error
 	window, err = glfw.CreateWindow(width, height, title, nil, nil)
 	if err != nil {
-		panic(err)
+		glfw.Terminate()
+		return &GlfwError{Msg: "can't create window", Err: err}
 	}
 
+	windowTitle = title
 	window.MakeContextCurrent()
 	glfw.SwapInterval(1)
 
-	r := gl.Init()
-	log.Println("init opengl:", r)
+	if err := gl.Init(); err != nil {
+		window.Destroy()
+		window = nil
+		glfw.Terminate()
+		return &GlfwError{Msg: "can't init opengl", Err: err}
+	}
 	printGLParams()
 
 	go func() {
@@ -184,10 +181,24 @@ func GlfwInit(width, height int, title string, major, minor int, debug bool) {
 			time.Sleep(100 * time.Millisecond)
 		}
 	}()
+
+	return nil
 }
 
 func GlfwMainLoop(render func(float64)) {
+	var shaderErr error
 	for !window.ShouldClose() {
+		// Show a failed shader reload in the title until it is fixed.
+		shaderReloader.Update()
+		if err := shaderReloader.Err(); err != shaderErr {
+			shaderErr = err
+			if err != nil {
+				window.SetTitle(windowTitle + " - shader error, see log")
+			} else {
+				window.SetTitle(windowTitle)
+			}
+		}
+
 		//Do OpenGL stuff
 		render(glfw.GetTime())
 
@@ -0,0 +1,282 @@
+// glslpp
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxIncludeDepth stops runaway includes that #pragma once doesn't catch.
+const maxIncludeDepth = 32
+
+// Preprocessor expands #include "file" directives and injects #defines into
+// GLSL source before it reaches the driver. Included files are searched for
+// next to the including file, then in IncludeDirs. A file containing
+// #pragma once is included only once; other guards are left to the driver.
+// The #version line of included files is dropped, so they may keep one for
+// editors. Includes inside block comments and #if 0 groups are skipped.
+//
+// Each file becomes a GLSL source string number in #line directives, so the
+// driver's error lines can be mapped back with GLSLSource.MapLog. The
+// directives use the GLSL 3.30 meaning of #line, the number of the next line.
+type Preprocessor struct {
+	Defines     map[string]string
+	IncludeDirs []string
+
+	// ReadFile reads files, ioutil.ReadFile if nil.
+	ReadFile func(filename string) ([]byte, error)
+}
+
+// GLSLSource is preprocessed source and the file each source string number
+// refers to.
+type GLSLSource struct {
+	Source string
+	Files  []string
+}
+
+// File preprocesses filename.
+func (p *Preprocessor) File(filename string) (*GLSLSource, error) {
+	d, err := p.read(filename)
+	if err != nil {
+		return nil, err
+	}
+	return p.expand(filepath.Clean(filename), string(d), true)
+}
+
+// Source preprocesses src, named name in errors; includes are searched for
+// in IncludeDirs only.
+func (p *Preprocessor) Source(src, name string) (*GLSLSource, error) {
+	return p.expand(name, src, false)
+}
+
+func (p *Preprocessor) read(filename string) ([]byte, error) {
+	if p.ReadFile != nil {
+		return p.ReadFile(filename)
+	}
+	return ioutil.ReadFile(filename)
+}
+
+func (p *Preprocessor) expand(name, src string, file bool) (*GLSLSource, error) {
+	e := &expander{p: p, once: make(map[string]bool), fromFile: file}
+	if err := e.expand(name, src, false); err != nil {
+		return nil, err
+	}
+	return &GLSLSource{Source: e.out.String(), Files: e.files}, nil
+}
+
+type expander struct {
+	p        *Preprocessor
+	fromFile bool // whether the top level source was read from a file
+	files    []string
+	once     map[string]bool
+	stack    []string
+	out      strings.Builder
+}
+
+// defines writes the injected #defines in name order.
+func (e *expander) defines() {
+	names := make([]string, 0, len(e.p.Defines))
+	for k := range e.p.Defines {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		if v := e.p.Defines[k]; v != "" {
+			fmt.Fprintf(&e.out, "#define %s %s\n", k, v)
+		} else {
+			fmt.Fprintf(&e.out, "#define %s\n", k)
+		}
+	}
+}
+
+// directive returns the name and the rest of a preprocessor line.
+func directive(line string) (name, rest string, ok bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "#") {
+		return "", "", false
+	}
+	fields := strings.Fields(line[1:])
+	if len(fields) == 0 {
+		return "", "", false
+	}
+	rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line[1:]), fields[0]))
+	return fields[0], rest, true
+}
+
+// activeLines reports for each line whether a directive on it takes effect.
+// Directives inside block comments and #if 0 groups don't; other
+// conditionals are left to the driver.
+func activeLines(lines []string) []bool {
+	active := make([]bool, len(lines))
+	comment := false
+	skip := 0 // #if nesting inside an #if 0 group
+	for i, line := range lines {
+		if d, rest, ok := directive(line); ok && !comment {
+			switch {
+			case skip == 0 && d == "if" && rest == "0":
+				skip = 1
+			case skip > 0 && (d == "if" || d == "ifdef" || d == "ifndef"):
+				skip++
+			case skip > 0 && d == "endif":
+				skip--
+			case skip == 1 && (d == "else" || d == "elif"):
+				skip = 0
+			}
+			active[i] = skip == 0
+		}
+		comment = inComment(line, comment)
+	}
+	return active
+}
+
+// inComment reports whether a block comment is open at the end of line,
+// given whether one was at its start.
+func inComment(line string, comment bool) bool {
+	for len(line) > 1 {
+		switch {
+		case comment && strings.HasPrefix(line, "*/"):
+			comment = false
+			line = line[2:]
+		case !comment && strings.HasPrefix(line, "/*"):
+			comment = true
+			line = line[2:]
+		case !comment && strings.HasPrefix(line, "//"):
+			return false
+		default:
+			line = line[1:]
+		}
+	}
+	return comment
+}
+
+func (e *expander) expand(name, src string, included bool) error {
+	for _, f := range e.stack {
+		if f == name {
+			return &ShaderIncludeError{File: name, Msg: "include cycle through " + strings.Join(e.stack, ", ")}
+		}
+	}
+	if len(e.stack) == maxIncludeDepth {
+		return &ShaderIncludeError{File: name, Msg: "includes nested too deeply"}
+	}
+	e.stack = append(e.stack, name)
+	defer func() { e.stack = e.stack[:len(e.stack)-1] }()
+
+	id := len(e.files)
+	e.files = append(e.files, name)
+
+	lines := strings.Split(strings.Replace(src, "\r\n", "\n", -1), "\n")
+	active := activeLines(lines)
+	injected := included || len(e.p.Defines) == 0
+	if !injected {
+		// Without a #version the defines go first.
+		hasVersion := false
+		for i, line := range lines {
+			if d, _, ok := directive(line); ok && active[i] && d == "version" {
+				hasVersion = true
+				break
+			}
+		}
+		if !hasVersion {
+			e.defines()
+			fmt.Fprintf(&e.out, "#line 1 %d\n", id)
+			injected = true
+		}
+	}
+
+	for i, line := range lines {
+		if i > 0 {
+			e.out.WriteByte('\n')
+		}
+		d, rest, ok := directive(line)
+		switch {
+		case !ok:
+			e.out.WriteString(line)
+		case !active[i] && d == "include":
+			// Dropped, the line stays blank.
+		case !active[i]:
+			e.out.WriteString(line)
+		case d == "version" && included:
+			// Dropped, the line stays blank.
+		case d == "version":
+			e.out.WriteString(line)
+			if !injected {
+				e.out.WriteByte('\n')
+				e.defines()
+				fmt.Fprintf(&e.out, "#line %d %d", i+2, id)
+				injected = true
+			}
+		case d == "pragma" && rest == "once":
+			e.once[name] = true
+		case d == "include":
+			if err := e.include(name, i+1, rest); err != nil {
+				return err
+			}
+			fmt.Fprintf(&e.out, "\n#line %d %d", i+2, id)
+		default:
+			e.out.WriteString(line)
+		}
+	}
+	return nil
+}
+
+// include expands the file named by arg, a quoted path on line of parent.
+func (e *expander) include(parent string, line int, arg string) error {
+	if len(arg) < 2 || arg[0] != '"' || arg[len(arg)-1] != '"' {
+		return &ShaderIncludeError{File: parent, Line: line, Msg: "#include expects \"file\""}
+	}
+	inc := arg[1 : len(arg)-1]
+
+	var dirs []string
+	if len(e.stack) > 1 || e.fromFile {
+		dirs = append(dirs, filepath.Dir(parent))
+	}
+	dirs = append(dirs, e.p.IncludeDirs...)
+
+	for _, dir := range dirs {
+		name := filepath.Clean(filepath.Join(dir, inc))
+		if e.once[name] {
+			return nil
+		}
+		d, err := e.p.read(name)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&e.out, "#line 1 %d\n", len(e.files))
+		return e.expand(name, string(d), true)
+	}
+	return &ShaderIncludeError{File: parent, Line: line, Msg: fmt.Sprintf("can't find include %q", inc)}
+}
+
+// driverLine matches the source string and line at the start of a message in
+// the logs of the common drivers: "0:12(5): error" (Mesa), "0(12) : error"
+// (NVIDIA) and "ERROR: 0:12: " (AMD, Intel).
+var driverLine = regexp.MustCompile(`^((?:ERROR|WARNING): )?(\d+)(?::(\d+)|\((\d+)\))`)
+
+// MapLog rewrites the source string numbers in a driver info log to the
+// file names they stand for.
+func (s *GLSLSource) MapLog(log string) string {
+	lines := strings.Split(log, "\n")
+	for i, line := range lines {
+		m := driverLine.FindStringSubmatchIndex(line)
+		if m == nil {
+			continue
+		}
+		id, _ := strconv.Atoi(line[m[4]:m[5]])
+		if id >= len(s.Files) {
+			continue
+		}
+		var num string
+		if m[6] >= 0 {
+			num = line[m[6]:m[7]]
+		} else {
+			num = line[m[8]:m[9]]
+		}
+		lines[i] = line[:m[4]] + s.Files[id] + ":" + num + line[m[1]:]
+	}
+	return strings.Join(lines, "\n")
+}
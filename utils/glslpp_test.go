@@ -0,0 +1,226 @@
+// glslpp_test.go
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// memFiles serves files from a map, keyed by slash separated path.
+func memFiles(files map[string]string) func(string) ([]byte, error) {
+	return func(name string) ([]byte, error) {
+		if s, ok := files[filepath.ToSlash(name)]; ok {
+			return []byte(s), nil
+		}
+		return nil, os.ErrNotExist
+	}
+}
+
+// lineOf returns the file and line the driver would report for output line
+// n (1-based) of src, following its #line directives.
+func lineOf(s *GLSLSource, n int) (string, int) {
+	file, line := 0, 1
+	for i, l := range strings.Split(s.Source, "\n") {
+		if i+1 == n {
+			return s.Files[file], line
+		}
+		if d, rest, ok := directive(l); ok && d == "line" {
+			f := strings.Fields(rest)
+			line = atoi(f[0])
+			if len(f) > 1 {
+				file = atoi(f[1])
+			}
+			continue
+		}
+		line++
+	}
+	return "", 0
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// find returns the output line (1-based) holding text.
+func find(t *testing.T, s *GLSLSource, text string) int {
+	for i, l := range strings.Split(s.Source, "\n") {
+		if strings.Contains(l, text) {
+			return i + 1
+		}
+	}
+	t.Fatalf("%q not in output:\n%s", text, s.Source)
+	return 0
+}
+
+func TestPreprocessInclude(t *testing.T) {
+	p := &Preprocessor{
+		IncludeDirs: []string{"lib"},
+		ReadFile: memFiles(map[string]string{
+			"shaders/main.vs": "#version 430 core\n" +
+				"#include \"common.glsl\"\n" +
+				"#include \"noise.glsl\"\n" +
+				"void main() {\n" +
+				"\tmain_body;\n" +
+				"}",
+			"shaders/common.glsl": "#version 430 core\n" +
+				"#pragma once\n" +
+				"struct VS_OUT { vec4 color; };\n",
+			"lib/noise.glsl": "#include \"common.glsl\"\n" +
+				"float noise(vec2 p);\n",
+			"lib/common.glsl": "lib_common;\n",
+		}),
+	}
+	s, err := p.File("shaders/main.vs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"shaders/main.vs", "shaders/common.glsl", "lib/noise.glsl", "lib/common.glsl"}
+	if strings.Join(s.Files, ",") != filepath.FromSlash(strings.Join(want, ",")) {
+		t.Errorf("files %v", s.Files)
+	}
+	if !strings.HasPrefix(s.Source, "#version 430 core\n") || strings.Count(s.Source, "#version") != 1 {
+		t.Errorf("version lines:\n%s", s.Source)
+	}
+	// noise.glsl finds the common.glsl next to it, not the one already
+	// included through main.vs.
+	if strings.Count(s.Source, "struct VS_OUT") != 1 || !strings.Contains(s.Source, "lib_common") {
+		t.Errorf("includes:\n%s", s.Source)
+	}
+
+	for _, c := range []struct {
+		text string
+		file string
+		line int
+	}{
+		{"struct VS_OUT", "shaders/common.glsl", 3},
+		{"lib_common", "lib/common.glsl", 1},
+		{"float noise", "lib/noise.glsl", 2},
+		{"main_body", "shaders/main.vs", 5},
+	} {
+		file, line := lineOf(s, find(t, s, c.text))
+		if file != filepath.FromSlash(c.file) || line != c.line {
+			t.Errorf("%s maps to %s:%d, want %s:%d", c.text, file, line, c.file, c.line)
+		}
+	}
+}
+
+func TestPreprocessPragmaOnce(t *testing.T) {
+	p := &Preprocessor{ReadFile: memFiles(map[string]string{
+		"a.glsl":     "#include \"once.glsl\"\n#include \"once.glsl\"\n#include \"twice.glsl\"\n#include \"twice.glsl\"\nend;",
+		"once.glsl":  "#pragma once\nonce;",
+		"twice.glsl": "#ifndef TWICE\n#define TWICE\ntwice;\n#endif",
+	})}
+	s, err := p.File("a.glsl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(s.Source, "once;") != 1 || strings.Count(s.Source, "twice;") != 2 {
+		t.Errorf("output:\n%s", s.Source)
+	}
+	if file, line := lineOf(s, find(t, s, "end;")); file != "a.glsl" || line != 5 {
+		t.Errorf("end maps to %s:%d", file, line)
+	}
+}
+
+func TestPreprocessDefines(t *testing.T) {
+	p := &Preprocessor{Defines: map[string]string{"MAX_LIGHTS": "4", "USE_FOG": ""}}
+
+	s, err := p.Source("// header\n#version 330\nuse;", "<vertex>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "// header\n#version 330\n#define MAX_LIGHTS 4\n#define USE_FOG\n#line 3 0\nuse;"
+	if s.Source != want {
+		t.Errorf("got\n%s\nwant\n%s", s.Source, want)
+	}
+
+	s, err = p.Source("use;", "<vertex>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file, line := lineOf(s, find(t, s, "use;")); file != "<vertex>" || line != 1 {
+		t.Errorf("use maps to %s:%d", file, line)
+	}
+
+	// Source without directives or defines is passed through.
+	src := "#version 330\r\nvoid main() {}\n"
+	if s, _ := (&Preprocessor{}).Source(src, "x"); s.Source != strings.Replace(src, "\r\n", "\n", -1) {
+		t.Errorf("got %q", s.Source)
+	}
+}
+
+func TestPreprocessErrors(t *testing.T) {
+	p := &Preprocessor{ReadFile: memFiles(map[string]string{
+		"loop.glsl":  "#include \"loop2.glsl\"",
+		"loop2.glsl": "\n#include \"loop.glsl\"",
+		"bad.glsl":   "#include <stdio.h>",
+		"miss.glsl":  "\n\n#include \"nothing.glsl\"",
+	})}
+	for _, c := range []struct {
+		file string
+		line int
+		msg  string
+	}{
+		{"loop.glsl", 0, "include cycle"},
+		{"bad.glsl", 1, "expects"},
+		{"miss.glsl", 3, "can't find"},
+	} {
+		_, err := p.File(c.file)
+		e, ok := err.(*ShaderIncludeError)
+		if !ok || e.Line != c.line || !strings.Contains(e.Msg, c.msg) {
+			t.Errorf("%s: %v", c.file, err)
+		}
+	}
+	if _, err := p.File("none.glsl"); !os.IsNotExist(err) {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestPreprocessInactive(t *testing.T) {
+	p := &Preprocessor{ReadFile: memFiles(map[string]string{
+		"main.fs": "/* #include \"none.glsl\"\n" +
+			"#include \"none.glsl\"\n" +
+			"*/ // /*\n" +
+			"#if 0\n" +
+			"#ifdef X\n" +
+			"#include \"none.glsl\"\n" +
+			"#endif\n" +
+			"#else\n" +
+			"#include \"a.glsl\"\n" +
+			"#endif\n" +
+			"end;",
+		"a.glsl": "a;",
+	})}
+	s, err := p.File("main.fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(s.Source, "none.glsl") != 1 || strings.Count(s.Source, "a;") != 1 {
+		t.Errorf("output:\n%s", s.Source)
+	}
+	if file, line := lineOf(s, find(t, s, "end;")); file != "main.fs" || line != 11 {
+		t.Errorf("end maps to %s:%d", file, line)
+	}
+}
+
+func TestMapLog(t *testing.T) {
+	s := &GLSLSource{Files: []string{"main.fs", "common.glsl"}}
+	log := "0:12(5): error: `x' undeclared\n" +
+		"1(3) : error C1008: undefined variable\n" +
+		"ERROR: 1:7: 'y' : syntax error\n" +
+		"5:1: unknown source\n" +
+		"linker notes"
+	want := "main.fs:12(5): error: `x' undeclared\n" +
+		"common.glsl:3 : error C1008: undefined variable\n" +
+		"ERROR: common.glsl:7: 'y' : syntax error\n" +
+		"5:1: unknown source\n" +
+		"linker notes"
+	if got := s.MapLog(log); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
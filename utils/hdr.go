@@ -0,0 +1,230 @@
+// hdr
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	gl "github.com/chsc/gogl/gl42"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DecodeHdr decodes a Radiance RGBE (.hdr) image, flat or run-length
+// encoded.
+func DecodeHdr(r io.Reader) (*FloatImage, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.ReadString('\n')
+	if err != nil || !(strings.HasPrefix(magic, "#?RADIANCE") || strings.HasPrefix(magic, "#?RGBE")) {
+		return nil, hdrErrorf("invalid file header")
+	}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, hdrErrorf("header truncated")
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+			return nil, hdrErrorf("unsupported %s", line)
+		}
+	}
+
+	// Only the standard orientations, rows top-down or bottom-up, are
+	// supported.
+	res, err := br.ReadString('\n')
+	if err != nil {
+		return nil, hdrErrorf("missing resolution")
+	}
+	var ysign, xsign byte
+	var width, height int
+	if n, _ := fmt.Sscanf(res, "%cY %d %cX %d", &ysign, &height, &xsign, &width); n != 4 ||
+		xsign != '+' || (ysign != '-' && ysign != '+') || width <= 0 || height <= 0 {
+		return nil, hdrErrorf("unsupported resolution %q", strings.TrimSpace(res))
+	}
+	if width*height > 1<<28 {
+		return nil, hdrErrorf("image too large")
+	}
+
+	img := NewFloatImage(width, height)
+	scan := make([]byte, width*4)
+	for y := 0; y < height; y++ {
+		if err := readHdrScanline(br, scan); err != nil {
+			return nil, err
+		}
+		row := y
+		if ysign == '+' {
+			row = height - 1 - y
+		}
+		pix := img.Pix[row*width*4:]
+		for x := 0; x < width; x++ {
+			r, g, b, e := scan[x*4], scan[x*4+1], scan[x*4+2], scan[x*4+3]
+			if e != 0 {
+				f := float32(math.Ldexp(1, int(e)-136))
+				pix[x*4] = (float32(r) + 0.5) * f
+				pix[x*4+1] = (float32(g) + 0.5) * f
+				pix[x*4+2] = (float32(b) + 0.5) * f
+			}
+			pix[x*4+3] = 1
+		}
+	}
+
+	return img, nil
+}
+
+// readHdrScanline reads one scanline of RGBE texels into scan.
+func readHdrScanline(br *bufio.Reader, scan []byte) error {
+	width := len(scan) / 4
+	var head [4]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return hdrErrorf("scanline truncated")
+	}
+
+	// New run-length encoding stores each component separately.
+	if width >= 8 && width < 0x8000 && head[0] == 2 && head[1] == 2 && head[2]&0x80 == 0 {
+		if int(head[2])<<8|int(head[3]) != width {
+			return hdrErrorf("scanline width mismatch")
+		}
+		for c := 0; c < 4; c++ {
+			for x := 0; x < width; {
+				count, err := br.ReadByte()
+				if err != nil {
+					return hdrErrorf("scanline truncated")
+				}
+				if count > 128 {
+					n := int(count) - 128
+					v, err := br.ReadByte()
+					if err != nil || x+n > width {
+						return hdrErrorf("bad scanline run")
+					}
+					for ; n > 0; n-- {
+						scan[x*4+c] = v
+						x++
+					}
+				} else {
+					n := int(count)
+					if n == 0 || x+n > width {
+						return hdrErrorf("bad scanline run")
+					}
+					for ; n > 0; n-- {
+						v, err := br.ReadByte()
+						if err != nil {
+							return hdrErrorf("scanline truncated")
+						}
+						scan[x*4+c] = v
+						x++
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	// Flat texels, possibly with the old style repeat markers.
+	copy(scan, head[:])
+	shift := uint(0)
+	for x := 1; x < width; {
+		var px [4]byte
+		if _, err := io.ReadFull(br, px[:]); err != nil {
+			return hdrErrorf("scanline truncated")
+		}
+		if px[0] == 1 && px[1] == 1 && px[2] == 1 {
+			n := int(px[3]) << shift
+			if x+n > width {
+				return hdrErrorf("bad scanline run")
+			}
+			for ; n > 0; n-- {
+				copy(scan[x*4:x*4+4], scan[x*4-4:x*4])
+				x++
+			}
+			shift += 8
+			continue
+		}
+		copy(scan[x*4:x*4+4], px[:])
+		x++
+		shift = 0
+	}
+	return nil
+}
+
+// NewFloatKtx converts img into an RGBA16F (half) or RGBA32F KTX file with
+// the bottom row first, ready for UploadKtx or WriteKtx.
+func NewFloatKtx(img *FloatImage, half bool) (*KTXFile, error) {
+	h := KTXHeader{
+		Gltype:           gl.FLOAT,
+		Glformat:         gl.RGBA,
+		Glinternalformat: gl.RGBA32F,
+		Pixelwidth:       uint32(img.Width),
+		Pixelheight:      uint32(img.Height),
+		Faces:            1,
+		Miplevels:        1,
+	}
+	if half {
+		h.Gltype = gl.HALF_FLOAT
+		h.Glinternalformat = gl.RGBA16F
+	}
+
+	stride := img.Width * 4
+	pix := make([]float32, len(img.Pix))
+	for y := 0; y < img.Height; y++ {
+		copy(pix[(img.Height-1-y)*stride:], img.Pix[y*stride:(y+1)*stride])
+	}
+
+	var data []byte
+	if half {
+		data = HalfBytes(pix)
+	} else {
+		data = Float32Bytes(pix)
+	}
+
+	f, err := NewKtx(h, [][]byte{data})
+	if err != nil {
+		return nil, err
+	}
+	f.KeyValue.SetString(orientationKey, "S=r,T=u")
+	return f, nil
+}
+
+// LoadFloatTexture loads a Radiance .hdr or OpenEXR .exr file into a
+// RGBA16F (half) or RGBA32F TEXTURE_2D, generating a new texture name if tex
+// is 0. Malformed files are reported as *HdrFormatError or *ExrFormatError.
+func LoadFloatTexture(filename string, tex gl.Uint, half bool) (gl.Uint, error) {
+	f, err := readFloatKtx(filename, half)
+	if err != nil {
+		return 0, err
+	}
+	return UploadKtx(f, tex)
+}
+
+func readFloatKtx(filename string, half bool) (*KTXFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var img *FloatImage
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".hdr", ".pic", ".rgbe":
+		img, err = DecodeHdr(file)
+	case ".exr":
+		img, err = DecodeExr(file)
+	default:
+		return nil, fmt.Errorf("%s: unknown floating-point image type", filename)
+	}
+	switch e := err.(type) {
+	case *HdrFormatError:
+		e.File = filename
+	case *ExrFormatError:
+		e.File = filename
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewFloatKtx(img, half)
+}
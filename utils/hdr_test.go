@@ -0,0 +1,113 @@
+// hdr_test.go
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	gl "github.com/chsc/gogl/gl42"
+	"math"
+	"testing"
+)
+
+// rgbeRows is a 8x2 image: a red ramp over a run of mid grey.
+var rgbeRows = [][][4]byte{
+	{{128, 0, 0, 129}, {128, 0, 0, 130}, {128, 0, 0, 131}, {128, 0, 0, 132},
+		{128, 0, 0, 133}, {128, 0, 0, 134}, {128, 0, 0, 135}, {128, 0, 0, 136}},
+	{{128, 128, 128, 128}, {128, 128, 128, 128}, {128, 128, 128, 128}, {128, 128, 128, 128},
+		{128, 128, 128, 128}, {128, 128, 128, 128}, {128, 128, 128, 128}, {128, 128, 128, 128}},
+}
+
+func buildHdr(rle bool) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\nEXPOSURE=1.0\n\n-Y %d +X %d\n", len(rgbeRows), len(rgbeRows[0]))
+
+	for _, row := range rgbeRows {
+		if !rle {
+			for _, px := range row {
+				buf.Write(px[:])
+			}
+			continue
+		}
+		buf.Write([]byte{2, 2, 0, byte(len(row))})
+		for c := 0; c < 4; c++ {
+			// Runs when the row is uniform, literals otherwise.
+			uniform := true
+			for _, px := range row {
+				uniform = uniform && px[c] == row[0][c]
+			}
+			if uniform {
+				buf.Write([]byte{byte(128 + len(row)), row[0][c]})
+				continue
+			}
+			buf.WriteByte(byte(len(row)))
+			for _, px := range row {
+				buf.WriteByte(px[c])
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeHdr(t *testing.T) {
+	for _, rle := range []bool{false, true} {
+		img, err := DecodeHdr(bytes.NewReader(buildHdr(rle)))
+		if err != nil {
+			t.Fatalf("rle %v: %v", rle, err)
+		}
+		if img.Width != 8 || img.Height != 2 {
+			t.Fatalf("rle %v: size %dx%d", rle, img.Width, img.Height)
+		}
+		// 128.5 * 2^(e-136) is about 2^(e-129).
+		for x := 0; x < 8; x++ {
+			want := math.Ldexp(1, x)
+			if r := float64(img.Pix[x*4]); math.Abs(r-want) > want*0.01 || img.Pix[x*4+3] != 1 {
+				t.Errorf("rle %v: texel %d = %v, want %v", rle, x, img.Pix[x*4:x*4+4], want)
+			}
+		}
+		if g := img.Pix[8*4+1]; math.Abs(float64(g)-0.5) > 0.01 {
+			t.Errorf("rle %v: grey = %v", rle, g)
+		}
+	}
+
+	d := buildHdr(true)
+	if _, err := DecodeHdr(bytes.NewReader(d[:len(d)-3])); err == nil {
+		t.Error("truncated file should fail")
+	}
+	if _, err := DecodeHdr(bytes.NewReader([]byte("P6\n"))); err == nil {
+		t.Error("bad magic should fail")
+	}
+}
+
+func TestNewFloatKtx(t *testing.T) {
+	img := NewFloatImage(1, 2)
+	copy(img.Pix, []float32{1, 2, 3, 1, 0.5, 0.25, -4, 1})
+
+	f, err := NewFloatKtx(img, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Header.Glinternalformat != gl.RGBA16F || f.Header.Gltypesize != 2 {
+		t.Errorf("header = %+v", f.Header)
+	}
+	// Bottom row first.
+	if v := HalfToFloat32(binary.LittleEndian.Uint16(f.Levels[0].Data[4:])); v != -4 {
+		t.Errorf("first row blue = %v, want -4", v)
+	}
+
+	g := roundTrip(t, f)
+	if !bytes.Equal(g.Levels[0].Data, f.Levels[0].Data) || g.TopDown() {
+		t.Error("round trip changed the file")
+	}
+}
+
+func TestHalf(t *testing.T) {
+	for _, v := range []float32{0, 1, -2, 0.5, 65504, 6.1035156e-05, 5.9604645e-08, float32(math.Inf(1))} {
+		if got := HalfToFloat32(Float32ToHalf(v)); got != v {
+			t.Errorf("%v round trips to %v", v, got)
+		}
+	}
+	if h := Float32ToHalf(1e6); h != 0x7c00 {
+		t.Errorf("overflow = %#x, want +Inf", h)
+	}
+}
@@ -0,0 +1,414 @@
+// image
+package utils
+
+import (
+	gl "github.com/chsc/gogl/gl42"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+)
+
+// MipFilter selects how LoadImageTexture builds the mip chain.
+type MipFilter int
+
+const (
+	MipGenerate MipFilter = iota // glGenerateMipmap on the GPU
+	MipNone                      // base level only
+	MipBox                       // 2x2 box filter on the CPU
+	MipKaiser                    // Kaiser windowed sinc on the CPU
+)
+
+// ImageOptions controls how a decoded image becomes a texture.
+type ImageOptions struct {
+	SRGB        bool // store as SRGB8_ALPHA8 rather than RGBA8
+	Premultiply bool // multiply color by alpha
+	FlipY       bool // store the bottom row first, as GL texture coordinates expect
+	Mipmaps     MipFilter
+	Sampler     *SamplerDesc // set on the texture by LoadImageTexture if not nil
+}
+
+// LoadImageTexture decodes a PNG, JPEG or GIF file into a TEXTURE_2D,
+// generating a new texture name if tex is 0. A nil opts uses the defaults of
+// a zero ImageOptions.
+func LoadImageTexture(filename string, tex gl.Uint, opts *ImageOptions) (gl.Uint, error) {
+	f, err := readImageKtx(filename, opts)
+	if err != nil {
+		return 0, err
+	}
+	if tex, err = UploadKtx(f, tex); err != nil {
+		return 0, err
+	}
+	if opts != nil && opts.Sampler != nil {
+		opts.Sampler.apply(f.Target, textureLevels(&f.Header))
+	}
+	return tex, nil
+}
+
+func readImageKtx(filename string, opts *ImageOptions) (*KTXFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	return NewImageKtx(img, opts)
+}
+
+// NewImageKtx converts img into an RGBA8 or SRGB8_ALPHA8 KTX file, with the
+// CPU mip chain if opts asks for one.
+func NewImageKtx(img image.Image, opts *ImageOptions) (*KTXFile, error) {
+	if opts == nil {
+		opts = &ImageOptions{}
+	}
+
+	b := img.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+
+	h := KTXHeader{
+		Gltype:           gl.UNSIGNED_BYTE,
+		Glformat:         gl.RGBA,
+		Glinternalformat: gl.RGBA8,
+		Pixelwidth:       uint32(b.Dx()),
+		Pixelheight:      uint32(b.Dy()),
+		Faces:            1,
+		Miplevels:        1,
+	}
+	if opts.SRGB {
+		h.Glinternalformat = gl.SRGB8_ALPHA8
+	}
+
+	base := newFloatImage(nrgba, opts.SRGB)
+	if opts.Premultiply {
+		base.premultiply()
+	}
+
+	var chain []*FloatImage
+	switch opts.Mipmaps {
+	case MipGenerate:
+		// A zero mip count has UploadKtx generate the chain.
+		h.Miplevels = 0
+		chain = []*FloatImage{base}
+	case MipNone:
+		chain = []*FloatImage{base}
+	case MipBox:
+		chain = base.mipChain(boxKernel, 0.5)
+	case MipKaiser:
+		chain = base.mipChain(kaiserKernel, 2)
+	}
+	if h.Miplevels != 0 {
+		h.Miplevels = uint32(len(chain))
+	}
+
+	var levels [][]byte
+	for _, m := range chain {
+		levels = append(levels, m.bytes(opts.SRGB, opts.FlipY))
+	}
+
+	f, err := NewKtx(h, levels)
+	if err != nil {
+		return nil, err
+	}
+	if opts.FlipY {
+		f.KeyValue.SetString(orientationKey, "S=r,T=u")
+	} else {
+		f.KeyValue.SetString(orientationKey, "S=r,T=d")
+	}
+	return f, nil
+}
+
+// FloatImage holds linear RGBA texels, top row first.
+type FloatImage struct {
+	Width, Height int
+	Pix           []float32
+}
+
+// NewFloatImage returns a black, transparent image.
+func NewFloatImage(width, height int) *FloatImage {
+	return &FloatImage{width, height, make([]float32, width*height*4)}
+}
+
+func srgbToLinear(c float32) float32 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return float32(math.Pow(float64((c+0.055)/1.055), 2.4))
+}
+
+func linearToSrgb(c float32) float32 {
+	if c <= 0.0031308 {
+		return c * 12.92
+	}
+	return float32(1.055*math.Pow(float64(c), 1/2.4) - 0.055)
+}
+
+func newFloatImage(img *image.NRGBA, srgb bool) *FloatImage {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	m := &FloatImage{w, h, make([]float32, w*h*4)}
+
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+		for i, v := range row {
+			c := float32(v) / 255
+			if srgb && i%4 != 3 {
+				c = srgbToLinear(c)
+			}
+			m.Pix[y*w*4+i] = c
+		}
+	}
+	return m
+}
+
+func (m *FloatImage) premultiply() {
+	for i := 0; i < len(m.Pix); i += 4 {
+		a := m.Pix[i+3]
+		m.Pix[i] *= a
+		m.Pix[i+1] *= a
+		m.Pix[i+2] *= a
+	}
+}
+
+// bytes encodes m as RGBA8, bottom row first if flip is set.
+func (m *FloatImage) bytes(srgb, flip bool) []byte {
+	d := make([]byte, len(m.Pix))
+	stride := m.Width * 4
+
+	for y := 0; y < m.Height; y++ {
+		dst := y
+		if flip {
+			dst = m.Height - 1 - y
+		}
+		for i := 0; i < stride; i++ {
+			c := m.Pix[y*stride+i]
+			if srgb && i%4 != 3 {
+				c = linearToSrgb(c)
+			}
+			c = float32(math.Max(0, math.Min(1, float64(c))))
+			d[dst*stride+i] = byte(c*255 + 0.5)
+		}
+	}
+	return d
+}
+
+func boxKernel(x float64) float64 {
+	if math.Abs(x) < 0.5 {
+		return 1
+	}
+	return 0
+}
+
+// bessel0 is the zeroth order modified Bessel function of the first kind.
+func bessel0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; k < 32; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+	}
+	return sum
+}
+
+// kaiserKernel is a sinc windowed by a Kaiser window of alpha 4 over [-2, 2].
+func kaiserKernel(x float64) float64 {
+	const alpha, support = 4.0, 2.0
+	if math.Abs(x) >= support {
+		return 0
+	}
+	sinc := 1.0
+	if x != 0 {
+		sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+	}
+	r := x / support
+	return sinc * bessel0(math.Pi*alpha*math.Sqrt(1-r*r)) / bessel0(math.Pi*alpha)
+}
+
+// mipChain returns m followed by every smaller mip down to 1x1, each level
+// filtered from the one above with kernel, which is zero outside support.
+func (m *FloatImage) mipChain(kernel func(float64) float64, support float64) []*FloatImage {
+	chain := []*FloatImage{m}
+	for m.Width > 1 || m.Height > 1 {
+		w, h := m.Width/2, m.Height/2
+		if w == 0 {
+			w = 1
+		}
+		if h == 0 {
+			h = 1
+		}
+		m = m.resample(w, h, kernel, support)
+		chain = append(chain, m)
+	}
+	return chain
+}
+
+// resample scales m to w x h with a separable filter, clamping at the edges.
+func (m *FloatImage) resample(w, h int, kernel func(float64) float64, support float64) *FloatImage {
+	tmp := &FloatImage{w, m.Height, make([]float32, w*m.Height*4)}
+	for x, taps := range filterTaps(m.Width, w, kernel, support) {
+		for y := 0; y < m.Height; y++ {
+			for _, t := range taps {
+				src := (y*m.Width + t.index) * 4
+				dst := (y*w + x) * 4
+				for c := 0; c < 4; c++ {
+					tmp.Pix[dst+c] += m.Pix[src+c] * t.weight
+				}
+			}
+		}
+	}
+
+	out := &FloatImage{w, h, make([]float32, w*h*4)}
+	for y, taps := range filterTaps(m.Height, h, kernel, support) {
+		for x := 0; x < w; x++ {
+			for _, t := range taps {
+				src := (t.index*w + x) * 4
+				dst := (y*w + x) * 4
+				for c := 0; c < 4; c++ {
+					out.Pix[dst+c] += tmp.Pix[src+c] * t.weight
+				}
+			}
+		}
+	}
+	return out
+}
+
+type filterTap struct {
+	index  int
+	weight float32
+}
+
+// filterTaps returns the normalized source taps of every destination texel
+// when scaling from n to size texels.
+func filterTaps(n, size int, kernel func(float64) float64, support float64) [][]filterTap {
+	scale := float64(n) / float64(size)
+	radius := support * scale
+
+	taps := make([][]filterTap, size)
+	for i := range taps {
+		center := (float64(i) + 0.5) * scale
+		var sum float64
+		var row []filterTap
+		for j := int(math.Floor(center - radius)); j <= int(math.Ceil(center+radius)); j++ {
+			w := kernel((float64(j) + 0.5 - center) / scale)
+			if w == 0 {
+				continue
+			}
+			k := j
+			if k < 0 {
+				k = 0
+			} else if k >= n {
+				k = n - 1
+			}
+			row = append(row, filterTap{k, float32(w)})
+			sum += w
+		}
+		for t := range row {
+			row[t].weight /= float32(sum)
+		}
+		taps[i] = row
+	}
+	return taps
+}
+
+// KtxImage converts one image of an uncompressed KTX file to 8 bits per
+// channel, top row first. Depth slices of a 3D image are stacked top to
+// bottom. Float values are clamped to [0, 1]; multi-byte components are read
+// as little-endian.
+func KtxImage(f *KTXFile, level, layer, face int) (*image.NRGBA, error) {
+	h := &f.Header
+	img := f.Image(level, layer, face)
+	if img == nil {
+		return nil, ktxErrorf("no image for level %d layer %d face %d", level, layer, face)
+	}
+	if h.IsCompressed() {
+		return nil, ktxErrorf("can't convert compressed format %#x", h.Glinternalformat)
+	}
+
+	var order []int
+	switch h.Glformat {
+	case gl.RED, gl.RED_INTEGER, gl.DEPTH_COMPONENT:
+		order = []int{0}
+	case gl.RG, gl.RG_INTEGER:
+		order = []int{0, 1}
+	case gl.RGB, gl.RGB_INTEGER:
+		order = []int{0, 1, 2}
+	case gl.BGR, gl.BGR_INTEGER:
+		order = []int{2, 1, 0}
+	case gl.RGBA, gl.RGBA_INTEGER:
+		order = []int{0, 1, 2, 3}
+	case gl.BGRA, gl.BGRA_INTEGER:
+		order = []int{2, 1, 0, 3}
+	default:
+		return nil, ktxErrorf("can't convert format %#x", h.Glformat)
+	}
+
+	var read func(d []byte) uint8
+	switch h.Gltype {
+	case gl.UNSIGNED_BYTE:
+		read = func(d []byte) uint8 { return d[0] }
+	case gl.UNSIGNED_SHORT:
+		read = func(d []byte) uint8 { return d[1] }
+	case gl.HALF_FLOAT:
+		read = func(d []byte) uint8 { return unorm8(HalfToFloat32(uint16(d[0]) | uint16(d[1])<<8)) }
+	case gl.FLOAT:
+		read = func(d []byte) uint8 {
+			return unorm8(math.Float32frombits(uint32(d[0]) | uint32(d[1])<<8 | uint32(d[2])<<16 | uint32(d[3])<<24))
+		}
+	default:
+		return nil, ktxErrorf("can't convert type %#x", h.Gltype)
+	}
+
+	size := int(h.Gltypesize)
+	if size != int(typeBytes(h.Gltype)) {
+		return nil, ktxErrorf("glTypeSize %d doesn't match type %#x", h.Gltypesize, h.Gltype)
+	}
+	stride := calcStride(h, img.Width, max1(uint32(f.Alignment)))
+	if mulSize(stride, uint64(img.Height)*uint64(img.Depth)) > uint64(len(img.Data)) {
+		return nil, ktxErrorf("image data truncated")
+	}
+	width := int(img.Width)
+	rows := int(img.Height * img.Depth)
+
+	out := image.NewNRGBA(image.Rect(0, 0, width, rows))
+	_, t, _ := f.Orientation()
+	for y := 0; y < rows; y++ {
+		src := img.Data[uint64(y)*stride:]
+		dy := y
+		if t == 'u' {
+			// Flip within each depth slice.
+			slice := int(img.Height)
+			dy = y/slice*slice + slice - 1 - y%slice
+		}
+		dst := out.Pix[dy*out.Stride:]
+		for x := 0; x < width; x++ {
+			p := dst[x*4 : x*4+4]
+			p[3] = 255
+			for c, o := range order {
+				p[o] = read(src[(x*len(order)+c)*size:])
+			}
+		}
+	}
+	return out, nil
+}
+
+func unorm8(v float32) uint8 {
+	return uint8(math.Max(0, math.Min(1, float64(v)))*255 + 0.5)
+}
+
+// typeBytes returns the size of one component of an unpacked type.
+func typeBytes(typ uint32) uint32 {
+	switch typ {
+	case gl.UNSIGNED_BYTE, gl.BYTE:
+		return 1
+	case gl.UNSIGNED_SHORT, gl.SHORT, gl.HALF_FLOAT:
+		return 2
+	case gl.UNSIGNED_INT, gl.INT, gl.FLOAT:
+		return 4
+	}
+	return 0
+}
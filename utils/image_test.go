@@ -0,0 +1,76 @@
+// image_test.go
+package utils
+
+import (
+	gl "github.com/chsc/gogl/gl42"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+)
+
+func checker(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if (x+y)%2 == 0 {
+				img.Set(x, y, color.NRGBA{255, 255, 255, 255})
+			} else {
+				img.Set(x, y, color.NRGBA{0, 0, 0, 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestNewImageKtx(t *testing.T) {
+	img := checker(8, 4)
+	img.Set(0, 0, color.NRGBA{255, 0, 0, 128})
+
+	f, err := NewImageKtx(img, &ImageOptions{Premultiply: true, FlipY: true, Mipmaps: MipBox})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Levels) != 4 || f.Levels[3].Width != 1 || f.Levels[3].Height != 1 {
+		t.Fatalf("%d levels", len(f.Levels))
+	}
+
+	// The top-left texel ends up in the last row, premultiplied.
+	base := f.Levels[0].Data
+	if got := base[3*8*4 : 3*8*4+4]; got[0] != 128 || got[1] != 0 || got[3] != 128 {
+		t.Errorf("flipped premultiplied texel = %v", got)
+	}
+
+	// A black and white checker averages to mid grey in every mip.
+	for i := 1; i < 3; i++ {
+		if g := f.Levels[i].Data[5]; g < 120 || g > 135 {
+			t.Errorf("level %d green = %d, want ~127", i, g)
+		}
+	}
+}
+
+func TestNewImageKtxSRGB(t *testing.T) {
+	for _, mip := range []MipFilter{MipBox, MipKaiser} {
+		f, err := NewImageKtx(checker(16, 16), &ImageOptions{SRGB: true, Mipmaps: mip})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Header.Glinternalformat != gl.SRGB8_ALPHA8 || len(f.Levels) != 5 {
+			t.Fatalf("format %#x with %d levels", f.Header.Glinternalformat, len(f.Levels))
+		}
+		// Averaging in linear space gives 0.5 linear, 188 once encoded.
+		if g := f.Levels[1].Data[20]; g < 180 || g > 195 {
+			t.Errorf("filter %d: level 1 = %d, want ~188", mip, g)
+		}
+	}
+}
+
+func TestLoadImageTextureDefaults(t *testing.T) {
+	if _, err := LoadImageTexture("missing.png", 0, nil); !os.IsNotExist(err) {
+		t.Errorf("missing file: %v", err)
+	}
+	f, err := NewImageKtx(checker(2, 2), nil)
+	if err != nil || f.Header.Miplevels != 0 {
+		t.Errorf("default options: %v, %+v", err, f.Header)
+	}
+}
@@ -2,17 +2,20 @@
 package utils
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
 	gl "github.com/chsc/gogl/gl42"
+	"io"
 	"io/ioutil"
-	"log"
-	"os"
-	"unsafe"
+	"math"
+	"math/bits"
 )
 
-type header struct {
+const (
+	ktxHeaderSize = 64
+)
+
+type KTXHeader struct {
 	Identifier           [12]byte
 	Endianness           uint32
 	Gltype               uint32
@@ -29,105 +32,165 @@ type header struct {
 	Keypairbytes         uint32
 }
 
+// KeyValue is a single entry of the key/value data that follows the header.
+type KeyValue struct {
+	Key   string
+	Value []byte
+}
+
+// KTXImage is one face of one array layer of a mip level.
+type KTXImage struct {
+	Level  int
+	Layer  int
+	Face   int
+	Width  uint32
+	Height uint32
+	Depth  uint32
+	Data   []byte
+}
+
+// KTXLevel is a mip level. Data spans every layer and face of the level,
+// Images slices it per layer and face (Images[layer*faces+face]).
+type KTXLevel struct {
+	Width  uint32
+	Height uint32
+	Depth  uint32
+	Data   []byte
+	Images []KTXImage
+}
+
+// KTXFile is a decoded KTX file. It holds no GL state.
+// Alignment is the row alignment of the image data, 4 as required by the
+// spec or 1 for the tightly packed SuperBible sample and KTX 2.0 files. 0
+// means 1.
+// KTX2 is only set for KTX 2.0 files, whose Header is derived from vkFormat.
+type KTXFile struct {
+	Header    KTXHeader
+	Target    gl.Enum
+	KeyValue  KeyValues
+	Levels    []KTXLevel
+	Alignment int
+	KTX2      *KTX2Info
+}
+
 var (
 	identifier = []byte{0xAB, 0x4B, 0x54, 0x58, 0x20, 0x31, 0x31, 0xBB, 0x0D, 0x0A, 0x1A, 0x0A}
 )
 
 func swap32(u32 uint32) uint32 {
-	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.LittleEndian, u32)
-	if err != nil {
-		log.Fatal("binary.Write failed:", err)
-	}
+	return bits.ReverseBytes32(u32)
+}
 
-	if buf.Len() != 4 {
-		log.Fatal("length error")
-	}
+func swap16(u16 uint16) uint16 {
+	return bits.ReverseBytes16(u16)
+}
 
-	u8 := buf.Bytes()
-	u8[0], u8[1], u8[2], u8[3] = u8[3], u8[2], u8[1], u8[0]
+func calcStride(h *KTXHeader, width, pad uint32) uint64 {
+	var stride uint64 = uint64(pixelSize(h)) * uint64(width)
+	stride = (stride + uint64(pad-1)) &^ uint64(pad-1)
 
-	err = binary.Read(buf, binary.LittleEndian, &u32)
-	if err != nil {
-		log.Fatal("binary.Read failed:", err)
-	}
-	return u32
+	return stride
 }
 
-func swap16(u16 uint16) uint16 {
-	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.LittleEndian, u16)
-	if err != nil {
-		log.Fatalln("binary.Write failed:", err)
+// guessTarget works out the texture target from the header dimensions.
+func guessTarget(h *KTXHeader) gl.Enum {
+	var target gl.Enum = gl.NONE
+
+	if h.Pixelheight == 0 {
+		if h.Arrayelements == 0 {
+			target = gl.TEXTURE_1D
+		} else {
+			target = gl.TEXTURE_1D_ARRAY
+		}
+	} else if h.Pixeldepth == 0 {
+		if h.Arrayelements == 0 {
+			if h.Faces != 6 {
+				target = gl.TEXTURE_2D
+			} else {
+				target = gl.TEXTURE_CUBE_MAP
+			}
+		} else {
+			if h.Faces != 6 {
+				target = gl.TEXTURE_2D_ARRAY
+			} else {
+				target = gl.TEXTURE_CUBE_MAP_ARRAY
+			}
+		}
+	} else {
+		target = gl.TEXTURE_3D
 	}
-	if buf.Len() != 2 {
-		log.Fatal("length error")
+
+	// Check for insanity...
+	if (h.Pixelwidth == 0) || // Texture has no width???
+		(h.Pixelheight == 0 && h.Pixeldepth != 0) { // Texture has depth but no height???
+		target = gl.NONE
 	}
 
-	u8 := buf.Bytes()
-	u8[0], u8[1] = u8[1], u8[0]
+	return target
+}
 
-	err = binary.Read(buf, binary.LittleEndian, &u16)
-	if err != nil {
-		log.Fatal("binary.Read failed:", err)
+func max1(v uint32) uint32 {
+	if v == 0 {
+		return 1
 	}
-	return u16
+	return v
 }
 
-func calcStride(h *header, width, pad uint32) uint32 {
-	var channels uint32 = 0
+// Layers returns the number of array layers, 1 for non-array textures.
+func (h *KTXHeader) Layers() uint32 {
+	return max1(h.Arrayelements)
+}
 
-	switch h.Glbaseinternalformat {
-	case gl.RED:
-		channels = 1
-	case gl.RG:
-		channels = 2
-	case gl.BGR, gl.RGB:
-		channels = 3
-	case gl.BGRA, gl.RGBA:
-		channels = 4
+// FaceCount returns the number of faces, 6 for cube maps and 1 otherwise.
+func (h *KTXHeader) FaceCount() uint32 {
+	if h.Faces == 6 {
+		return 6
 	}
-
-	var stride uint32 = h.Gltypesize * channels * width
-	stride = (stride + (pad - 1)) &^ (pad - 1)
-
-	return stride
+	return 1
 }
 
-func calcFaceSize(h *header) uint32 {
-	stride := calcStride(h, h.Pixelwidth, 4)
-	return stride * h.Pixelheight
+// Mips returns the number of mip levels stored in the file.
+func (h *KTXHeader) Mips() uint32 {
+	return max1(h.Miplevels)
 }
 
-func LoadKtx(filename string, tex gl.Uint) gl.Uint {
-	file, err := os.Open(filename)
-	if err != nil {
-		log.Fatal(err)
+// Image returns the image for the given mip level, array layer and cube face,
+// or nil if there is none.
+func (f *KTXFile) Image(level, layer, face int) *KTXImage {
+	if level < 0 || level >= len(f.Levels) {
+		return nil
 	}
-	defer file.Close()
-
-	h := new(header)
-	r := bufio.NewReader(file)
+	faces := int(f.Header.FaceCount())
+	if layer < 0 || face < 0 || face >= faces {
+		return nil
+	}
+	i := layer*faces + face
+	if i >= len(f.Levels[level].Images) {
+		return nil
+	}
+	return &f.Levels[level].Images[i]
+}
 
-	log.Println("header size:", unsafe.Sizeof(*h))
-	d, err := r.Peek(int(unsafe.Sizeof(*h)))
-	if err != nil {
-		log.Fatal(err)
+func parseKtxHeader(d []byte) (*KTXHeader, binary.ByteOrder, error) {
+	if len(d) < ktxHeaderSize {
+		return nil, nil, ktxErrorf("file too short")
 	}
 
 	if bytes.Compare(d[:12], identifier) != 0 {
-		log.Fatal("invalid file header")
+		return nil, nil, ktxErrorf("invalid file header")
 	}
 
-	if err := binary.Read(bytes.NewBuffer(d), binary.LittleEndian, h); err != nil {
-		log.Fatal(err)
+	h := new(KTXHeader)
+	if err := binary.Read(bytes.NewReader(d[:ktxHeaderSize]), binary.LittleEndian, h); err != nil {
+		return nil, nil, err
 	}
-	log.Printf("%#v\n", h)
 
+	var order binary.ByteOrder = binary.LittleEndian
 	if h.Endianness == 0x04030201 {
 		// No swap needed
 	} else if h.Endianness == 0x01020304 {
 		// Swap needed
+		order = binary.BigEndian
 		h.Endianness = swap32(h.Endianness)
 		h.Gltype = swap32(h.Gltype)
 		h.Gltypesize = swap32(h.Gltypesize)
@@ -142,131 +205,242 @@ func LoadKtx(filename string, tex gl.Uint) gl.Uint {
 		h.Miplevels = swap32(h.Miplevels)
 		h.Keypairbytes = swap32(h.Keypairbytes)
 	} else {
-		log.Fatal("invalid header field endianness:", h.Endianness)
+		return nil, nil, ktxErrorf("invalid header field endianness: %#x", h.Endianness)
 	}
 
-	var target gl.Enum = gl.NONE
-	// Guess target (texture type)
-	if h.Pixelheight == 0 {
-		if h.Arrayelements == 0 {
-			target = gl.TEXTURE_1D
-		} else {
-			target = gl.TEXTURE_1D_ARRAY
+	return h, order, nil
+}
+
+func parseKeyValue(d []byte, order binary.ByteOrder) (KeyValues, error) {
+	var kvs KeyValues
+
+	for len(d) >= 4 {
+		size := order.Uint32(d)
+		d = d[4:]
+		if uint64(size) > uint64(len(d)) {
+			return nil, ktxErrorf("key/value data truncated")
 		}
-	} else if h.Pixeldepth == 0 {
-		if h.Arrayelements == 0 {
-			if h.Faces == 0 {
-				target = gl.TEXTURE_2D
-			} else {
-				target = gl.TEXTURE_CUBE_MAP
-			}
-		} else {
-			if h.Faces == 0 {
-				target = gl.TEXTURE_2D_ARRAY
-			} else {
-				target = gl.TEXTURE_CUBE_MAP_ARRAY
-			}
+
+		kv := d[:size]
+		n := bytes.IndexByte(kv, 0)
+		if n < 0 {
+			return nil, ktxErrorf("key is not NUL terminated")
 		}
-	} else {
-		target = gl.TEXTURE_3D
+		kvs = append(kvs, KeyValue{Key: string(kv[:n]), Value: kv[n+1:]})
+
+		pad := (size + 3) &^ 3
+		if uint64(pad) > uint64(len(d)) {
+			pad = uint32(len(d))
+		}
+		d = d[pad:]
 	}
 
-	// Check for insanity...
-	if target == gl.NONE || // Couldn't figure out target
-		(h.Pixelwidth == 0) || // Texture has no width???
-		(h.Pixelheight == 0 && h.Pixeldepth != 0) { // Texture has depth but no height???
-		log.Fatal("invalid dimension")
+	return kvs, nil
+}
+
+// ParseKtx decodes a KTX 1.1 or 2.0 file without touching any GL state.
+func ParseKtx(r io.Reader) (*KTXFile, error) {
+	d, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
 	}
 
-	if tex == 0 {
-		gl.GenTextures(1, &tex)
+	if bytes.HasPrefix(d, identifier2) {
+		return parseKtx2(d)
 	}
-	gl.BindTexture(target, tex)
 
-	data, err := ioutil.ReadFile(filename)
+	h, order, err := parseKtxHeader(d)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	log.Println(filename, len(data))
-	data = data[unsafe.Sizeof(h):]
 
-	if h.Miplevels == 0 {
-		h.Miplevels = 1
+	f := &KTXFile{
+		Header: *h,
+		Target: guessTarget(h),
 	}
 
-	switch target {
-	case gl.TEXTURE_1D:
-		gl.TexStorage1D(target, gl.Sizei(h.Miplevels), gl.Enum(h.Glinternalformat), gl.Sizei(h.Pixelwidth))
-		gl.TexSubImage1D(target, 0, 0, gl.Sizei(h.Pixelwidth), gl.Enum(h.Glformat), gl.Enum(h.Glinternalformat), gl.Pointer(&data[0]))
+	d = d[ktxHeaderSize:]
+	if uint64(h.Keypairbytes) > uint64(len(d)) {
+		return nil, ktxErrorf("key/value data truncated")
+	}
+	if f.KeyValue, err = parseKeyValue(d[:h.Keypairbytes], order); err != nil {
+		return nil, err
+	}
+	d = d[h.Keypairbytes:]
 
-	case gl.TEXTURE_2D:
-		gl.TexStorage2D(target, gl.Sizei(h.Miplevels), gl.Enum(h.Glinternalformat), gl.Sizei(h.Pixelwidth), gl.Sizei(h.Pixelheight))
+	if err := checkLayout(h, uint64(len(d)), ktxErrorf); err != nil {
+		return nil, err
+	}
 
-		height := h.Pixelheight
-		width := h.Pixelwidth
-		gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
-		for i := 0; i < int(h.Miplevels); i++ {
-			gl.TexSubImage2D(target, gl.Int(i), 0, 0, gl.Sizei(width), gl.Sizei(height),
-				gl.Enum(h.Glformat), gl.Enum(h.Gltype), gl.Pointer(&data[0]))
+	// The SuperBible sample textures predate the imageSize fields and store
+	// tightly packed rows. Anything else is laid out as the spec says.
+	if uint64(len(d)) == dataSize(h, 1) {
+		f.Alignment = 1
+		err = parseLegacyLevels(f, d)
+	} else {
+		f.Alignment = 4
+		err = parseLevels(f, d, order)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-			data = data[height*calcStride(h, width, 1):]
-			//log.Println(height*calcStride(h, width, 1), len(data))
-			height >>= 1
-			width >>= 1
-			if height == 0 {
-				height = 1
-			}
-			if width == 0 {
-				width = 1
-			}
+	// Image data is made of glTypeSize units in the file's byte order, the
+	// rest of the package expects them little-endian.
+	if order == binary.BigEndian && !h.IsCompressed() {
+		for _, level := range f.Levels {
+			swapData(level.Data, h.Gltypesize)
+		}
+	}
+
+	return f, nil
+}
+
+// swapData reverses the bytes of every size byte unit of d in place. Sizes
+// other than 2 and 4 are left alone.
+func swapData(d []byte, size uint32) {
+	switch size {
+	case 2:
+		for i := 0; i+2 <= len(d); i += 2 {
+			binary.LittleEndian.PutUint16(d[i:], binary.BigEndian.Uint16(d[i:]))
 		}
+	case 4:
+		for i := 0; i+4 <= len(d); i += 4 {
+			binary.LittleEndian.PutUint32(d[i:], binary.BigEndian.Uint32(d[i:]))
+		}
+	}
+}
+
+// dataSize returns the size of all the images in the file, with rows padded
+// to pad bytes but no imageSize fields or mip padding.
+func dataSize(h *KTXHeader, pad uint32) uint64 {
+	var size uint64
 
-	case gl.TEXTURE_3D:
-		gl.TexStorage3D(target, gl.Sizei(h.Miplevels), gl.Enum(h.Glinternalformat),
-			gl.Sizei(h.Pixelwidth), gl.Sizei(h.Pixelheight), gl.Sizei(h.Pixeldepth))
-		gl.TexSubImage3D(target, 0, 0, 0, 0, gl.Sizei(h.Pixelwidth), gl.Sizei(h.Pixelheight),
-			gl.Sizei(h.Pixeldepth), gl.Enum(h.Glformat), gl.Enum(h.Gltype), gl.Pointer(&data[0]))
-
-	case gl.TEXTURE_1D_ARRAY:
-		gl.TexStorage2D(target, gl.Sizei(h.Miplevels), gl.Enum(h.Glinternalformat), gl.Sizei(h.Pixelwidth), gl.Sizei(h.Arrayelements))
-		gl.TexSubImage2D(target, 0, 0, 0, gl.Sizei(h.Pixelwidth), gl.Sizei(h.Arrayelements),
-			gl.Enum(h.Glformat), gl.Enum(h.Gltype), gl.Pointer(&data[0]))
-
-	case gl.TEXTURE_2D_ARRAY:
-		gl.TexStorage3D(target, gl.Sizei(h.Miplevels), gl.Enum(h.Glinternalformat),
-			gl.Sizei(h.Pixelwidth), gl.Sizei(h.Pixelheight), gl.Sizei(h.Arrayelements))
-		gl.TexSubImage3D(target, 0, 0, 0, 0, gl.Sizei(h.Pixelwidth),
-			gl.Sizei(h.Pixelheight), gl.Sizei(h.Arrayelements), gl.Enum(h.Glformat), gl.Enum(h.Gltype), gl.Pointer(&data[0]))
-
-	case gl.TEXTURE_CUBE_MAP:
-		gl.TexStorage2D(target, gl.Sizei(h.Miplevels), gl.Enum(h.Glinternalformat), gl.Sizei(h.Pixelwidth), gl.Sizei(h.Pixelheight))
-
-		face_size := calcFaceSize(h)
-		for i := 0; i < int(h.Faces); i++ {
-			d := data[int(face_size)*i:]
-			gl.TexSubImage2D(gl.Enum(gl.TEXTURE_CUBE_MAP_POSITIVE_X+i), 0, 0, 0, gl.Sizei(h.Pixelwidth), gl.Sizei(h.Pixelheight),
-				gl.Enum(h.Glformat), gl.Enum(h.Gltype), gl.Pointer(&d[0]))
+	images := uint64(h.Layers()) * uint64(h.FaceCount())
+	for i := 0; i < int(h.Mips()); i++ {
+		n := mulSize(faceSize(h, i, pad), images)
+		if size+n < size {
+			return math.MaxUint64
 		}
+		size += n
+	}
+	return size
+}
 
-	case gl.TEXTURE_CUBE_MAP_ARRAY:
-		gl.TexStorage3D(target, gl.Sizei(h.Miplevels), gl.Enum(h.Glinternalformat),
-			gl.Sizei(h.Pixelwidth), gl.Sizei(h.Pixelheight), gl.Sizei(h.Arrayelements))
-		gl.TexSubImage3D(target, 0, 0, 0, 0, gl.Sizei(h.Pixelwidth), gl.Sizei(h.Pixelheight), gl.Sizei(h.Faces*h.Arrayelements),
-			gl.Enum(h.Glformat), gl.Enum(h.Gltype), gl.Pointer(&data[0]))
+// checkLayout rejects a header whose counts and dimensions can't describe
+// limit bytes of image data, before anything is allocated or sliced on its
+// word. errorf makes the error of the calling parser.
+func checkLayout(h *KTXHeader, limit uint64, errorf func(string, ...interface{}) error) error {
+	if guessTarget(h) == gl.NONE {
+		return errorf("invalid dimension")
+	}
+	if h.Faces > 1 && h.Faces != 6 {
+		return errorf("numberOfFaces is %d, want 1 or 6", h.Faces)
+	}
+	if h.Faces == 6 && h.Pixelwidth != h.Pixelheight {
+		return errorf("cube map faces are %dx%d, want square", h.Pixelwidth, h.Pixelheight)
+	}
+	if full := uint32(fullMipCount(h)); h.Miplevels > full {
+		return errorf("numberOfMipmapLevels is %d, a %dx%dx%d texture has %d", h.Miplevels,
+			h.Pixelwidth, h.Pixelheight, h.Pixeldepth, full)
+	}
 
-	default: // Should never happen
-		log.Fatal("invalid target", target)
+	// Every image takes at least a byte, even in a format we can't size.
+	images := uint64(h.Layers()) * uint64(h.FaceCount()) * uint64(h.Mips())
+	if size := dataSize(h, 1); images > limit || size > limit {
+		return errorf("image data truncated")
 	}
+	return nil
+}
 
-	if h.Miplevels == 1 {
-		gl.GenerateMipmap(target)
+// newLevel returns mip level i with its images sliced out of data, imageSize
+// bytes each, every face starting at a multiple of faceStride.
+func newLevel(h *KTXHeader, i int, data []byte, imageSize, faceStride uint64) KTXLevel {
+	level := KTXLevel{
+		Width:  max1(h.Pixelwidth >> uint(i)),
+		Height: max1(h.Pixelheight >> uint(i)),
+		Depth:  max1(h.Pixeldepth >> uint(i)),
+		Data:   data,
 	}
 
-	return tex
+	layers := h.Layers()
+	faces := h.FaceCount()
+	for layer := uint32(0); layer < layers; layer++ {
+		for face := uint32(0); face < faces; face++ {
+			off := uint64(layer*faces+face) * faceStride
+			level.Images = append(level.Images, KTXImage{
+				Level:  i,
+				Layer:  int(layer),
+				Face:   int(face),
+				Width:  level.Width,
+				Height: level.Height,
+				Depth:  level.Depth,
+				Data:   data[off : off+imageSize],
+			})
+		}
+	}
+	return level
 }
 
-/*
-func SaveKtx(filename string, target gl.Uint, gl.Uint tex) bool {
+func parseLegacyLevels(f *KTXFile, d []byte) error {
+	h := &f.Header
+	images := uint64(h.Layers()) * uint64(h.FaceCount())
+
+	for i := 0; i < int(h.Mips()); i++ {
+		imageSize := faceSize(h, i, 1)
+		size := imageSize * images
+		if size > uint64(len(d)) {
+			return ktxErrorf("level %d truncated", i)
+		}
+
+		f.Levels = append(f.Levels, newLevel(h, i, d[:size], imageSize, imageSize))
+		d = d[size:]
+	}
+
+	return nil
+}
+
+func parseLevels(f *KTXFile, d []byte, order binary.ByteOrder) error {
+	h := &f.Header
+	images := uint64(h.Layers()) * uint64(h.FaceCount())
+
+	for i := 0; i < int(h.Mips()); i++ {
+		if len(d) < 4 {
+			return ktxErrorf("level %d truncated", i)
+		}
+		imageSize := uint64(order.Uint32(d))
+		d = d[4:]
+
+		// For non-array cube maps imageSize is the size of a single face,
+		// and every face is padded to 4 bytes.
+		want := faceSize(h, i, 4)
+		var faceBytes, faceStride, size uint64
+		if f.Target == gl.TEXTURE_CUBE_MAP {
+			faceBytes = imageSize
+			faceStride = (imageSize + 3) &^ 3
+			size = faceStride * images
+		} else {
+			faceBytes = imageSize / images
+			faceStride = faceBytes
+			size = imageSize
+			want *= images
+		}
+		// Formats we know nothing about are taken at their word.
+		if want != 0 && imageSize != want {
+			return ktxErrorf("level %d imageSize is %d, want %d", i, imageSize, want)
+		}
+		if size > uint64(len(d)) {
+			return ktxErrorf("level %d truncated", i)
+		}
+
+		f.Levels = append(f.Levels, newLevel(h, i, d[:size], faceBytes, faceStride))
+
+		size = (size + 3) &^ 3
+		if size > uint64(len(d)) {
+			size = uint64(len(d))
+		}
+		d = d[size:]
+	}
 
+	return nil
 }
-*/
@@ -0,0 +1,360 @@
+// ktx2
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	gl "github.com/chsc/gogl/gl42"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+const (
+	ktx2HeaderSize     = 80
+	ktx2LevelIndexSize = 24
+
+	// maxInflatedSize caps the image data a supercompressed file can expand
+	// to.
+	maxInflatedSize = 1 << 30
+)
+
+// KTX 2.0 supercompression schemes.
+const (
+	SupercompressionNone    = 0
+	SupercompressionBasisLZ = 1
+	SupercompressionZstd    = 2
+	SupercompressionZLIB    = 3
+)
+
+var (
+	identifier2 = []byte{0xAB, 0x4B, 0x54, 0x58, 0x20, 0x32, 0x30, 0xBB, 0x0D, 0x0A, 0x1A, 0x0A}
+)
+
+// KTX2Header is the fixed header and index of a KTX 2.0 file.
+type KTX2Header struct {
+	Identifier             [12]byte
+	VkFormat               uint32
+	TypeSize               uint32
+	PixelWidth             uint32
+	PixelHeight            uint32
+	PixelDepth             uint32
+	LayerCount             uint32
+	FaceCount              uint32
+	LevelCount             uint32
+	SupercompressionScheme uint32
+	DfdByteOffset          uint32
+	DfdByteLength          uint32
+	KvdByteOffset          uint32
+	KvdByteLength          uint32
+	SgdByteOffset          uint64
+	SgdByteLength          uint64
+}
+
+// KTX2LevelIndex locates one mip level in a KTX 2.0 file.
+type KTX2LevelIndex struct {
+	ByteOffset             uint64
+	ByteLength             uint64
+	UncompressedByteLength uint64
+}
+
+// KTX2DFD is the basic block of the Khronos Data Format Descriptor.
+type KTX2DFD struct {
+	ColorModel       uint8
+	ColorPrimaries   uint8
+	TransferFunction uint8
+	Flags            uint8
+	BlockDimension   [4]uint8
+	BytesPlane       [8]uint8
+}
+
+// KTX2Info holds the parts of a KTX 2.0 file that have no KTX 1.1 equivalent.
+type KTX2Info struct {
+	Header KTX2Header
+	Levels []KTX2LevelIndex
+	DFD    KTX2DFD
+}
+
+type vkFormatInfo struct {
+	internalformat gl.Enum
+	format         gl.Enum
+	typ            gl.Enum
+	typesize       uint32
+}
+
+var vkFormats = map[uint32]vkFormatInfo{
+	2:   {gl.RGBA4, gl.RGBA, gl.UNSIGNED_SHORT_4_4_4_4, 2},   // R4G4B4A4_UNORM_PACK16
+	4:   {gl.RGB565, gl.RGB, gl.UNSIGNED_SHORT_5_6_5, 2},     // R5G6B5_UNORM_PACK16
+	6:   {gl.RGB5_A1, gl.RGBA, gl.UNSIGNED_SHORT_5_5_5_1, 2}, // R5G5B5A1_UNORM_PACK16
+	9:   {gl.R8, gl.RED, gl.UNSIGNED_BYTE, 1},
+	10:  {gl.R8_SNORM, gl.RED, gl.BYTE, 1},
+	13:  {gl.R8UI, gl.RED_INTEGER, gl.UNSIGNED_BYTE, 1},
+	14:  {gl.R8I, gl.RED_INTEGER, gl.BYTE, 1},
+	16:  {gl.RG8, gl.RG, gl.UNSIGNED_BYTE, 1},
+	17:  {gl.RG8_SNORM, gl.RG, gl.BYTE, 1},
+	20:  {gl.RG8UI, gl.RG_INTEGER, gl.UNSIGNED_BYTE, 1},
+	21:  {gl.RG8I, gl.RG_INTEGER, gl.BYTE, 1},
+	23:  {gl.RGB8, gl.RGB, gl.UNSIGNED_BYTE, 1},
+	24:  {gl.RGB8_SNORM, gl.RGB, gl.BYTE, 1},
+	27:  {gl.RGB8UI, gl.RGB_INTEGER, gl.UNSIGNED_BYTE, 1},
+	28:  {gl.RGB8I, gl.RGB_INTEGER, gl.BYTE, 1},
+	29:  {gl.SRGB8, gl.RGB, gl.UNSIGNED_BYTE, 1},
+	30:  {gl.RGB8, gl.BGR, gl.UNSIGNED_BYTE, 1},  // B8G8R8_UNORM
+	36:  {gl.SRGB8, gl.BGR, gl.UNSIGNED_BYTE, 1}, // B8G8R8_SRGB
+	37:  {gl.RGBA8, gl.RGBA, gl.UNSIGNED_BYTE, 1},
+	38:  {gl.RGBA8_SNORM, gl.RGBA, gl.BYTE, 1},
+	41:  {gl.RGBA8UI, gl.RGBA_INTEGER, gl.UNSIGNED_BYTE, 1},
+	42:  {gl.RGBA8I, gl.RGBA_INTEGER, gl.BYTE, 1},
+	43:  {gl.SRGB8_ALPHA8, gl.RGBA, gl.UNSIGNED_BYTE, 1},
+	44:  {gl.RGBA8, gl.BGRA, gl.UNSIGNED_BYTE, 1},        // B8G8R8A8_UNORM
+	50:  {gl.SRGB8_ALPHA8, gl.BGRA, gl.UNSIGNED_BYTE, 1}, // B8G8R8A8_SRGB
+	64:  {gl.RGB10_A2, gl.RGBA, gl.UNSIGNED_INT_2_10_10_10_REV, 4},
+	68:  {gl.RGB10_A2UI, gl.RGBA_INTEGER, gl.UNSIGNED_INT_2_10_10_10_REV, 4},
+	70:  {gl.R16, gl.RED, gl.UNSIGNED_SHORT, 2},
+	71:  {gl.R16_SNORM, gl.RED, gl.SHORT, 2},
+	74:  {gl.R16UI, gl.RED_INTEGER, gl.UNSIGNED_SHORT, 2},
+	75:  {gl.R16I, gl.RED_INTEGER, gl.SHORT, 2},
+	76:  {gl.R16F, gl.RED, gl.HALF_FLOAT, 2},
+	77:  {gl.RG16, gl.RG, gl.UNSIGNED_SHORT, 2},
+	78:  {gl.RG16_SNORM, gl.RG, gl.SHORT, 2},
+	81:  {gl.RG16UI, gl.RG_INTEGER, gl.UNSIGNED_SHORT, 2},
+	82:  {gl.RG16I, gl.RG_INTEGER, gl.SHORT, 2},
+	83:  {gl.RG16F, gl.RG, gl.HALF_FLOAT, 2},
+	84:  {gl.RGB16, gl.RGB, gl.UNSIGNED_SHORT, 2},
+	85:  {gl.RGB16_SNORM, gl.RGB, gl.SHORT, 2},
+	88:  {gl.RGB16UI, gl.RGB_INTEGER, gl.UNSIGNED_SHORT, 2},
+	89:  {gl.RGB16I, gl.RGB_INTEGER, gl.SHORT, 2},
+	90:  {gl.RGB16F, gl.RGB, gl.HALF_FLOAT, 2},
+	91:  {gl.RGBA16, gl.RGBA, gl.UNSIGNED_SHORT, 2},
+	92:  {gl.RGBA16_SNORM, gl.RGBA, gl.SHORT, 2},
+	95:  {gl.RGBA16UI, gl.RGBA_INTEGER, gl.UNSIGNED_SHORT, 2},
+	96:  {gl.RGBA16I, gl.RGBA_INTEGER, gl.SHORT, 2},
+	97:  {gl.RGBA16F, gl.RGBA, gl.HALF_FLOAT, 2},
+	98:  {gl.R32UI, gl.RED_INTEGER, gl.UNSIGNED_INT, 4},
+	99:  {gl.R32I, gl.RED_INTEGER, gl.INT, 4},
+	100: {gl.R32F, gl.RED, gl.FLOAT, 4},
+	101: {gl.RG32UI, gl.RG_INTEGER, gl.UNSIGNED_INT, 4},
+	102: {gl.RG32I, gl.RG_INTEGER, gl.INT, 4},
+	103: {gl.RG32F, gl.RG, gl.FLOAT, 4},
+	104: {gl.RGB32UI, gl.RGB_INTEGER, gl.UNSIGNED_INT, 4},
+	105: {gl.RGB32I, gl.RGB_INTEGER, gl.INT, 4},
+	106: {gl.RGB32F, gl.RGB, gl.FLOAT, 4},
+	107: {gl.RGBA32UI, gl.RGBA_INTEGER, gl.UNSIGNED_INT, 4},
+	108: {gl.RGBA32I, gl.RGBA_INTEGER, gl.INT, 4},
+	109: {gl.RGBA32F, gl.RGBA, gl.FLOAT, 4},
+	122: {gl.R11F_G11F_B10F, gl.RGB, gl.UNSIGNED_INT_10F_11F_11F_REV, 4},
+	123: {gl.RGB9_E5, gl.RGB, gl.UNSIGNED_INT_5_9_9_9_REV, 4},
+	124: {gl.DEPTH_COMPONENT16, gl.DEPTH_COMPONENT, gl.UNSIGNED_SHORT, 2},
+	125: {gl.DEPTH_COMPONENT24, gl.DEPTH_COMPONENT, gl.UNSIGNED_INT, 4},
+	126: {gl.DEPTH_COMPONENT32F, gl.DEPTH_COMPONENT, gl.FLOAT, 4},
+	129: {gl.DEPTH24_STENCIL8, gl.DEPTH_STENCIL, gl.UNSIGNED_INT_24_8, 4},
+
+	// Compressed formats have no client format or type.
+	131: {glCompressedRGBS3TCDXT1, 0, 0, 1},       // BC1_RGB_UNORM_BLOCK
+	132: {glCompressedSRGBS3TCDXT1, 0, 0, 1},      // BC1_RGB_SRGB_BLOCK
+	133: {glCompressedRGBAS3TCDXT1, 0, 0, 1},      // BC1_RGBA_UNORM_BLOCK
+	134: {glCompressedSRGBAlphaS3TCDXT1, 0, 0, 1}, // BC1_RGBA_SRGB_BLOCK
+	135: {glCompressedRGBAS3TCDXT3, 0, 0, 1},      // BC2_UNORM_BLOCK
+	136: {glCompressedSRGBAlphaS3TCDXT3, 0, 0, 1}, // BC2_SRGB_BLOCK
+	137: {glCompressedRGBAS3TCDXT5, 0, 0, 1},      // BC3_UNORM_BLOCK
+	138: {glCompressedSRGBAlphaS3TCDXT5, 0, 0, 1}, // BC3_SRGB_BLOCK
+	139: {gl.COMPRESSED_RED_RGTC1, 0, 0, 1},
+	140: {gl.COMPRESSED_SIGNED_RED_RGTC1, 0, 0, 1},
+	141: {gl.COMPRESSED_RG_RGTC2, 0, 0, 1},
+	142: {gl.COMPRESSED_SIGNED_RG_RGTC2, 0, 0, 1},
+	143: {gl.COMPRESSED_RGB_BPTC_UNSIGNED_FLOAT, 0, 0, 1},
+	144: {gl.COMPRESSED_RGB_BPTC_SIGNED_FLOAT, 0, 0, 1},
+	145: {gl.COMPRESSED_RGBA_BPTC_UNORM, 0, 0, 1},
+	146: {gl.COMPRESSED_SRGB_ALPHA_BPTC_UNORM, 0, 0, 1},
+	147: {glCompressedRGB8ETC2, 0, 0, 1},
+	148: {glCompressedSRGB8ETC2, 0, 0, 1},
+	149: {glCompressedRGB8PunchthroughAlpha1ETC2, 0, 0, 1},
+	150: {glCompressedSRGB8PunchthroughAlpha1ETC2, 0, 0, 1},
+	151: {glCompressedRGBA8ETC2EAC, 0, 0, 1},
+	152: {glCompressedSRGB8Alpha8ETC2EAC, 0, 0, 1},
+	153: {glCompressedR11EAC, 0, 0, 1},
+	154: {glCompressedSignedR11EAC, 0, 0, 1},
+	155: {glCompressedRG11EAC, 0, 0, 1},
+	156: {glCompressedSignedRG11EAC, 0, 0, 1},
+}
+
+func init() {
+	// VK_FORMAT_ASTC_4x4_UNORM_BLOCK onwards alternate UNORM and SRGB.
+	for i := range astcBlocks {
+		vkFormats[uint32(157+2*i)] = vkFormatInfo{gl.Enum(glCompressedRGBAASTC4x4 + i), 0, 0, 1}
+		vkFormats[uint32(158+2*i)] = vkFormatInfo{gl.Enum(glCompressedSRGB8Alpha8ASTC4x4 + i), 0, 0, 1}
+	}
+}
+
+func parseDFD(d []byte) KTX2DFD {
+	var dfd KTX2DFD
+
+	// dfdTotalSize, then the basic block: vendor/type and version/size
+	// words followed by the fields below.
+	if len(d) < 4+8+16 {
+		return dfd
+	}
+	b := d[4+8:]
+	dfd.ColorModel = b[0]
+	dfd.ColorPrimaries = b[1]
+	dfd.TransferFunction = b[2]
+	dfd.Flags = b[3]
+	copy(dfd.BlockDimension[:], b[4:8])
+	copy(dfd.BytesPlane[:], b[8:16])
+
+	return dfd
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func inflateLevel(scheme uint32, level int, src []byte, size uint64) ([]byte, error) {
+	var d []byte
+	var err error
+
+	switch scheme {
+	case SupercompressionNone:
+		return src, nil
+	case SupercompressionZstd:
+		zstdOnce.Do(func() {
+			zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxInflatedSize))
+		})
+		if zstdErr != nil {
+			return nil, zstdErr
+		}
+		d, err = zstdDecoder.DecodeAll(src, nil)
+	case SupercompressionZLIB:
+		var r io.ReadCloser
+		if r, err = zlib.NewReader(bytes.NewReader(src)); err == nil {
+			// One byte past size is enough to tell the level is too long.
+			d, err = ioutil.ReadAll(io.LimitReader(r, int64(size)+1))
+			r.Close()
+		}
+	default:
+		return nil, ktxErrorf("unsupported supercompression scheme %d", scheme)
+	}
+	if err != nil {
+		return nil, ktxErrorf("level %d: %v", level, err)
+	}
+	if uint64(len(d)) != size {
+		return nil, ktxErrorf("level %d inflated to %d bytes, want %d", level, len(d), size)
+	}
+
+	return d, nil
+}
+
+func section(d []byte, off, length uint64) ([]byte, error) {
+	if off > uint64(len(d)) || length > uint64(len(d))-off {
+		return nil, ktxErrorf("section out of range")
+	}
+	return d[off : off+length], nil
+}
+
+// parseKtx2 decodes a KTX 2.0 file into the same representation as a KTX 1.1
+// file, with the GL format fields derived from vkFormat.
+func parseKtx2(d []byte) (*KTXFile, error) {
+	if len(d) < ktx2HeaderSize {
+		return nil, ktxErrorf("file too short")
+	}
+
+	info := new(KTX2Info)
+	if err := binary.Read(bytes.NewReader(d[:ktx2HeaderSize]), binary.LittleEndian, &info.Header); err != nil {
+		return nil, err
+	}
+	k := &info.Header
+
+	levels := max1(k.LevelCount)
+	index, err := section(d, ktx2HeaderSize, uint64(levels)*ktx2LevelIndexSize)
+	if err != nil {
+		return nil, err
+	}
+	info.Levels = make([]KTX2LevelIndex, levels)
+	if err := binary.Read(bytes.NewReader(index), binary.LittleEndian, info.Levels); err != nil {
+		return nil, err
+	}
+
+	dfd, err := section(d, uint64(k.DfdByteOffset), uint64(k.DfdByteLength))
+	if err != nil {
+		return nil, err
+	}
+	info.DFD = parseDFD(dfd)
+
+	vk, ok := vkFormats[k.VkFormat]
+	if !ok {
+		return nil, ktxErrorf("unsupported vkFormat %d", k.VkFormat)
+	}
+
+	f := &KTXFile{
+		Header: KTXHeader{
+			Endianness:           0x04030201,
+			Gltype:               uint32(vk.typ),
+			Gltypesize:           vk.typesize,
+			Glformat:             uint32(vk.format),
+			Glinternalformat:     uint32(vk.internalformat),
+			Glbaseinternalformat: uint32(baseFormat(vk.internalformat)),
+			Pixelwidth:           k.PixelWidth,
+			Pixelheight:          k.PixelHeight,
+			Pixeldepth:           k.PixelDepth,
+			Arrayelements:        k.LayerCount,
+			Faces:                k.FaceCount,
+			Miplevels:            k.LevelCount,
+			Keypairbytes:         k.KvdByteLength,
+		},
+		Alignment: 1,
+		KTX2:      info,
+	}
+	copy(f.Header.Identifier[:], identifier2)
+	h := &f.Header
+
+	// Supercompressed levels may not inflate past maxInflatedSize, plain
+	// ones have to fit in the file.
+	f.Target = guessTarget(h)
+	limit := uint64(len(d))
+	if k.SupercompressionScheme != SupercompressionNone {
+		limit = maxInflatedSize
+	}
+	if err := checkLayout(h, limit, ktxErrorf); err != nil {
+		return nil, err
+	}
+	// Every image gets a KTXImage, so a tiny supercompressed file can't be
+	// allowed to claim more images than it has bytes.
+	if n := uint64(h.Layers()) * uint64(h.FaceCount()); n > uint64(len(d)) {
+		return nil, ktxErrorf("%d images in a %d byte file", n, len(d))
+	}
+
+	kvd, err := section(d, uint64(k.KvdByteOffset), uint64(k.KvdByteLength))
+	if err != nil {
+		return nil, err
+	}
+	if f.KeyValue, err = parseKeyValue(kvd, binary.LittleEndian); err != nil {
+		return nil, err
+	}
+
+	// KTX 2.0 rows are tightly packed.
+	images := h.Layers() * h.FaceCount()
+	for i, l := range info.Levels {
+		imageSize := faceSize(h, i, 1)
+		size := imageSize * uint64(images)
+
+		src, err := section(d, l.ByteOffset, l.ByteLength)
+		if err != nil {
+			return nil, ktxErrorf("level %d out of range", i)
+		}
+		data, err := inflateLevel(k.SupercompressionScheme, i, src, size)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(data)) != size {
+			return nil, ktxErrorf("level %d is %d bytes, want %d", i, len(data), size)
+		}
+
+		f.Levels = append(f.Levels, newLevel(h, i, data, imageSize, imageSize))
+	}
+
+	return f, nil
+}
@@ -0,0 +1,160 @@
+// ktx2_test.go
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	gl "github.com/chsc/gogl/gl42"
+	"github.com/klauspost/compress/zstd"
+	"strings"
+	"testing"
+)
+
+func compressLevel(t testing.TB, scheme uint32, d []byte) []byte {
+	switch scheme {
+	case SupercompressionZstd:
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer enc.Close()
+		return enc.EncodeAll(d, nil)
+	case SupercompressionZLIB:
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write(d)
+		w.Close()
+		return buf.Bytes()
+	}
+	return d
+}
+
+// buildKtx2 lays out a KTX 2.0 file with the level data stored smallest
+// level first, as the spec recommends.
+func buildKtx2(t testing.TB, k KTX2Header, kv []KeyValue, levels [][]byte) []byte {
+	k.LevelCount = uint32(len(levels))
+	copy(k.Identifier[:], identifier2)
+
+	dfd := make([]byte, 4+8+16)
+	binary.LittleEndian.PutUint32(dfd, uint32(len(dfd)))
+	dfd[12] = 1 // KHR_DF_MODEL_RGBSDA
+	dfd[14] = 2 // KHR_DF_TRANSFER_SRGB
+	kvd := encodeKeyValue(kv)
+
+	off := uint64(ktx2HeaderSize + ktx2LevelIndexSize*len(levels))
+	k.DfdByteOffset, k.DfdByteLength = uint32(off), uint32(len(dfd))
+	off += uint64(len(dfd))
+	k.KvdByteOffset, k.KvdByteLength = uint32(off), uint32(len(kvd))
+	off += uint64(len(kvd))
+
+	index := make([]KTX2LevelIndex, len(levels))
+	var data []byte
+	for i := len(levels) - 1; i >= 0; i-- {
+		c := compressLevel(t, k.SupercompressionScheme, levels[i])
+		index[i] = KTX2LevelIndex{off + uint64(len(data)), uint64(len(c)), uint64(len(levels[i]))}
+		data = append(data, c...)
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &k)
+	binary.Write(&buf, binary.LittleEndian, index)
+	buf.Write(dfd)
+	buf.Write(kvd)
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func TestParseKtx2(t *testing.T) {
+	levels := [][]byte{fill(3*3*3, 0), fill(3, 50)}
+
+	for _, scheme := range []uint32{SupercompressionNone, SupercompressionZstd, SupercompressionZLIB} {
+		d := buildKtx2(t, KTX2Header{
+			VkFormat:               29, // VK_FORMAT_R8G8B8_SRGB
+			TypeSize:               1,
+			PixelWidth:             3,
+			PixelHeight:            3,
+			FaceCount:              1,
+			SupercompressionScheme: scheme,
+		}, []KeyValue{{"KTXwriter", []byte("gogl\x00")}}, levels)
+
+		f, err := ParseKtx(bytes.NewReader(d))
+		if err != nil {
+			t.Fatalf("scheme %d: %v", scheme, err)
+		}
+		if f.KTX2 == nil || f.KTX2.DFD.TransferFunction != 2 {
+			t.Errorf("scheme %d: KTX2 info = %+v", scheme, f.KTX2)
+		}
+		if f.Target != gl.TEXTURE_2D || f.Alignment != 1 {
+			t.Errorf("scheme %d: target %#x alignment %d", scheme, f.Target, f.Alignment)
+		}
+		if f.Header.Glinternalformat != gl.SRGB8 || f.Header.Glformat != gl.RGB || f.Header.Gltype != gl.UNSIGNED_BYTE {
+			t.Errorf("scheme %d: formats %+v", scheme, f.Header)
+		}
+		for i := range levels {
+			if !bytes.Equal(f.Levels[i].Data, levels[i]) {
+				t.Errorf("scheme %d: level %d differs", scheme, i)
+			}
+		}
+		if len(f.KeyValue) != 1 || f.KeyValue[0].Key != "KTXwriter" {
+			t.Errorf("scheme %d: key/value = %+v", scheme, f.KeyValue)
+		}
+	}
+}
+
+func TestParseKtx2Compressed(t *testing.T) {
+	// 5x5 BC7 needs 2x2 blocks of 16 bytes.
+	d := buildKtx2(t, KTX2Header{
+		VkFormat:    145, // VK_FORMAT_BC7_UNORM_BLOCK
+		TypeSize:    1,
+		PixelWidth:  5,
+		PixelHeight: 5,
+		FaceCount:   1,
+	}, nil, [][]byte{fill(64, 0)})
+
+	f, err := ParseKtx(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Header.IsCompressed() || f.Header.Glinternalformat != gl.COMPRESSED_RGBA_BPTC_UNORM ||
+		f.Header.Glbaseinternalformat != gl.RGBA {
+		t.Errorf("header = %+v", f.Header)
+	}
+	if len(f.Levels[0].Data) != 64 {
+		t.Errorf("level 0 is %d bytes, want 64", len(f.Levels[0].Data))
+	}
+}
+
+func TestParseKtx2Errors(t *testing.T) {
+	good := KTX2Header{VkFormat: 37, TypeSize: 1, PixelWidth: 2, PixelHeight: 2, FaceCount: 1}
+
+	bad := good
+	bad.VkFormat = 1000
+	if _, err := ParseKtx(bytes.NewReader(buildKtx2(t, bad, nil, [][]byte{fill(16, 0)}))); err == nil {
+		t.Error("unknown vkFormat should fail")
+	}
+
+	bad = good
+	bad.SupercompressionScheme = SupercompressionBasisLZ
+	if _, err := ParseKtx(bytes.NewReader(buildKtx2(t, bad, nil, [][]byte{fill(16, 0)}))); err == nil {
+		t.Error("BasisLZ should be rejected")
+	}
+
+	if _, err := ParseKtx(bytes.NewReader(buildKtx2(t, good, nil, [][]byte{fill(12, 0)}))); err == nil {
+		t.Error("short level should fail")
+	}
+
+	// A 1 GiB R8 array of 1x1 layers that zstd would inflate from a few KB.
+	layers := good
+	layers.VkFormat, layers.PixelWidth, layers.PixelHeight, layers.LayerCount = 9, 1, 1, 1<<30
+	layers.SupercompressionScheme = SupercompressionZstd
+	if _, err := ParseKtx(bytes.NewReader(buildKtx2(t, layers, nil, [][]byte{fill(16, 0)}))); err == nil ||
+		!strings.Contains(err.Error(), "images in a") {
+		t.Errorf("layer count: %v", err)
+	}
+
+	d := buildKtx2(t, good, nil, [][]byte{fill(16, 0)})
+	if _, err := ParseKtx(bytes.NewReader(d[:len(d)-1])); err == nil {
+		t.Error("truncated file should fail")
+	}
+}
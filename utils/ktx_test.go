@@ -3,7 +3,10 @@ package utils
 
 import (
 	gl "github.com/chsc/gogl/gl42"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -13,5 +16,70 @@ func init() {
 
 func TestLoadKtx(t *testing.T) {
 	gl.Init()
-	LoadKtx("brick.ktx", 0)
+	if _, err := LoadKtx("brick.ktx", 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadKtxErrors(t *testing.T) {
+	if _, err := LoadKtx("missing.ktx", 0); !os.IsNotExist(err) {
+		t.Errorf("missing file: %v", err)
+	}
+
+	name := filepath.Join(t.TempDir(), "bad.ktx")
+	if err := ioutil.WriteFile(name, []byte("not a ktx file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadKtx(name, 0)
+	if e, ok := err.(*KtxFormatError); !ok || e.File != name {
+		t.Errorf("bad file: %#v", err)
+	}
+}
+
+func TestParseKtx(t *testing.T) {
+	file, err := os.Open("brick.ktx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	f, err := ParseKtx(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Target != gl.TEXTURE_2D {
+		t.Errorf("target = %#x, want TEXTURE_2D", f.Target)
+	}
+	if f.Header.Pixelwidth != 512 || f.Header.Pixelheight != 512 {
+		t.Errorf("size = %dx%d, want 512x512", f.Header.Pixelwidth, f.Header.Pixelheight)
+	}
+	if len(f.Levels) != 10 {
+		t.Fatalf("levels = %d, want 10", len(f.Levels))
+	}
+
+	last := f.Image(9, 0, 0)
+	if last == nil || last.Width != 1 || last.Height != 1 || len(last.Data) != 3 {
+		t.Errorf("level 9 = %+v, want a single RGB texel", last)
+	}
+	if f.Image(0, 0, 1) != nil || f.Image(10, 0, 0) != nil {
+		t.Error("out of range image lookup should return nil")
+	}
+}
+
+func TestFullMipCount(t *testing.T) {
+	tests := []struct {
+		h    KTXHeader
+		want int
+	}{
+		{KTXHeader{Pixelwidth: 1}, 1},
+		{KTXHeader{Pixelwidth: 512, Pixelheight: 512}, 10},
+		{KTXHeader{Pixelwidth: 5, Pixelheight: 300}, 9},
+		{KTXHeader{Pixelwidth: 4, Pixelheight: 4, Pixeldepth: 16}, 5},
+	}
+	for _, tt := range tests {
+		if got := fullMipCount(&tt.h); got != tt.want {
+			t.Errorf("%dx%dx%d: got %d, want %d", tt.h.Pixelwidth, tt.h.Pixelheight, tt.h.Pixeldepth, got, tt.want)
+		}
+	}
 }
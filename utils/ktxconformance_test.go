@@ -0,0 +1,257 @@
+// ktxconformance_test.go
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	gl "github.com/chsc/gogl/gl42"
+	"testing"
+)
+
+// ktxCase is a file of the conformance corpus. Images holds every image of
+// every level in KTX order (Images[level][layer*faces+face]), rows padded
+// to 4 bytes.
+type ktxCase struct {
+	name   string
+	header KTXHeader
+	kv     []KeyValue
+	target gl.Enum
+	images [][][]byte
+}
+
+// specKtx encodes c by following the KTX 1.1 spec to the letter. It is kept
+// apart from WriteKtx so the two can be checked against each other.
+func specKtx(c *ktxCase, order binary.ByteOrder) []byte {
+	h := c.header
+	copy(h.Identifier[:], identifier)
+	h.Endianness = 0x04030201
+
+	var kv bytes.Buffer
+	for _, e := range c.kv {
+		size := uint32(len(e.Key) + 1 + len(e.Value))
+		binary.Write(&kv, order, size)
+		kv.WriteString(e.Key)
+		kv.WriteByte(0)
+		kv.Write(e.Value)
+		kv.Write(make([]byte, 3-(size+3)%4)) // valuePadding
+	}
+	h.Keypairbytes = uint32(kv.Len())
+
+	var buf bytes.Buffer
+	binary.Write(&buf, order, &h)
+	buf.Write(kv.Bytes())
+
+	cube := h.Faces == 6 && h.Arrayelements == 0
+	for _, images := range c.images {
+		var imageSize int
+		if cube {
+			imageSize = len(images[0])
+		} else {
+			for _, img := range images {
+				imageSize += len(img)
+			}
+		}
+		binary.Write(&buf, order, uint32(imageSize))
+
+		for _, img := range images {
+			buf.Write(img)
+			if cube {
+				buf.Write(make([]byte, 3-(len(img)+3)%4)) // cubePadding
+			}
+		}
+		buf.Write(make([]byte, 3-(imageSize+3)%4)) // mipPadding
+	}
+
+	return buf.Bytes()
+}
+
+// ktxCorpus generates the conformance corpus.
+func ktxCorpus() []*ktxCase {
+	seed := byte(0)
+	images := func(n, size int) [][]byte {
+		var imgs [][]byte
+		for i := 0; i < n; i++ {
+			imgs = append(imgs, fill(size, seed))
+			seed += 37
+		}
+		return imgs
+	}
+	rgba8 := KTXHeader{Gltype: gl.UNSIGNED_BYTE, Gltypesize: 1, Glformat: gl.RGBA, Glinternalformat: gl.RGBA8, Glbaseinternalformat: gl.RGBA}
+	rgb8 := KTXHeader{Gltype: gl.UNSIGNED_BYTE, Gltypesize: 1, Glformat: gl.RGB, Glinternalformat: gl.RGB8, Glbaseinternalformat: gl.RGB}
+	r16 := KTXHeader{Gltype: gl.UNSIGNED_SHORT, Gltypesize: 2, Glformat: gl.RED, Glinternalformat: gl.R16, Glbaseinternalformat: gl.RED}
+	r32f := KTXHeader{Gltype: gl.FLOAT, Gltypesize: 4, Glformat: gl.RED, Glinternalformat: gl.R32F, Glbaseinternalformat: gl.RED}
+	rgb565 := KTXHeader{Gltype: gl.UNSIGNED_SHORT_5_6_5, Gltypesize: 2, Glformat: gl.RGB, Glinternalformat: gl.RGB565, Glbaseinternalformat: gl.RGB}
+	bc1 := KTXHeader{Gltypesize: 1, Glinternalformat: glCompressedRGBS3TCDXT1, Glbaseinternalformat: gl.RGB}
+
+	with := func(h KTXHeader, w, ht, d, layers, faces, mips uint32) KTXHeader {
+		h.Pixelwidth, h.Pixelheight, h.Pixeldepth = w, ht, d
+		h.Arrayelements, h.Faces, h.Miplevels = layers, faces, mips
+		return h
+	}
+
+	return []*ktxCase{
+		{name: "1d", header: with(rgba8, 4, 0, 0, 0, 1, 3), target: gl.TEXTURE_1D,
+			images: [][][]byte{images(1, 16), images(1, 8), images(1, 4)}},
+		{name: "1d-array-rgb", header: with(rgb8, 2, 0, 0, 3, 1, 2), target: gl.TEXTURE_1D_ARRAY,
+			images: [][][]byte{images(3, 8), images(3, 4)}},
+		// 3 texel RGB rows pad from 9 to 12 bytes, the 1x1 level needs mipPadding.
+		{name: "2d-rgb-odd", header: with(rgb8, 3, 3, 0, 0, 1, 2), target: gl.TEXTURE_2D,
+			kv:     []KeyValue{{"KTXorientation", []byte("S=r,T=d\x00")}, {"odd", []byte{1, 2, 3}}},
+			images: [][][]byte{images(1, 36), images(1, 4)}},
+		{name: "2d-r16", header: with(r16, 3, 2, 0, 0, 1, 2), target: gl.TEXTURE_2D,
+			images: [][][]byte{images(1, 16), images(1, 4)}},
+		{name: "2d-r32f", header: with(r32f, 2, 2, 0, 0, 1, 2), target: gl.TEXTURE_2D,
+			images: [][][]byte{images(1, 16), images(1, 4)}},
+		// Packed 16-bit texels, 3 of them padded to 8 bytes a row.
+		{name: "2d-rgb565", header: with(rgb565, 3, 2, 0, 0, 1, 1), target: gl.TEXTURE_2D,
+			images: [][][]byte{images(1, 16)}},
+		{name: "3d", header: with(rgba8, 2, 2, 4, 0, 1, 3), target: gl.TEXTURE_3D,
+			images: [][][]byte{images(1, 64), images(1, 8), images(1, 4)}},
+		{name: "2d-array", header: with(rgba8, 2, 2, 0, 4, 1, 2), target: gl.TEXTURE_2D_ARRAY,
+			images: [][][]byte{images(4, 16), images(4, 4)}},
+		// Row padding keeps every face a multiple of 4 bytes, so cubePadding is
+		// empty, but imageSize covers a single face.
+		{name: "cube", header: with(rgb8, 2, 2, 0, 0, 6, 2), target: gl.TEXTURE_CUBE_MAP,
+			kv:     []KeyValue{{"KTXwriter", []byte("gogl\x00")}},
+			images: [][][]byte{images(6, 16), images(6, 4)}},
+		{name: "cube-array", header: with(rgba8, 2, 2, 0, 2, 6, 2), target: gl.TEXTURE_CUBE_MAP_ARRAY,
+			images: [][][]byte{images(12, 16), images(12, 4)}},
+		{name: "2d-bc1", header: with(bc1, 10, 6, 0, 0, 1, 3), target: gl.TEXTURE_2D,
+			images: [][][]byte{images(1, 48), images(1, 16), images(1, 8)}},
+		{name: "cube-bc1", header: with(bc1, 4, 4, 0, 0, 6, 1), target: gl.TEXTURE_CUBE_MAP,
+			images: [][][]byte{images(6, 8)}},
+	}
+}
+
+func checkCase(t *testing.T, c *ktxCase, f *KTXFile) {
+	if f.Target != c.target {
+		t.Errorf("%s: target = %#x, want %#x", c.name, f.Target, c.target)
+	}
+	if len(f.Levels) != len(c.images) {
+		t.Fatalf("%s: %d levels, want %d", c.name, len(f.Levels), len(c.images))
+	}
+	faces := int(c.header.FaceCount())
+	for i, images := range c.images {
+		for j, want := range images {
+			img := f.Image(i, j/faces, j%faces)
+			if img == nil || !bytes.Equal(img.Data, want) {
+				t.Errorf("%s: level %d image %d differs", c.name, i, j)
+			}
+		}
+	}
+	if len(f.KeyValue) != len(c.kv) {
+		t.Fatalf("%s: key/value = %+v", c.name, f.KeyValue)
+	}
+	for i, kv := range c.kv {
+		if f.KeyValue[i].Key != kv.Key || !bytes.Equal(f.KeyValue[i].Value, kv.Value) {
+			t.Errorf("%s: key/value %d = %+v", c.name, i, f.KeyValue[i])
+		}
+	}
+}
+
+func TestKtxConformance(t *testing.T) {
+	for _, c := range ktxCorpus() {
+		d := specKtx(c, binary.LittleEndian)
+		if len(d)%4 != 0 {
+			t.Errorf("%s: corpus file is %d bytes", c.name, len(d))
+		}
+
+		f, err := ParseKtx(bytes.NewReader(d))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		checkCase(t, c, f)
+
+		// The writer must reproduce the reference encoding exactly.
+		var buf bytes.Buffer
+		if err := WriteKtx(&buf, f); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		} else if !bytes.Equal(buf.Bytes(), d) {
+			t.Errorf("%s: WriteKtx output differs from the reference", c.name)
+		}
+	}
+}
+
+func TestKtxConformanceErrors(t *testing.T) {
+	c := ktxCorpus()[2]
+	d := specKtx(c, binary.LittleEndian)
+
+	// imageSize of the first level follows the header and key/value data.
+	off := ktxHeaderSize + int(binary.LittleEndian.Uint32(d[60:]))
+	bad := append([]byte(nil), d...)
+	binary.LittleEndian.PutUint32(bad[off:], 27)
+	if _, err := ParseKtx(bytes.NewReader(bad)); err == nil {
+		t.Error("unpadded imageSize should be rejected")
+	}
+
+	bad = append([]byte(nil), d...)
+	binary.LittleEndian.PutUint32(bad[60:], uint32(len(d)))
+	if _, err := ParseKtx(bytes.NewReader(bad)); err == nil {
+		t.Error("oversized key/value data should be rejected")
+	}
+
+	if _, err := ParseKtx(bytes.NewReader(d[:len(d)-8])); err == nil {
+		t.Error("truncated level should be rejected")
+	}
+}
+
+// bigEndian returns c as a big-endian writer would store it, every
+// glTypeSize unit of its images byte swapped.
+func bigEndian(c *ktxCase) *ktxCase {
+	be := *c
+	be.images = nil
+	size := int(c.header.Gltypesize)
+	for _, images := range c.images {
+		var level [][]byte
+		for _, img := range images {
+			img = append([]byte(nil), img...)
+			if c.header.Gltype != 0 {
+				for i := 0; i+size <= len(img); i += size {
+					for a, b := i, i+size-1; a < b; a, b = a+1, b-1 {
+						img[a], img[b] = img[b], img[a]
+					}
+				}
+			}
+			level = append(level, img)
+		}
+		be.images = append(be.images, level)
+	}
+	return &be
+}
+
+func TestKtxBigEndian(t *testing.T) {
+	for _, c := range ktxCorpus() {
+		be := specKtx(bigEndian(c), binary.BigEndian)
+		f, err := ParseKtx(bytes.NewReader(be))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		checkCase(t, c, f)
+
+		// Rewritten, a big-endian file is the little-endian reference.
+		var buf bytes.Buffer
+		if err := WriteKtx(&buf, f); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		} else if !bytes.Equal(buf.Bytes(), specKtx(c, binary.LittleEndian)) {
+			t.Errorf("%s: WriteKtx output differs from the little-endian reference", c.name)
+		}
+	}
+}
+
+func TestSwapData(t *testing.T) {
+	d := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	swapData(d, 2)
+	if !bytes.Equal(d, []byte{2, 1, 4, 3, 6, 5, 8, 7}) {
+		t.Errorf("2 byte swap: % x", d)
+	}
+	swapData(d, 4)
+	if !bytes.Equal(d, []byte{3, 4, 1, 2, 7, 8, 5, 6}) {
+		t.Errorf("4 byte swap: % x", d)
+	}
+	swapData(d, 1)
+	if !bytes.Equal(d, []byte{3, 4, 1, 2, 7, 8, 5, 6}) {
+		t.Errorf("1 byte units changed: % x", d)
+	}
+}
@@ -0,0 +1,262 @@
+// ktxformat
+package utils
+
+import (
+	gl "github.com/chsc/gogl/gl42"
+	"math"
+	"math/bits"
+)
+
+// glFormatInfo describes how the texels of an internal format are transferred
+// to and from client memory.
+type glFormatInfo struct {
+	format     gl.Enum
+	typ        gl.Enum
+	typesize   uint32
+	baseformat gl.Enum
+}
+
+var glFormats = map[gl.Enum]glFormatInfo{
+	gl.R8:             {gl.RED, gl.UNSIGNED_BYTE, 1, gl.RED},
+	gl.R8_SNORM:       {gl.RED, gl.BYTE, 1, gl.RED},
+	gl.R16:            {gl.RED, gl.UNSIGNED_SHORT, 2, gl.RED},
+	gl.R16_SNORM:      {gl.RED, gl.SHORT, 2, gl.RED},
+	gl.R16F:           {gl.RED, gl.HALF_FLOAT, 2, gl.RED},
+	gl.R32F:           {gl.RED, gl.FLOAT, 4, gl.RED},
+	gl.R8I:            {gl.RED_INTEGER, gl.BYTE, 1, gl.RED},
+	gl.R8UI:           {gl.RED_INTEGER, gl.UNSIGNED_BYTE, 1, gl.RED},
+	gl.R16I:           {gl.RED_INTEGER, gl.SHORT, 2, gl.RED},
+	gl.R16UI:          {gl.RED_INTEGER, gl.UNSIGNED_SHORT, 2, gl.RED},
+	gl.R32I:           {gl.RED_INTEGER, gl.INT, 4, gl.RED},
+	gl.R32UI:          {gl.RED_INTEGER, gl.UNSIGNED_INT, 4, gl.RED},
+	gl.RG8:            {gl.RG, gl.UNSIGNED_BYTE, 1, gl.RG},
+	gl.RG8_SNORM:      {gl.RG, gl.BYTE, 1, gl.RG},
+	gl.RG16:           {gl.RG, gl.UNSIGNED_SHORT, 2, gl.RG},
+	gl.RG16_SNORM:     {gl.RG, gl.SHORT, 2, gl.RG},
+	gl.RG16F:          {gl.RG, gl.HALF_FLOAT, 2, gl.RG},
+	gl.RG32F:          {gl.RG, gl.FLOAT, 4, gl.RG},
+	gl.RG8I:           {gl.RG_INTEGER, gl.BYTE, 1, gl.RG},
+	gl.RG8UI:          {gl.RG_INTEGER, gl.UNSIGNED_BYTE, 1, gl.RG},
+	gl.RG16I:          {gl.RG_INTEGER, gl.SHORT, 2, gl.RG},
+	gl.RG16UI:         {gl.RG_INTEGER, gl.UNSIGNED_SHORT, 2, gl.RG},
+	gl.RG32I:          {gl.RG_INTEGER, gl.INT, 4, gl.RG},
+	gl.RG32UI:         {gl.RG_INTEGER, gl.UNSIGNED_INT, 4, gl.RG},
+	gl.RGB8:           {gl.RGB, gl.UNSIGNED_BYTE, 1, gl.RGB},
+	gl.RGB8_SNORM:     {gl.RGB, gl.BYTE, 1, gl.RGB},
+	gl.SRGB8:          {gl.RGB, gl.UNSIGNED_BYTE, 1, gl.RGB},
+	gl.RGB16:          {gl.RGB, gl.UNSIGNED_SHORT, 2, gl.RGB},
+	gl.RGB16_SNORM:    {gl.RGB, gl.SHORT, 2, gl.RGB},
+	gl.RGB16F:         {gl.RGB, gl.HALF_FLOAT, 2, gl.RGB},
+	gl.RGB32F:         {gl.RGB, gl.FLOAT, 4, gl.RGB},
+	gl.RGB8I:          {gl.RGB_INTEGER, gl.BYTE, 1, gl.RGB},
+	gl.RGB8UI:         {gl.RGB_INTEGER, gl.UNSIGNED_BYTE, 1, gl.RGB},
+	gl.RGB16I:         {gl.RGB_INTEGER, gl.SHORT, 2, gl.RGB},
+	gl.RGB16UI:        {gl.RGB_INTEGER, gl.UNSIGNED_SHORT, 2, gl.RGB},
+	gl.RGB32I:         {gl.RGB_INTEGER, gl.INT, 4, gl.RGB},
+	gl.RGB32UI:        {gl.RGB_INTEGER, gl.UNSIGNED_INT, 4, gl.RGB},
+	gl.RGB565:         {gl.RGB, gl.UNSIGNED_SHORT_5_6_5, 2, gl.RGB},
+	gl.R11F_G11F_B10F: {gl.RGB, gl.UNSIGNED_INT_10F_11F_11F_REV, 4, gl.RGB},
+	gl.RGB9_E5:        {gl.RGB, gl.UNSIGNED_INT_5_9_9_9_REV, 4, gl.RGB},
+	gl.RGBA8:          {gl.RGBA, gl.UNSIGNED_BYTE, 1, gl.RGBA},
+	gl.RGBA8_SNORM:    {gl.RGBA, gl.BYTE, 1, gl.RGBA},
+	gl.SRGB8_ALPHA8:   {gl.RGBA, gl.UNSIGNED_BYTE, 1, gl.RGBA},
+	gl.RGBA16:         {gl.RGBA, gl.UNSIGNED_SHORT, 2, gl.RGBA},
+	gl.RGBA16_SNORM:   {gl.RGBA, gl.SHORT, 2, gl.RGBA},
+	gl.RGBA16F:        {gl.RGBA, gl.HALF_FLOAT, 2, gl.RGBA},
+	gl.RGBA32F:        {gl.RGBA, gl.FLOAT, 4, gl.RGBA},
+	gl.RGBA8I:         {gl.RGBA_INTEGER, gl.BYTE, 1, gl.RGBA},
+	gl.RGBA8UI:        {gl.RGBA_INTEGER, gl.UNSIGNED_BYTE, 1, gl.RGBA},
+	gl.RGBA16I:        {gl.RGBA_INTEGER, gl.SHORT, 2, gl.RGBA},
+	gl.RGBA16UI:       {gl.RGBA_INTEGER, gl.UNSIGNED_SHORT, 2, gl.RGBA},
+	gl.RGBA32I:        {gl.RGBA_INTEGER, gl.INT, 4, gl.RGBA},
+	gl.RGBA32UI:       {gl.RGBA_INTEGER, gl.UNSIGNED_INT, 4, gl.RGBA},
+	gl.RGBA4:          {gl.RGBA, gl.UNSIGNED_SHORT_4_4_4_4, 2, gl.RGBA},
+	gl.RGB5_A1:        {gl.RGBA, gl.UNSIGNED_SHORT_5_5_5_1, 2, gl.RGBA},
+	gl.RGB10_A2:       {gl.RGBA, gl.UNSIGNED_INT_2_10_10_10_REV, 4, gl.RGBA},
+	gl.RGB10_A2UI:     {gl.RGBA_INTEGER, gl.UNSIGNED_INT_2_10_10_10_REV, 4, gl.RGBA},
+
+	gl.DEPTH_COMPONENT16:  {gl.DEPTH_COMPONENT, gl.UNSIGNED_SHORT, 2, gl.DEPTH_COMPONENT},
+	gl.DEPTH_COMPONENT24:  {gl.DEPTH_COMPONENT, gl.UNSIGNED_INT, 4, gl.DEPTH_COMPONENT},
+	gl.DEPTH_COMPONENT32:  {gl.DEPTH_COMPONENT, gl.UNSIGNED_INT, 4, gl.DEPTH_COMPONENT},
+	gl.DEPTH_COMPONENT32F: {gl.DEPTH_COMPONENT, gl.FLOAT, 4, gl.DEPTH_COMPONENT},
+	gl.DEPTH24_STENCIL8:   {gl.DEPTH_STENCIL, gl.UNSIGNED_INT_24_8, 4, gl.DEPTH_STENCIL},
+}
+
+// formatComponents returns the number of components of a pixel transfer format.
+func formatComponents(format uint32) uint32 {
+	switch format {
+	case gl.RED, gl.GREEN, gl.BLUE, gl.ALPHA, gl.RED_INTEGER,
+		gl.DEPTH_COMPONENT, gl.STENCIL_INDEX:
+		return 1
+	case gl.RG, gl.RG_INTEGER, gl.DEPTH_STENCIL:
+		return 2
+	case gl.RGB, gl.BGR, gl.RGB_INTEGER, gl.BGR_INTEGER:
+		return 3
+	case gl.RGBA, gl.BGRA, gl.RGBA_INTEGER, gl.BGRA_INTEGER:
+		return 4
+	}
+	return 0
+}
+
+// isPackedType reports whether all components of a pixel are packed into
+// a single value of the type.
+func isPackedType(typ uint32) bool {
+	switch typ {
+	case gl.UNSIGNED_BYTE_3_3_2,
+		gl.UNSIGNED_SHORT_5_6_5, gl.UNSIGNED_SHORT_5_6_5_REV,
+		gl.UNSIGNED_SHORT_4_4_4_4, gl.UNSIGNED_SHORT_4_4_4_4_REV,
+		gl.UNSIGNED_SHORT_5_5_5_1, gl.UNSIGNED_SHORT_1_5_5_5_REV,
+		gl.UNSIGNED_INT_8_8_8_8, gl.UNSIGNED_INT_8_8_8_8_REV,
+		gl.UNSIGNED_INT_10_10_10_2, gl.UNSIGNED_INT_2_10_10_10_REV,
+		gl.UNSIGNED_INT_10F_11F_11F_REV, gl.UNSIGNED_INT_5_9_9_9_REV,
+		gl.UNSIGNED_INT_24_8:
+		return true
+	}
+	return false
+}
+
+// pixelSize returns the size in bytes of one pixel of an uncompressed format.
+func pixelSize(h *KTXHeader) uint32 {
+	if isPackedType(h.Gltype) {
+		return h.Gltypesize
+	}
+	return h.Gltypesize * formatComponents(h.Glformat)
+}
+
+// Compressed formats from the S3TC, ETC1 and ASTC extensions and the GL 4.3
+// ETC2/EAC formats.
+const (
+	glCompressedRGBS3TCDXT1       = 0x83F0
+	glCompressedRGBAS3TCDXT1      = 0x83F1
+	glCompressedRGBAS3TCDXT3      = 0x83F2
+	glCompressedRGBAS3TCDXT5      = 0x83F3
+	glCompressedSRGBS3TCDXT1      = 0x8C4C
+	glCompressedSRGBAlphaS3TCDXT1 = 0x8C4D
+	glCompressedSRGBAlphaS3TCDXT3 = 0x8C4E
+	glCompressedSRGBAlphaS3TCDXT5 = 0x8C4F
+
+	glETC1RGB8 = 0x8D64
+
+	glCompressedR11EAC                      = 0x9270
+	glCompressedSignedR11EAC                = 0x9271
+	glCompressedRG11EAC                     = 0x9272
+	glCompressedSignedRG11EAC               = 0x9273
+	glCompressedRGB8ETC2                    = 0x9274
+	glCompressedSRGB8ETC2                   = 0x9275
+	glCompressedRGB8PunchthroughAlpha1ETC2  = 0x9276
+	glCompressedSRGB8PunchthroughAlpha1ETC2 = 0x9277
+	glCompressedRGBA8ETC2EAC                = 0x9278
+	glCompressedSRGB8Alpha8ETC2EAC          = 0x9279
+
+	// ASTC formats run from 4x4 to 12x12 in the order of astcBlocks.
+	glCompressedRGBAASTC4x4        = 0x93B0
+	glCompressedSRGB8Alpha8ASTC4x4 = 0x93D0
+)
+
+var astcBlocks = [][2]uint32{
+	{4, 4}, {5, 4}, {5, 5}, {6, 5}, {6, 6}, {8, 5}, {8, 6},
+	{8, 8}, {10, 5}, {10, 6}, {10, 8}, {10, 10}, {12, 10}, {12, 12},
+}
+
+// compressedFormatInfo describes the block layout of a compressed format.
+type compressedFormatInfo struct {
+	blockWidth  uint32
+	blockHeight uint32
+	blockSize   uint32
+	baseformat  gl.Enum
+}
+
+var compressedFormats = map[gl.Enum]compressedFormatInfo{
+	glCompressedRGBS3TCDXT1:       {4, 4, 8, gl.RGB},
+	glCompressedRGBAS3TCDXT1:      {4, 4, 8, gl.RGBA},
+	glCompressedRGBAS3TCDXT3:      {4, 4, 16, gl.RGBA},
+	glCompressedRGBAS3TCDXT5:      {4, 4, 16, gl.RGBA},
+	glCompressedSRGBS3TCDXT1:      {4, 4, 8, gl.RGB},
+	glCompressedSRGBAlphaS3TCDXT1: {4, 4, 8, gl.RGBA},
+	glCompressedSRGBAlphaS3TCDXT3: {4, 4, 16, gl.RGBA},
+	glCompressedSRGBAlphaS3TCDXT5: {4, 4, 16, gl.RGBA},
+
+	gl.COMPRESSED_RED_RGTC1:        {4, 4, 8, gl.RED},
+	gl.COMPRESSED_SIGNED_RED_RGTC1: {4, 4, 8, gl.RED},
+	gl.COMPRESSED_RG_RGTC2:         {4, 4, 16, gl.RG},
+	gl.COMPRESSED_SIGNED_RG_RGTC2:  {4, 4, 16, gl.RG},
+
+	gl.COMPRESSED_RGBA_BPTC_UNORM:         {4, 4, 16, gl.RGBA},
+	gl.COMPRESSED_SRGB_ALPHA_BPTC_UNORM:   {4, 4, 16, gl.RGBA},
+	gl.COMPRESSED_RGB_BPTC_SIGNED_FLOAT:   {4, 4, 16, gl.RGB},
+	gl.COMPRESSED_RGB_BPTC_UNSIGNED_FLOAT: {4, 4, 16, gl.RGB},
+
+	glETC1RGB8: {4, 4, 8, gl.RGB},
+
+	glCompressedR11EAC:                      {4, 4, 8, gl.RED},
+	glCompressedSignedR11EAC:                {4, 4, 8, gl.RED},
+	glCompressedRG11EAC:                     {4, 4, 16, gl.RG},
+	glCompressedSignedRG11EAC:               {4, 4, 16, gl.RG},
+	glCompressedRGB8ETC2:                    {4, 4, 8, gl.RGB},
+	glCompressedSRGB8ETC2:                   {4, 4, 8, gl.RGB},
+	glCompressedRGB8PunchthroughAlpha1ETC2:  {4, 4, 8, gl.RGBA},
+	glCompressedSRGB8PunchthroughAlpha1ETC2: {4, 4, 8, gl.RGBA},
+	glCompressedRGBA8ETC2EAC:                {4, 4, 16, gl.RGBA},
+	glCompressedSRGB8Alpha8ETC2EAC:          {4, 4, 16, gl.RGBA},
+}
+
+func init() {
+	for i, b := range astcBlocks {
+		info := compressedFormatInfo{b[0], b[1], 16, gl.RGBA}
+		compressedFormats[gl.Enum(glCompressedRGBAASTC4x4+i)] = info
+		compressedFormats[gl.Enum(glCompressedSRGB8Alpha8ASTC4x4+i)] = info
+	}
+}
+
+// compressedFormat returns the block layout of h's internal format if the
+// file holds compressed data, which KTX marks with a zero glType.
+func compressedFormat(h *KTXHeader) (compressedFormatInfo, bool) {
+	if h.Gltype != 0 {
+		return compressedFormatInfo{}, false
+	}
+	c, ok := compressedFormats[gl.Enum(h.Glinternalformat)]
+	return c, ok
+}
+
+// IsCompressed reports whether the file holds block compressed image data.
+func (h *KTXHeader) IsCompressed() bool {
+	_, ok := compressedFormat(h)
+	return ok
+}
+
+// faceSize returns the size of one face of one array layer of mip level i,
+// with uncompressed rows padded to pad bytes.
+func faceSize(h *KTXHeader, i int, pad uint32) uint64 {
+	width := max1(h.Pixelwidth >> uint(i))
+	height := max1(h.Pixelheight >> uint(i))
+	depth := max1(h.Pixeldepth >> uint(i))
+
+	if c, ok := compressedFormat(h); ok {
+		bx := (width + c.blockWidth - 1) / c.blockWidth
+		by := (height + c.blockHeight - 1) / c.blockHeight
+		return mulSize(mulSize(uint64(bx), uint64(by)), uint64(depth)*uint64(c.blockSize))
+	}
+	return mulSize(calcStride(h, width, pad), uint64(height)*uint64(depth))
+}
+
+// mulSize multiplies two sizes, saturating instead of wrapping around so
+// that absurd headers never come out as small sizes.
+func mulSize(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	if hi != 0 {
+		return math.MaxUint64
+	}
+	return lo
+}
+
+// baseFormat returns the base internal format of an internal format, or
+// gl.NONE if it is not known.
+func baseFormat(internalformat gl.Enum) gl.Enum {
+	if f, ok := glFormats[internalformat]; ok {
+		return f.baseformat
+	}
+	if c, ok := compressedFormats[internalformat]; ok {
+		return c.baseformat
+	}
+	return gl.NONE
+}
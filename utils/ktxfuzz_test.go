@@ -0,0 +1,136 @@
+// ktxfuzz_test.go
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// hostileKtx returns a little-endian KTX 1.1 header for h followed by data.
+func hostileKtx(h KTXHeader, data []byte) []byte {
+	copy(h.Identifier[:], identifier)
+	h.Endianness = 0x04030201
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &h)
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func hostileCases() map[string][]byte {
+	rgba := KTXHeader{Gltype: 0x1401, Gltypesize: 1, Glformat: 0x1908, Glinternalformat: 0x8058,
+		Glbaseinternalformat: 0x1908, Pixelwidth: 4, Pixelheight: 4, Faces: 1, Miplevels: 1}
+
+	cases := make(map[string][]byte)
+	h := rgba
+	h.Pixelwidth, h.Pixelheight, h.Pixeldepth = 1<<31, 1<<31, 1<<31
+	cases["huge-3d"] = hostileKtx(h, []byte{0, 0, 0, 0})
+
+	h = rgba
+	h.Pixelwidth = 1 << 30 // 4 GiB rows wrap a 32-bit stride to 0
+	cases["wrapping-stride"] = hostileKtx(h, []byte{0, 0, 0, 0})
+
+	h = rgba
+	h.Miplevels = 0xffffffff
+	cases["mip-count"] = hostileKtx(h, []byte{0, 0, 0, 0})
+
+	h = rgba
+	h.Faces = 6
+	cases["cube-imagesize"] = hostileKtx(h, append([]byte{0xfd, 0xff, 0xff, 0xff}, make([]byte, 6*64)...))
+
+	h = rgba
+	h.Gltype, h.Glformat, h.Arrayelements = 0x1234, 0x1234, 0xffffffff
+	cases["unknown-format-layers"] = hostileKtx(h, []byte{0, 0, 0, 0})
+
+	h = rgba
+	h.Keypairbytes = 0xfffffffc
+	cases["key-value-size"] = hostileKtx(h, nil)
+
+	h = rgba
+	cases["empty-level"] = hostileKtx(h, []byte{0, 0, 0, 0})
+	return cases
+}
+
+func TestParseKtxHostile(t *testing.T) {
+	for name, d := range hostileCases() {
+		if _, err := ParseKtx(bytes.NewReader(d)); err == nil {
+			t.Errorf("%s: accepted", name)
+		} else if _, ok := err.(*KtxFormatError); !ok {
+			t.Errorf("%s: %T %v", name, err, err)
+		}
+	}
+}
+
+// exercise runs everything that reads a parsed file without a GL context.
+func exercise(t *testing.T, f *KTXFile) {
+	valid := len(f.Validate()) == 0
+	f.FlipRows()
+	for i, l := range f.Levels {
+		for _, img := range l.Images {
+			KtxImage(f, i, img.Layer, img.Face)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteKtx(&buf, f); err != nil || !valid {
+		return
+	}
+	if _, err := ParseKtx(&buf); err != nil {
+		t.Fatalf("valid file doesn't survive a round trip: %v", err)
+	}
+}
+
+func FuzzParseKtx(f *testing.F) {
+	for _, c := range ktxCorpus() {
+		f.Add(specKtx(c, binary.LittleEndian))
+		f.Add(specKtx(c, binary.BigEndian))
+	}
+	for _, scheme := range []uint32{SupercompressionNone, SupercompressionZLIB} {
+		f.Add(buildKtx2(f, KTX2Header{VkFormat: 29, TypeSize: 1, PixelWidth: 3, PixelHeight: 3, FaceCount: 1,
+			SupercompressionScheme: scheme}, nil, [][]byte{fill(27, 0), fill(3, 50)}))
+	}
+	f.Add(buildKtx2(f, KTX2Header{VkFormat: 9, TypeSize: 1, PixelWidth: 1, PixelHeight: 1, LayerCount: 1 << 30,
+		FaceCount: 1, SupercompressionScheme: SupercompressionZstd}, nil, [][]byte{fill(16, 0)}))
+
+	f.Fuzz(func(t *testing.T, d []byte) {
+		kf, err := ParseKtx(bytes.NewReader(d))
+		if err != nil {
+			if _, ok := err.(*KtxFormatError); !ok {
+				t.Fatalf("%T %v", err, err)
+			}
+			return
+		}
+		exercise(t, kf)
+	})
+}
+
+func FuzzParseDds(f *testing.F) {
+	dh := ddsHeader{Flags: ddsdMipMapCount, Width: 8, Height: 4, MipMapCount: 3}
+	dh.PixelFormat.Flags = ddpfFourCC
+	dh.PixelFormat.FourCC = fourCC("DXT1")
+	f.Add(buildDds(dh, nil, [][]byte{fill(16, 1), fill(8, 2), fill(8, 3)}))
+
+	dh = ddsHeader{Width: 2, Height: 2}
+	dh.PixelFormat.Flags = ddpfRGB | ddpfAlphaPixels
+	dh.PixelFormat.RGBBitCount = 32
+	dh.PixelFormat.RBitMask, dh.PixelFormat.GBitMask = 0xff, 0xff00
+	dh.PixelFormat.BBitMask, dh.PixelFormat.ABitMask = 0xff0000, 0xff000000
+	f.Add(buildDds(dh, nil, [][]byte{fill(16, 4)}))
+
+	dh = ddsHeader{Flags: ddsdMipMapCount, Width: 4, Height: 4, MipMapCount: 2}
+	dh.PixelFormat.Flags = ddpfFourCC
+	dh.PixelFormat.FourCC = fourCC("DX10")
+	f.Add(buildDds(dh, &ddsHeaderDX10{DxgiFormat: 98, ResourceDimension: 3, MiscFlag: ddsResourceMiscTexCube,
+		ArraySize: 2}, [][]byte{fill(16*24, 5)}))
+
+	f.Fuzz(func(t *testing.T, d []byte) {
+		kf, err := ParseDds(bytes.NewReader(d))
+		if err != nil {
+			if _, ok := err.(*DdsFormatError); !ok {
+				t.Fatalf("%T %v", err, err)
+			}
+			return
+		}
+		exercise(t, kf)
+	})
+}
@@ -0,0 +1,222 @@
+// ktxload
+package utils
+
+import (
+	gl "github.com/chsc/gogl/gl42"
+	"math/bits"
+	"os"
+)
+
+// LoadKtx loads a KTX file into tex, generating a new texture name if tex is 0.
+// Files stored top-down according to KTXorientation are flipped on the way,
+// except cube maps, whose faces GL expects top row first.
+// Malformed files are reported as *KtxFormatError.
+func LoadKtx(filename string, tex gl.Uint) (gl.Uint, error) {
+	f, err := readKtx(filename)
+	if err != nil {
+		return 0, err
+	}
+	return UploadKtx(f, tex)
+}
+
+// readKtx parses a KTX file and puts its bottom row first, as GL wants.
+func readKtx(filename string) (*KTXFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	f, err := ParseKtx(file)
+	if err != nil {
+		if e, ok := err.(*KtxFormatError); ok {
+			e.File = filename
+		}
+		return nil, err
+	}
+
+	if f.TopDown() {
+		f.FlipRows()
+	}
+	return f, nil
+}
+
+// ktxUploader sends image data to the bound texture, picking the
+// compressed entry points for block compressed formats. data is a client
+// pointer, or an offset into the bound PIXEL_UNPACK_BUFFER.
+type ktxUploader struct {
+	compressed     bool
+	internalformat gl.Enum
+	format         gl.Enum
+	typ            gl.Enum
+}
+
+func newKtxUploader(h *KTXHeader) *ktxUploader {
+	return &ktxUploader{
+		compressed:     h.IsCompressed(),
+		internalformat: gl.Enum(h.Glinternalformat),
+		format:         gl.Enum(h.Glformat),
+		typ:            gl.Enum(h.Gltype),
+	}
+}
+
+func (u *ktxUploader) subImage1D(target gl.Enum, level int, width uint32, size int, data gl.Pointer) {
+	if u.compressed {
+		gl.CompressedTexSubImage1D(target, gl.Int(level), 0, gl.Sizei(width),
+			u.internalformat, gl.Sizei(size), data)
+		return
+	}
+	gl.TexSubImage1D(target, gl.Int(level), 0, gl.Sizei(width), u.format, u.typ, data)
+}
+
+func (u *ktxUploader) subImage2D(target gl.Enum, level int, y, width, height uint32, size int, data gl.Pointer) {
+	if u.compressed {
+		gl.CompressedTexSubImage2D(target, gl.Int(level), 0, gl.Int(y), gl.Sizei(width), gl.Sizei(height),
+			u.internalformat, gl.Sizei(size), data)
+		return
+	}
+	gl.TexSubImage2D(target, gl.Int(level), 0, gl.Int(y), gl.Sizei(width), gl.Sizei(height),
+		u.format, u.typ, data)
+}
+
+func (u *ktxUploader) subImage3D(target gl.Enum, level int, y, z, width, height, depth uint32, size int, data gl.Pointer) {
+	if u.compressed {
+		gl.CompressedTexSubImage3D(target, gl.Int(level), 0, gl.Int(y), gl.Int(z), gl.Sizei(width),
+			gl.Sizei(height), gl.Sizei(depth), u.internalformat, gl.Sizei(size), data)
+		return
+	}
+	gl.TexSubImage3D(target, gl.Int(level), 0, gl.Int(y), gl.Int(z), gl.Sizei(width), gl.Sizei(height),
+		gl.Sizei(depth), u.format, u.typ, data)
+}
+
+// checkUpload makes sure f can be handed to GL: a known target and format,
+// and every image as large as GL will read.
+func checkUpload(f *KTXFile) error {
+	h := &f.Header
+
+	switch f.Target {
+	case gl.TEXTURE_1D, gl.TEXTURE_2D, gl.TEXTURE_3D, gl.TEXTURE_1D_ARRAY,
+		gl.TEXTURE_2D_ARRAY, gl.TEXTURE_CUBE_MAP, gl.TEXTURE_CUBE_MAP_ARRAY:
+	default:
+		return ktxErrorf("invalid target %#x", f.Target)
+	}
+	if len(f.Levels) == 0 {
+		return ktxErrorf("no image data")
+	}
+	if h.Gltype == 0 && !h.IsCompressed() {
+		return ktxErrorf("unsupported compressed format %#x", h.Glinternalformat)
+	}
+
+	// GL reads as many bytes as the format and size call for, whatever the
+	// slice holds.
+	images := uint64(h.Layers()) * uint64(h.FaceCount())
+	for i, level := range f.Levels {
+		want := faceSize(h, i, max1(uint32(f.Alignment)))
+		if want == 0 {
+			return ktxErrorf("unsupported format %#x/%#x", h.Glformat, h.Gltype)
+		}
+		if uint64(len(level.Data)) < mulSize(want, images) || uint64(len(level.Images)) != images {
+			return ktxErrorf("level %d image data truncated", i)
+		}
+		for _, img := range level.Images {
+			if uint64(len(img.Data)) < want {
+				return ktxErrorf("level %d image data truncated", i)
+			}
+		}
+	}
+	return nil
+}
+
+// allocKtx binds tex, generating a name if it is 0, and allocates immutable
+// storage for f. generate reports whether the mip chain is left for
+// GenerateMipmap to fill.
+func allocKtx(f *KTXFile, tex gl.Uint) (_ gl.Uint, generate bool) {
+	h := &f.Header
+	target := f.Target
+
+	if tex == 0 {
+		gl.GenTextures(1, &tex)
+	}
+	gl.BindTexture(target, tex)
+
+	// A zero mip count asks the loader to generate the rest of the chain,
+	// which GL can only do for uncompressed formats.
+	generate = h.Miplevels == 0 && !h.IsCompressed()
+	miplevels := gl.Sizei(h.Mips())
+	if generate {
+		miplevels = gl.Sizei(fullMipCount(h))
+	}
+
+	internalformat := gl.Enum(h.Glinternalformat)
+	width, height := gl.Sizei(h.Pixelwidth), gl.Sizei(h.Pixelheight)
+	layers := h.Layers() * h.FaceCount()
+	switch target {
+	case gl.TEXTURE_1D:
+		gl.TexStorage1D(target, miplevels, internalformat, width)
+	case gl.TEXTURE_2D, gl.TEXTURE_CUBE_MAP:
+		gl.TexStorage2D(target, miplevels, internalformat, width, height)
+	case gl.TEXTURE_1D_ARRAY:
+		gl.TexStorage2D(target, miplevels, internalformat, width, gl.Sizei(layers))
+	case gl.TEXTURE_3D:
+		gl.TexStorage3D(target, miplevels, internalformat, width, height, gl.Sizei(h.Pixeldepth))
+	case gl.TEXTURE_2D_ARRAY, gl.TEXTURE_CUBE_MAP_ARRAY:
+		gl.TexStorage3D(target, miplevels, internalformat, width, height, gl.Sizei(layers))
+	}
+	return tex, generate
+}
+
+// UploadKtx creates the storage for a parsed KTX file and uploads its images
+// into tex, generating a new texture name if tex is 0.
+func UploadKtx(f *KTXFile, tex gl.Uint) (gl.Uint, error) {
+	if err := checkUpload(f); err != nil {
+		return 0, err
+	}
+
+	h := &f.Header
+	target := f.Target
+	tex, generate := allocKtx(f, tex)
+	u := newKtxUploader(h)
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, gl.Int(max1(uint32(f.Alignment))))
+
+	// Array levels hold every layer (and face) back to back, so each level
+	// goes up in one call; cube map faces have their own targets.
+	layers := h.Layers() * h.FaceCount()
+	for i, level := range f.Levels {
+		data, size := gl.Pointer(&level.Data[0]), len(level.Data)
+		switch target {
+		case gl.TEXTURE_1D:
+			u.subImage1D(target, i, level.Width, size, data)
+		case gl.TEXTURE_2D:
+			u.subImage2D(target, i, 0, level.Width, level.Height, size, data)
+		case gl.TEXTURE_1D_ARRAY:
+			u.subImage2D(target, i, 0, level.Width, layers, size, data)
+		case gl.TEXTURE_3D:
+			u.subImage3D(target, i, 0, 0, level.Width, level.Height, level.Depth, size, data)
+		case gl.TEXTURE_2D_ARRAY, gl.TEXTURE_CUBE_MAP_ARRAY:
+			u.subImage3D(target, i, 0, 0, level.Width, level.Height, layers, size, data)
+		case gl.TEXTURE_CUBE_MAP:
+			for face, img := range level.Images {
+				u.subImage2D(gl.Enum(gl.TEXTURE_CUBE_MAP_POSITIVE_X+face), i, 0, img.Width, img.Height,
+					len(img.Data), gl.Pointer(&img.Data[0]))
+			}
+		}
+	}
+
+	if generate {
+		gl.GenerateMipmap(target)
+	}
+
+	return tex, nil
+}
+
+// fullMipCount returns the length of the complete mip chain for h.
+func fullMipCount(h *KTXHeader) int {
+	size := h.Pixelwidth
+	if h.Pixelheight > size {
+		size = h.Pixelheight
+	}
+	if h.Pixeldepth > size {
+		size = h.Pixeldepth
+	}
+	return bits.Len32(size)
+}
@@ -0,0 +1,170 @@
+// ktxmeta
+package utils
+
+import (
+	"bytes"
+	"strings"
+)
+
+// KeyValues is the key/value metadata of a KTX file, kept in file order.
+type KeyValues []KeyValue
+
+// Get returns the value stored under key.
+func (kvs KeyValues) Get(key string) ([]byte, bool) {
+	for _, kv := range kvs {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// String returns the value stored under key as a string, without the NUL
+// terminator KTX puts after UTF-8 values.
+func (kvs KeyValues) String(key string) string {
+	v, _ := kvs.Get(key)
+	if n := bytes.IndexByte(v, 0); n >= 0 {
+		v = v[:n]
+	}
+	return string(v)
+}
+
+// Set stores value under key, replacing an existing entry in place or
+// appending a new one.
+func (kvs *KeyValues) Set(key string, value []byte) {
+	for i := range *kvs {
+		if (*kvs)[i].Key == key {
+			(*kvs)[i].Value = value
+			return
+		}
+	}
+	*kvs = append(*kvs, KeyValue{Key: key, Value: value})
+}
+
+// SetString stores a NUL terminated UTF-8 value under key.
+func (kvs *KeyValues) SetString(key, value string) {
+	kvs.Set(key, append([]byte(value), 0))
+}
+
+// Delete removes the entry stored under key.
+func (kvs *KeyValues) Delete(key string) {
+	for i := range *kvs {
+		if (*kvs)[i].Key == key {
+			*kvs = append((*kvs)[:i], (*kvs)[i+1:]...)
+			return
+		}
+	}
+}
+
+const orientationKey = "KTXorientation"
+
+// Orientation returns the directions of the s, t and r axes recorded in
+// KTXorientation: 'r' or 'l' for s, 'd' or 'u' for t and 'i' or 'o' for r,
+// with 0 for axes the file doesn't mention. Both the KTX 1.1 form
+// ("S=r,T=d") and the KTX 2.0 form ("rd") are understood.
+func (f *KTXFile) Orientation() (s, t, r byte) {
+	v := f.KeyValue.String(orientationKey)
+	axes := [3]byte{}
+
+	if strings.Contains(v, "=") {
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if len(field) != 3 || field[1] != '=' {
+				continue
+			}
+			switch field[0] {
+			case 'S', 's':
+				axes[0] = field[2]
+			case 'T', 't':
+				axes[1] = field[2]
+			case 'R', 'r':
+				axes[2] = field[2]
+			}
+		}
+	} else {
+		for i := 0; i < len(v) && i < 3; i++ {
+			axes[i] = v[i]
+		}
+	}
+
+	return axes[0], axes[1], axes[2]
+}
+
+// TopDown reports whether the rows of the file are stored top to bottom,
+// the opposite of what GL expects.
+func (f *KTXFile) TopDown() bool {
+	_, t, _ := f.Orientation()
+	return t == 'd'
+}
+
+// FlipRows turns every image upside down in place and records the new
+// orientation in KTXorientation. Compressed images, whose rows are packed
+// into blocks, 1D textures and cube maps, whose faces GL expects top row
+// first, are left alone and false is returned.
+func (f *KTXFile) FlipRows() bool {
+	h := &f.Header
+	if h.IsCompressed() || h.Pixelheight == 0 || h.FaceCount() == 6 || pixelSize(h) == 0 {
+		return false
+	}
+
+	// Check every image first so a short one doesn't leave the file half
+	// flipped.
+	align := max1(uint32(f.Alignment))
+	for i := range f.Levels {
+		for _, img := range f.Levels[i].Images {
+			stride := calcStride(h, img.Width, align)
+			if mulSize(stride, uint64(img.Height)*uint64(img.Depth)) > uint64(len(img.Data)) {
+				return false
+			}
+		}
+	}
+	for i := range f.Levels {
+		for _, img := range f.Levels[i].Images {
+			stride := calcStride(h, img.Width, align)
+			slice := stride * uint64(img.Height)
+			for z := uint64(0); z < uint64(img.Depth); z++ {
+				flipRows(img.Data[z*slice:(z+1)*slice], stride, uint64(img.Height))
+			}
+		}
+	}
+
+	s, t, r := f.Orientation()
+	switch t {
+	case 'd':
+		t = 'u'
+	default:
+		t = 'd'
+	}
+	f.setOrientation(s, t, r)
+	return true
+}
+
+func (f *KTXFile) setOrientation(s, t, r byte) {
+	if s == 0 {
+		s = 'r'
+	}
+	var v string
+	if f.KTX2 != nil {
+		v = string([]byte{s, t})
+		if r != 0 {
+			v += string(r)
+		}
+	} else {
+		v = "S=" + string(s) + ",T=" + string(t)
+		if r != 0 {
+			v += ",R=" + string(r)
+		}
+	}
+	f.KeyValue.SetString(orientationKey, v)
+}
+
+func flipRows(d []byte, stride, rows uint64) {
+	tmp := make([]byte, stride)
+	for top, bottom := uint64(0), rows-1; top < bottom; top, bottom = top+1, bottom-1 {
+		a := d[top*stride : (top+1)*stride]
+		b := d[bottom*stride : (bottom+1)*stride]
+		copy(tmp, a)
+		copy(a, b)
+		copy(b, tmp)
+	}
+}
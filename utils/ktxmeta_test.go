@@ -0,0 +1,99 @@
+// ktxmeta_test.go
+package utils
+
+import (
+	"bytes"
+	gl "github.com/chsc/gogl/gl42"
+	"testing"
+)
+
+func TestKeyValues(t *testing.T) {
+	var kvs KeyValues
+	kvs.SetString("KTXwriter", "gogl")
+	kvs.Set("hash", []byte{1, 2, 3})
+	kvs.SetString("KTXwriter", "gogl 2")
+	kvs.SetString("tool", "bake")
+
+	if len(kvs) != 3 || kvs[0].Key != "KTXwriter" || kvs[1].Key != "hash" || kvs[2].Key != "tool" {
+		t.Fatalf("order = %+v", kvs)
+	}
+	if s := kvs.String("KTXwriter"); s != "gogl 2" {
+		t.Errorf("KTXwriter = %q", s)
+	}
+	if v, ok := kvs.Get("hash"); !ok || !bytes.Equal(v, []byte{1, 2, 3}) {
+		t.Errorf("hash = %v, %v", v, ok)
+	}
+
+	kvs.Delete("hash")
+	if _, ok := kvs.Get("hash"); ok || len(kvs) != 2 {
+		t.Errorf("after delete = %+v", kvs)
+	}
+}
+
+func TestKtxOrientation(t *testing.T) {
+	tests := []struct {
+		value   string
+		s, t, r byte
+	}{
+		{"S=r,T=d", 'r', 'd', 0},
+		{"S=l,T=u,R=o", 'l', 'u', 'o'},
+		{"rd", 'r', 'd', 0},
+		{"", 0, 0, 0},
+	}
+	for _, tt := range tests {
+		f := &KTXFile{}
+		if tt.value != "" {
+			f.KeyValue.SetString(orientationKey, tt.value)
+		}
+		if s, t2, r := f.Orientation(); s != tt.s || t2 != tt.t || r != tt.r {
+			t.Errorf("%q: got %c %c %c", tt.value, s, t2, r)
+		}
+	}
+}
+
+func TestKtxFlipRows(t *testing.T) {
+	// 3 texel RGB rows padded to 12 bytes.
+	h := KTXHeader{
+		Gltype:           gl.UNSIGNED_BYTE,
+		Glformat:         gl.RGB,
+		Glinternalformat: gl.RGB8,
+		Pixelwidth:       3,
+		Pixelheight:      2,
+		Faces:            1,
+		Miplevels:        1,
+	}
+	data := fill(24, 0)
+	f, err := NewKtx(h, [][]byte{append([]byte(nil), data...)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.KeyValue.SetString(orientationKey, "S=r,T=d")
+	f.KeyValue.SetString("source", "3f2a")
+
+	if !f.TopDown() || !f.FlipRows() {
+		t.Fatal("file should be flipped")
+	}
+	got := f.Levels[0].Data
+	if !bytes.Equal(got[:12], data[12:]) || !bytes.Equal(got[12:], data[:12]) {
+		t.Errorf("flipped = %v", got)
+	}
+
+	g := roundTrip(t, f)
+	if g.TopDown() || g.KeyValue.String(orientationKey) != "S=r,T=u" {
+		t.Errorf("orientation = %q", g.KeyValue.String(orientationKey))
+	}
+	if g.KeyValue.String("source") != "3f2a" {
+		t.Errorf("key/value = %+v", g.KeyValue)
+	}
+
+	// Cube faces are already the way GL wants them.
+	h.Faces = 6
+	f, err = NewKtx(h, [][]byte{fill(6*24, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.KeyValue.SetString(orientationKey, "S=r,T=d")
+	if f.FlipRows() || !bytes.Equal(f.Levels[0].Data, fill(6*24, 0)) || !f.TopDown() {
+		t.Error("cube map flipped")
+	}
+}
@@ -0,0 +1,77 @@
+// ktxvalidate
+package utils
+
+import (
+	gl "github.com/chsc/gogl/gl42"
+	"strings"
+)
+
+// Validate checks f against the rules of the KTX spec that ParseKtx does not
+// need to enforce to read the file, returning every problem found.
+func (f *KTXFile) Validate() []error {
+	h := &f.Header
+	var errs []error
+	fail := func(format string, a ...interface{}) {
+		errs = append(errs, ktxErrorf(format, a...))
+	}
+
+	if f.Target == gl.NONE {
+		fail("invalid dimension")
+	}
+	if h.Faces != 1 && h.Faces != 6 {
+		fail("numberOfFaces is %d, want 1 or 6", h.Faces)
+	}
+	if h.Faces == 6 && (h.Pixelwidth != h.Pixelheight || h.Pixeldepth != 0) {
+		fail("cube map faces are %dx%dx%d, want square and 2D", h.Pixelwidth, h.Pixelheight, h.Pixeldepth)
+	}
+	if full := uint32(fullMipCount(h)); h.Miplevels > full {
+		fail("numberOfMipmapLevels is %d, a %dx%dx%d texture has %d", h.Miplevels,
+			h.Pixelwidth, h.Pixelheight, h.Pixeldepth, full)
+	}
+	if len(f.Levels) != int(h.Mips()) {
+		fail("file holds %d levels, header says %d", len(f.Levels), h.Mips())
+	}
+
+	internal := gl.Enum(h.Glinternalformat)
+	if base := baseFormat(internal); base == gl.NONE {
+		fail("unknown glInternalFormat %#x", h.Glinternalformat)
+	} else if uint32(base) != h.Glbaseinternalformat {
+		fail("glBaseInternalFormat is %#x, want %#x", h.Glbaseinternalformat, uint32(base))
+	}
+
+	if _, ok := compressedFormats[internal]; ok {
+		if h.Gltype != 0 || h.Glformat != 0 {
+			fail("compressed format with glType %#x and glFormat %#x, want 0", h.Gltype, h.Glformat)
+		}
+		if h.Gltypesize != 1 {
+			fail("glTypeSize is %d, want 1 for compressed data", h.Gltypesize)
+		}
+	} else if info, ok := glFormats[internal]; ok {
+		if formatComponents(h.Glformat) == 0 {
+			fail("unknown glFormat %#x", h.Glformat)
+		}
+		if h.Gltypesize != info.typesize {
+			fail("glTypeSize is %d, want %d", h.Gltypesize, info.typesize)
+		}
+	}
+
+	if v, ok := f.KeyValue.Get(orientationKey); ok {
+		s, t, r := f.Orientation()
+		if f.KTX2 == nil && (len(v) == 0 || v[len(v)-1] != 0) {
+			fail("%s is not NUL terminated", orientationKey)
+		}
+		if !strings.ContainsRune("rl", rune(s)) || (t != 0 && !strings.ContainsRune("du", rune(t))) ||
+			(r != 0 && !strings.ContainsRune("io", rune(r))) {
+			fail("invalid %s %q", orientationKey, f.KeyValue.String(orientationKey))
+		}
+	}
+	seen := make(map[string]bool)
+	for _, kv := range f.KeyValue {
+		if seen[kv.Key] {
+			fail("duplicate key %q", kv.Key)
+		}
+		seen[kv.Key] = true
+	}
+
+	return errs
+}
@@ -0,0 +1,51 @@
+// ktxvalidate_test.go
+package utils
+
+import (
+	gl "github.com/chsc/gogl/gl42"
+	"image/color"
+	"testing"
+)
+
+func TestKtxValidate(t *testing.T) {
+	f, err := NewImageKtx(checker(4, 4), &ImageOptions{Mipmaps: MipBox})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := f.Validate(); len(errs) != 0 {
+		t.Fatalf("valid file reported %v", errs)
+	}
+
+	f.Header.Glbaseinternalformat = gl.BGR
+	f.Header.Gltypesize = 4
+	f.KeyValue.Set(orientationKey, []byte("S=x,T=d\x00"))
+	if errs := f.Validate(); len(errs) != 3 {
+		t.Fatalf("got %d errors: %v", len(errs), errs)
+	}
+}
+
+func TestKtxImage(t *testing.T) {
+	img := checker(4, 2)
+	img.Set(0, 0, color.NRGBA{255, 0, 0, 128})
+	f, err := NewImageKtx(img, &ImageOptions{FlipY: true, Mipmaps: MipNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := KtxImage(f, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Bounds().Dx() != 4 || out.Bounds().Dy() != 2 {
+		t.Fatalf("bounds %v", out.Bounds())
+	}
+	if c := out.NRGBAAt(0, 0); c != (color.NRGBA{255, 0, 0, 128}) {
+		t.Errorf("top-left texel is %v", c)
+	}
+	if c := out.NRGBAAt(1, 1); c != (color.NRGBA{255, 255, 255, 255}) {
+		t.Errorf("texel (1,1) is %v", c)
+	}
+	if _, err := KtxImage(f, 1, 0, 0); err == nil {
+		t.Error("missing level accepted")
+	}
+}
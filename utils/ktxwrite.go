@@ -0,0 +1,277 @@
+// ktxwrite
+package utils
+
+import (
+	"bufio"
+	"encoding/binary"
+	gl "github.com/chsc/gogl/gl42"
+	"io"
+	"os"
+)
+
+// NewKtx builds a KTX file from CPU-side pixel data, one slice per mip level.
+// Each level holds every array layer and cube face in KTX order with rows
+// padded to 4 bytes, or whole blocks for compressed formats (Gltype and
+// Glformat 0). Glbaseinternalformat and Gltypesize are filled in from
+// Glinternalformat when left zero.
+func NewKtx(h KTXHeader, levels [][]byte) (*KTXFile, error) {
+	copy(h.Identifier[:], identifier)
+	h.Endianness = 0x04030201
+
+	if h.Glbaseinternalformat == 0 {
+		h.Glbaseinternalformat = uint32(baseFormat(gl.Enum(h.Glinternalformat)))
+	}
+	if h.Gltypesize == 0 {
+		if info, ok := glFormats[gl.Enum(h.Glinternalformat)]; ok {
+			h.Gltypesize = info.typesize
+		} else if h.IsCompressed() {
+			h.Gltypesize = 1
+		}
+	}
+
+	f := &KTXFile{
+		Header:    h,
+		Target:    guessTarget(&h),
+		Alignment: 4,
+	}
+	if f.Target == gl.NONE {
+		return nil, ktxErrorf("invalid dimension")
+	}
+	if !h.IsCompressed() && pixelSize(&h) == 0 {
+		return nil, ktxErrorf("unsupported format %#x/%#x", h.Glformat, h.Gltype)
+	}
+	if len(levels) != int(h.Mips()) {
+		return nil, ktxErrorf("got %d levels, header says %d", len(levels), h.Mips())
+	}
+
+	images := uint64(h.Layers()) * uint64(h.FaceCount())
+	for i, data := range levels {
+		size := faceSize(&h, i, 4)
+		if uint64(len(data)) != mulSize(size, images) {
+			return nil, ktxErrorf("level %d is %d bytes, want %d", i, len(data), mulSize(size, images))
+		}
+		f.Levels = append(f.Levels, newLevel(&h, i, data, size, size))
+	}
+
+	return f, nil
+}
+
+// repackRows copies rows of rowSize bytes from src, srcStride apart, into a
+// new buffer with dstStride bytes per row.
+func repackRows(src []byte, rows, rowSize, srcStride, dstStride uint64) []byte {
+	dst := make([]byte, rows*dstStride)
+	for r := uint64(0); r < rows; r++ {
+		copy(dst[r*dstStride:r*dstStride+rowSize], src[r*srcStride:r*srcStride+rowSize])
+	}
+	return dst
+}
+
+func encodeKeyValue(kvs KeyValues) []byte {
+	var buf []byte
+
+	for _, kv := range kvs {
+		size := uint32(len(kv.Key) + 1 + len(kv.Value))
+		buf = binary.LittleEndian.AppendUint32(buf, size)
+		buf = append(buf, kv.Key...)
+		buf = append(buf, 0)
+		buf = append(buf, kv.Value...)
+		for size%4 != 0 {
+			buf = append(buf, 0)
+			size++
+		}
+	}
+
+	return buf
+}
+
+// WriteKtx encodes f as a little-endian KTX 1.1 file.
+func WriteKtx(w io.Writer, f *KTXFile) error {
+	h := f.Header
+	copy(h.Identifier[:], identifier)
+	h.Endianness = 0x04030201
+
+	kv := encodeKeyValue(f.KeyValue)
+	h.Keypairbytes = uint32(len(kv))
+
+	if err := binary.Write(w, binary.LittleEndian, &h); err != nil {
+		return err
+	}
+	if _, err := w.Write(kv); err != nil {
+		return err
+	}
+
+	var pad [4]byte
+	for i, level := range f.Levels {
+		rowSize := calcStride(&h, level.Width, 1)
+		srcStride := calcStride(&h, level.Width, max1(uint32(f.Alignment)))
+		stride := calcStride(&h, level.Width, 4)
+		rows := uint64(level.Height) * uint64(level.Depth)
+
+		var faces [][]byte
+		for _, img := range level.Images {
+			data := img.Data
+			// Compressed rows are whole blocks and never need repacking.
+			if !h.IsCompressed() && srcStride != stride {
+				data = repackRows(data, rows, rowSize, srcStride, stride)
+			}
+			faces = append(faces, data)
+		}
+		if len(faces) == 0 {
+			return ktxErrorf("level %d has no images", i)
+		}
+
+		var imageSize uint32
+		if f.Target == gl.TEXTURE_CUBE_MAP {
+			imageSize = uint32(len(faces[0]))
+		} else {
+			for _, data := range faces {
+				imageSize += uint32(len(data))
+			}
+		}
+		if err := binary.Write(w, binary.LittleEndian, imageSize); err != nil {
+			return err
+		}
+
+		var written uint32
+		for _, data := range faces {
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+			written += uint32(len(data))
+			if f.Target == gl.TEXTURE_CUBE_MAP {
+				n := (4 - written%4) % 4
+				if _, err := w.Write(pad[:n]); err != nil {
+					return err
+				}
+				written += n
+			}
+		}
+		if _, err := w.Write(pad[:(4-written%4)%4]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SaveKtxFile writes f, metadata included, to a KTX file.
+func SaveKtxFile(filename string, f *KTXFile) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if err := WriteKtx(w, f); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// SaveKtxData writes CPU-side pixel data to a KTX file, see NewKtx.
+func SaveKtxData(filename string, h KTXHeader, levels [][]byte) error {
+	f, err := NewKtx(h, levels)
+	if err != nil {
+		return err
+	}
+	return SaveKtxFile(filename, f)
+}
+
+// SaveKtx reads back every mip level of tex, bound to target, and writes it
+// to a KTX file.
+func SaveKtx(filename string, target gl.Enum, tex gl.Uint) error {
+	gl.BindTexture(target, tex)
+
+	faceTarget := target
+	if target == gl.TEXTURE_CUBE_MAP {
+		faceTarget = gl.TEXTURE_CUBE_MAP_POSITIVE_X
+	}
+
+	var width, height, depth, internalformat gl.Int
+	gl.GetTexLevelParameteriv(faceTarget, 0, gl.TEXTURE_WIDTH, &width)
+	gl.GetTexLevelParameteriv(faceTarget, 0, gl.TEXTURE_HEIGHT, &height)
+	gl.GetTexLevelParameteriv(faceTarget, 0, gl.TEXTURE_DEPTH, &depth)
+	gl.GetTexLevelParameteriv(faceTarget, 0, gl.TEXTURE_INTERNAL_FORMAT, &internalformat)
+	if width == 0 {
+		return ktxErrorf("texture has no image")
+	}
+
+	info, ok := glFormats[gl.Enum(internalformat)]
+	if !ok {
+		if _, ok = compressedFormats[gl.Enum(internalformat)]; !ok {
+			return ktxErrorf("unsupported internal format %#x", internalformat)
+		}
+	}
+
+	h := KTXHeader{
+		Gltype:           uint32(info.typ),
+		Gltypesize:       info.typesize,
+		Glformat:         uint32(info.format),
+		Glinternalformat: uint32(internalformat),
+		Pixelwidth:       uint32(width),
+		Faces:            1,
+	}
+	switch target {
+	case gl.TEXTURE_1D:
+	case gl.TEXTURE_1D_ARRAY:
+		h.Arrayelements = uint32(height)
+	case gl.TEXTURE_2D:
+		h.Pixelheight = uint32(height)
+	case gl.TEXTURE_2D_ARRAY:
+		h.Pixelheight = uint32(height)
+		h.Arrayelements = uint32(depth)
+	case gl.TEXTURE_3D:
+		h.Pixelheight = uint32(height)
+		h.Pixeldepth = uint32(depth)
+	case gl.TEXTURE_CUBE_MAP:
+		h.Pixelheight = uint32(height)
+		h.Faces = 6
+	case gl.TEXTURE_CUBE_MAP_ARRAY:
+		h.Pixelheight = uint32(height)
+		h.Arrayelements = uint32(depth) / 6
+		h.Faces = 6
+	default:
+		return ktxErrorf("unsupported target %#x", target)
+	}
+
+	for h.Miplevels < 32 {
+		var w gl.Int
+		gl.GetTexLevelParameteriv(faceTarget, gl.Int(h.Miplevels), gl.TEXTURE_WIDTH, &w)
+		if w == 0 {
+			break
+		}
+		h.Miplevels++
+	}
+
+	gl.PixelStorei(gl.PACK_ALIGNMENT, 4)
+
+	images := uint64(h.Layers()) * uint64(h.FaceCount())
+	var levels [][]byte
+	for i := 0; i < int(h.Miplevels); i++ {
+		size := faceSize(&h, i, 4)
+		data := make([]byte, size*images)
+
+		if target == gl.TEXTURE_CUBE_MAP {
+			for face := 0; face < 6; face++ {
+				getTexImage(&h, gl.Enum(gl.TEXTURE_CUBE_MAP_POSITIVE_X+face), i, data[uint64(face)*size:])
+			}
+		} else {
+			getTexImage(&h, target, i, data)
+		}
+		levels = append(levels, data)
+	}
+
+	return SaveKtxData(filename, h, levels)
+}
+
+func getTexImage(h *KTXHeader, target gl.Enum, level int, data []byte) {
+	if h.IsCompressed() {
+		gl.GetCompressedTexImage(target, gl.Int(level), gl.Pointer(&data[0]))
+		return
+	}
+	gl.GetTexImage(target, gl.Int(level), gl.Enum(h.Glformat), gl.Enum(h.Gltype), gl.Pointer(&data[0]))
+}
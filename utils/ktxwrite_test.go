@@ -0,0 +1,209 @@
+// ktxwrite_test.go
+package utils
+
+import (
+	"bytes"
+	gl "github.com/chsc/gogl/gl42"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func fill(n int, seed byte) []byte {
+	d := make([]byte, n)
+	for i := range d {
+		d[i] = seed + byte(i)
+	}
+	return d
+}
+
+func roundTrip(t *testing.T, f *KTXFile) *KTXFile {
+	var buf bytes.Buffer
+	if err := WriteKtx(&buf, f); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len()%4 != 0 {
+		t.Errorf("file size %d is not a multiple of 4", buf.Len())
+	}
+
+	g, err := ParseKtx(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestWriteKtx2D(t *testing.T) {
+	h := KTXHeader{
+		Gltype:           gl.UNSIGNED_BYTE,
+		Glformat:         gl.RGBA,
+		Glinternalformat: gl.RGBA8,
+		Pixelwidth:       4,
+		Pixelheight:      2,
+		Faces:            1,
+		Miplevels:        3,
+	}
+	f, err := NewKtx(h, [][]byte{fill(32, 0), fill(8, 100), fill(4, 200)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.KeyValue = []KeyValue{{"tool", []byte("gogl\x00")}}
+
+	g := roundTrip(t, f)
+	if g.Target != gl.TEXTURE_2D || len(g.Levels) != 3 {
+		t.Fatalf("target %#x with %d levels", g.Target, len(g.Levels))
+	}
+	if g.Header.Glbaseinternalformat != gl.RGBA || g.Header.Gltypesize != 1 {
+		t.Errorf("base format/type size not filled in: %+v", g.Header)
+	}
+	for i := range f.Levels {
+		if !bytes.Equal(f.Levels[i].Data, g.Levels[i].Data) {
+			t.Errorf("level %d differs", i)
+		}
+	}
+	if len(g.KeyValue) != 1 || g.KeyValue[0].Key != "tool" || string(g.KeyValue[0].Value) != "gogl\x00" {
+		t.Errorf("key/value = %+v", g.KeyValue)
+	}
+}
+
+func TestWriteKtxCube(t *testing.T) {
+	// 3 texel wide RGB rows are padded from 9 to 12 bytes.
+	h := KTXHeader{
+		Gltype:           gl.UNSIGNED_BYTE,
+		Glformat:         gl.RGB,
+		Glinternalformat: gl.RGB8,
+		Pixelwidth:       3,
+		Pixelheight:      3,
+		Faces:            6,
+		Miplevels:        1,
+	}
+	if _, err := NewKtx(h, [][]byte{fill(6*27, 0)}); err == nil {
+		t.Error("unpadded rows should be rejected")
+	}
+
+	f, err := NewKtx(h, [][]byte{fill(6*36, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := roundTrip(t, f)
+	if g.Target != gl.TEXTURE_CUBE_MAP {
+		t.Fatalf("target = %#x, want TEXTURE_CUBE_MAP", g.Target)
+	}
+	for face := 0; face < 6; face++ {
+		if !bytes.Equal(f.Image(0, 0, face).Data, g.Image(0, 0, face).Data) {
+			t.Errorf("face %d differs", face)
+		}
+	}
+}
+
+func TestWriteKtxAlignment(t *testing.T) {
+	// 3 texel wide RGB rows at any alignment are written 4 byte aligned.
+	h := KTXHeader{
+		Gltype:           gl.UNSIGNED_BYTE,
+		Glformat:         gl.RGB,
+		Glinternalformat: gl.RGB8,
+		Pixelwidth:       3,
+		Pixelheight:      2,
+		Faces:            1,
+		Miplevels:        1,
+	}
+	padded := make([]byte, 24)
+	copy(padded, fill(9, 0))
+	copy(padded[12:], fill(9, 9))
+	want, err := NewKtx(h, [][]byte{padded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		alignment int
+		stride    int
+	}{{0, 9}, {1, 9}, {2, 10}, {8, 16}} {
+		var data []byte
+		for r := 0; r < 2; r++ {
+			row := make([]byte, c.stride)
+			copy(row, fill(9, byte(9*r)))
+			data = append(data, row...)
+		}
+		f := &KTXFile{Header: want.Header, Target: gl.TEXTURE_2D, Alignment: c.alignment}
+		f.Levels = append(f.Levels, newLevel(&f.Header, 0, data, uint64(len(data)), uint64(len(data))))
+
+		g := roundTrip(t, f)
+		if !bytes.Equal(g.Levels[0].Data, want.Levels[0].Data) {
+			t.Errorf("alignment %d: got %v, want %v", c.alignment, g.Levels[0].Data, want.Levels[0].Data)
+		}
+	}
+}
+
+func TestSaveKtxDataLegacy(t *testing.T) {
+	file, err := os.Open("brick.ktx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	f, err := ParseKtx(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewriting a tightly packed file pads the rows of the small mips.
+	name := filepath.Join(t.TempDir(), "brick.ktx")
+	var levels [][]byte
+	for _, l := range f.Levels {
+		row := uint64(l.Width) * 3
+		levels = append(levels, repackRows(l.Data, uint64(l.Height), row, row, (row+3)&^3))
+	}
+	if err := SaveKtxData(name, f.Header, levels); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	g, err := ParseKtx(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Alignment != 4 || len(g.Levels) != len(f.Levels) {
+		t.Fatalf("alignment %d with %d levels", g.Alignment, len(g.Levels))
+	}
+	last := g.Image(9, 0, 0)
+	if len(last.Data) != 4 || !bytes.Equal(last.Data[:3], f.Image(9, 0, 0).Data) {
+		t.Errorf("level 9 = %v, want %v", last.Data, f.Image(9, 0, 0).Data)
+	}
+}
+
+func TestWriteKtxCompressed(t *testing.T) {
+	// 10x6 BC1 rounds up to 3x2 blocks, then 2x1 and 1x1.
+	h := KTXHeader{
+		Glinternalformat: glCompressedRGBS3TCDXT1,
+		Pixelwidth:       10,
+		Pixelheight:      6,
+		Faces:            1,
+		Miplevels:        3,
+	}
+	f, err := NewKtx(h, [][]byte{fill(48, 0), fill(16, 100), fill(8, 200)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := roundTrip(t, f)
+	if !g.Header.IsCompressed() || g.Header.Glbaseinternalformat != gl.RGB {
+		t.Errorf("header = %+v", g.Header)
+	}
+	for i := range f.Levels {
+		if !bytes.Equal(f.Levels[i].Data, g.Levels[i].Data) {
+			t.Errorf("level %d differs", i)
+		}
+	}
+
+	// ASTC 10x8 blocks are 16 bytes.
+	h.Glinternalformat = glCompressedRGBAASTC4x4 + 10
+	if size := faceSize(&h, 0, 4); size != 16 {
+		t.Errorf("ASTC 10x8 face size = %d, want 16", size)
+	}
+}
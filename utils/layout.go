@@ -0,0 +1,504 @@
+// layout
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	gl "github.com/chsc/gogl/gl42"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Layout is a GLSL block memory layout.
+type Layout int
+
+const (
+	Std140 Layout = iota // uniform blocks
+	Std430               // shader storage blocks
+)
+
+func (l Layout) String() string {
+	if l == Std430 {
+		return "std430"
+	}
+	return "std140"
+}
+
+// Go types map to GLSL as follows:
+//
+//	float32, float64           float, double
+//	int32, int, uint32, uint   int, uint
+//	bool                       bool
+//	[2..4]float32, int32, ...  vec2..4, ivec, uvec, dvec
+//	[C][R]float32, float64     matCxR, dmatCxR, C columns of R rows
+//	math3d.Vector2..4          vec2..4
+//	math3d.Matrix4             mat4
+//	[N]T                       T[N]
+//	struct                     struct
+//	[]T                        T[], only as the last field of the block itself
+//
+// A field is named after its glsl tag, or its Go name with the first letter
+// lowered. The tag option array, as in `glsl:"weights,array"`, makes an
+// [N]float32 or similar of 2 to 4 elements an array rather than a vector.
+
+// matrix4 is math3d.Matrix4, whose elements are not exported.
+type matrix4 interface {
+	ToArray32() [16]float32
+}
+
+var matrix4Type = reflect.TypeOf((*matrix4)(nil)).Elem()
+
+type layoutKind int
+
+const (
+	layoutScalar layoutKind = iota
+	layoutVector
+	layoutMatrix
+	layoutMath3dVector
+	layoutMath3dMatrix
+	layoutArray
+	layoutSlice
+	layoutStruct
+)
+
+// typeLayout is the layout of a Go type under one Layout.
+type typeLayout struct {
+	kind   layoutKind
+	align  int
+	size   int // 0 for a slice
+	scalar reflect.Kind
+	comps  int // vector components, matrix rows
+	cols   int // matrix columns
+	stride int // array element or matrix column stride
+	elem   *typeLayout
+	n      int // array length
+	fields []fieldLayout
+}
+
+type fieldLayout struct {
+	name   string
+	index  int
+	offset int
+	layout *typeLayout
+}
+
+func roundUp(n, align int) int {
+	return (n + align - 1) / align * align
+}
+
+// scalarSize returns the size in a block of a Go scalar kind, 0 if it is not
+// one.
+func scalarSize(k reflect.Kind) int {
+	switch k {
+	case reflect.Float32, reflect.Int32, reflect.Int, reflect.Uint32, reflect.Uint, reflect.Bool:
+		return 4
+	case reflect.Float64:
+		return 8
+	}
+	return 0
+}
+
+// vectorLayout returns the alignment and size of a vector of n scalars of
+// size s.
+func vectorLayout(s, n int) (align, size int) {
+	if n == 3 {
+		return 4 * s, 3 * s
+	}
+	return n * s, n * s
+}
+
+var layoutCache sync.Map // [2]interface{}{Layout, reflect.Type} -> *typeLayout
+
+func layoutOf(rule Layout, t reflect.Type) (*typeLayout, error) {
+	key := [2]interface{}{rule, t}
+	if l, ok := layoutCache.Load(key); ok {
+		return l.(*typeLayout), nil
+	}
+	l, err := buildLayout(rule, t, false, true)
+	if err != nil {
+		return nil, err
+	}
+	layoutCache.Store(key, l)
+	return l, nil
+}
+
+// buildLayout works out the layout of t. forceArray makes a short array an
+// array, top allows a slice, which only the last field of the block itself
+// may be.
+func buildLayout(rule Layout, t reflect.Type, forceArray, top bool) (*typeLayout, error) {
+	// std140 rounds the alignment of arrays, structs and matrix columns up
+	// to that of a vec4.
+	round := func(a int) int {
+		if rule == Std140 {
+			return roundUp(a, 16)
+		}
+		return a
+	}
+
+	if t.Implements(matrix4Type) || reflect.PtrTo(t).Implements(matrix4Type) {
+		return &typeLayout{kind: layoutMath3dMatrix, align: 16, size: 64, scalar: reflect.Float32, comps: 4, cols: 4, stride: 16}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64, reflect.Int32, reflect.Int, reflect.Uint32, reflect.Uint, reflect.Bool:
+		s := scalarSize(t.Kind())
+		return &typeLayout{kind: layoutScalar, align: s, size: s, scalar: t.Kind()}, nil
+
+	case reflect.Array:
+		e := t.Elem()
+		if s := scalarSize(e.Kind()); s > 0 && t.Len() >= 2 && t.Len() <= 4 && !forceArray {
+			a, size := vectorLayout(s, t.Len())
+			return &typeLayout{kind: layoutVector, align: a, size: size, scalar: e.Kind(), comps: t.Len()}, nil
+		}
+		if e.Kind() == reflect.Array && t.Len() >= 2 && t.Len() <= 4 && e.Len() >= 2 && e.Len() <= 4 && !forceArray {
+			// GLSL matrices are float or double only.
+			if k := e.Elem().Kind(); k == reflect.Float32 || k == reflect.Float64 {
+				s := scalarSize(k)
+				a, _ := vectorLayout(s, e.Len())
+				a = round(a)
+				return &typeLayout{kind: layoutMatrix, align: a, size: a * t.Len(), scalar: e.Elem().Kind(),
+					comps: e.Len(), cols: t.Len(), stride: a}, nil
+			}
+		}
+		el, err := buildLayout(rule, e, false, false)
+		if err != nil {
+			return nil, err
+		}
+		stride := round(roundUp(el.size, el.align))
+		return &typeLayout{kind: layoutArray, align: round(el.align), size: stride * t.Len(), stride: stride, elem: el, n: t.Len()}, nil
+
+	case reflect.Slice:
+		if !top {
+			return nil, fmt.Errorf("layout: slice %s is only allowed as the last field of a block", t)
+		}
+		el, err := buildLayout(rule, t.Elem(), false, false)
+		if err != nil {
+			return nil, err
+		}
+		stride := round(roundUp(el.size, el.align))
+		return &typeLayout{kind: layoutSlice, align: round(el.align), stride: stride, elem: el}, nil
+
+	case reflect.Struct:
+		if n := t.NumField(); n >= 2 && n <= 4 && isMath3dVector(t) {
+			a, size := vectorLayout(4, n)
+			return &typeLayout{kind: layoutMath3dVector, align: a, size: size, scalar: reflect.Float32, comps: n}, nil
+		}
+		l := &typeLayout{kind: layoutStruct, align: 1}
+		off := 0
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, opts := f.Tag.Get("glsl"), ""
+			if j := strings.Index(name, ","); j >= 0 {
+				name, opts = name[:j], name[j+1:]
+			}
+			if name == "" {
+				r, n := utf8.DecodeRuneInString(f.Name)
+				name = string(unicode.ToLower(r)) + f.Name[n:]
+			}
+			last := top && i == t.NumField()-1 && f.Type.Kind() == reflect.Slice
+			fl, err := buildLayout(rule, f.Type, opts == "array", last)
+			if err != nil {
+				return nil, fmt.Errorf("layout: field %s: %v", f.Name, strings.TrimPrefix(err.Error(), "layout: "))
+			}
+			off = roundUp(off, fl.align)
+			l.fields = append(l.fields, fieldLayout{name: name, index: i, offset: off, layout: fl})
+			off += fl.size
+			if fl.align > l.align {
+				l.align = fl.align
+			}
+		}
+		l.align = round(l.align)
+		l.size = roundUp(off, l.align)
+		if top && len(l.fields) > 0 && l.fields[len(l.fields)-1].layout.kind == layoutSlice {
+			// The unsized array is not padded.
+			l.size = off
+		}
+		return l, nil
+	}
+	return nil, fmt.Errorf("layout: unsupported type %s", t)
+}
+
+// isMath3dVector reports whether t is one of the math3d vectors, whose
+// fields are float64 components.
+func isMath3dVector(t reflect.Type) bool {
+	if !strings.HasSuffix(t.PkgPath(), "math3d") || !strings.HasPrefix(t.Name(), "Vector") {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Type.Kind() != reflect.Float64 {
+			return false
+		}
+	}
+	return true
+}
+
+// Encode lays out v, a struct or a pointer to one, in a block layout.
+func Encode(rule Layout, v interface{}) ([]byte, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("layout: %T is not a struct", v)
+	}
+	l, err := layoutOf(rule, rv.Type())
+	if err != nil {
+		return nil, err
+	}
+	size := l.size
+	if n := len(l.fields); n > 0 && l.fields[n-1].layout.kind == layoutSlice {
+		last := l.fields[n-1]
+		size = last.offset + rv.Field(last.index).Len()*last.layout.stride
+	}
+	buf := make([]byte, size)
+	encodeValue(buf, l, rv)
+	return buf, nil
+}
+
+// EncodedSize returns the size of a T in a block layout, not counting a
+// trailing slice.
+func EncodedSize(rule Layout, t reflect.Type) (int, error) {
+	l, err := layoutOf(rule, t)
+	if err != nil {
+		return 0, err
+	}
+	return l.size, nil
+}
+
+func putScalar(buf []byte, k reflect.Kind, v reflect.Value) {
+	switch k {
+	case reflect.Float32:
+		binary.LittleEndian.PutUint32(buf, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		binary.LittleEndian.PutUint64(buf, math.Float64bits(v.Float()))
+	case reflect.Int32, reflect.Int:
+		binary.LittleEndian.PutUint32(buf, uint32(int32(v.Int())))
+	case reflect.Uint32, reflect.Uint:
+		binary.LittleEndian.PutUint32(buf, uint32(v.Uint()))
+	case reflect.Bool:
+		if v.Bool() {
+			binary.LittleEndian.PutUint32(buf, 1)
+		}
+	}
+}
+
+func encodeValue(buf []byte, l *typeLayout, v reflect.Value) {
+	s := scalarSize(l.scalar)
+	switch l.kind {
+	case layoutScalar:
+		putScalar(buf, l.scalar, v)
+	case layoutVector:
+		for i := 0; i < l.comps; i++ {
+			putScalar(buf[i*s:], l.scalar, v.Index(i))
+		}
+	case layoutMatrix:
+		for c := 0; c < l.cols; c++ {
+			for r := 0; r < l.comps; r++ {
+				putScalar(buf[c*l.stride+r*s:], l.scalar, v.Index(c).Index(r))
+			}
+		}
+	case layoutMath3dVector:
+		for i := 0; i < l.comps; i++ {
+			binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(float32(v.Field(i).Float())))
+		}
+	case layoutMath3dMatrix:
+		var m matrix4
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return
+			}
+			m = v.Interface().(matrix4)
+		} else if v.CanAddr() {
+			m = v.Addr().Interface().(matrix4)
+		} else {
+			p := reflect.New(v.Type())
+			p.Elem().Set(v)
+			m = p.Interface().(matrix4)
+		}
+		a := m.ToArray32()
+		for i, f := range a {
+			binary.LittleEndian.PutUint32(buf[(i/4)*16+(i%4)*4:], math.Float32bits(f))
+		}
+	case layoutArray, layoutSlice:
+		for i := 0; i < v.Len(); i++ {
+			encodeValue(buf[i*l.stride:], l.elem, v.Index(i))
+		}
+	case layoutStruct:
+		for _, f := range l.fields {
+			encodeValue(buf[f.offset:], f.layout, v.Field(f.index))
+		}
+	}
+}
+
+// layoutMember is a leaf of a layout as GL names it in reflection.
+type layoutMember struct {
+	offset       int
+	arrayStride  int
+	matrixStride int
+}
+
+// members lists the leaves of l by their GL names. An array of leaves is one
+// member named name[0], an array of structs is listed element by element.
+func (l *typeLayout) members(prefix string, offset int, out map[string]layoutMember) {
+	switch l.kind {
+	case layoutStruct:
+		for _, f := range l.fields {
+			name := f.name
+			if prefix != "" {
+				name = prefix + "." + f.name
+			}
+			f.layout.members(name, offset+f.offset, out)
+		}
+	case layoutArray, layoutSlice:
+		if l.elem.kind == layoutStruct || l.elem.kind == layoutArray {
+			for i := 0; i < l.n; i++ {
+				l.elem.members(fmt.Sprintf("%s[%d]", prefix, i), offset+i*l.stride, out)
+			}
+			if l.kind == layoutSlice {
+				l.elem.members(prefix+"[0]", offset, out)
+			}
+			return
+		}
+		m := layoutMember{offset: offset, arrayStride: l.stride}
+		if l.elem.kind == layoutMatrix || l.elem.kind == layoutMath3dMatrix {
+			m.matrixStride = l.elem.stride
+		}
+		out[prefix+"[0]"] = m
+	case layoutMatrix, layoutMath3dMatrix:
+		out[prefix] = layoutMember{offset: offset, matrixStride: l.stride}
+	default:
+		out[prefix] = layoutMember{offset: offset}
+	}
+}
+
+// verifyLayout compares the layout of t with the members GL reports for a
+// block called block.
+func verifyLayout(rule Layout, t reflect.Type, block string, size int, reported []layoutReport) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	l, err := layoutOf(rule, t)
+	if err != nil {
+		return err
+	}
+	ours := make(map[string]layoutMember)
+	l.members("", 0, ours)
+
+	var errs []string
+	for _, r := range reported {
+		name := strings.TrimPrefix(r.name, block+".")
+		m, ok := ours[name]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("%s has no field", name))
+			continue
+		}
+		if m.offset != r.offset {
+			errs = append(errs, fmt.Sprintf("%s at offset %d, GL has %d", name, m.offset, r.offset))
+		}
+		if r.arrayStride > 0 && m.arrayStride != r.arrayStride {
+			errs = append(errs, fmt.Sprintf("%s array stride %d, GL has %d", name, m.arrayStride, r.arrayStride))
+		}
+		if r.matrixStride > 0 && m.matrixStride != r.matrixStride {
+			errs = append(errs, fmt.Sprintf("%s matrix stride %d, GL has %d", name, m.matrixStride, r.matrixStride))
+		}
+		if r.rowMajor && m.matrixStride > 0 {
+			// Encode writes matrices column by column.
+			errs = append(errs, fmt.Sprintf("%s is row_major", name))
+		}
+	}
+	if l.size > size && size > 0 {
+		errs = append(errs, fmt.Sprintf("%d bytes, GL block has %d", l.size, size))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("layout: %s %s as %s: %s", rule, block, t, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+type layoutReport struct {
+	name         string
+	offset       int
+	arrayStride  int
+	matrixStride int
+	rowMajor     bool
+}
+
+// Verify checks that v, or a value of its type, lays out in std140 at the
+// offsets and strides GL reports for the block, with column-major matrices.
+func (b *UniformBlock) Verify(v interface{}) error {
+	reported := make([]layoutReport, len(b.Members))
+	for i, m := range b.Members {
+		reported[i] = layoutReport{m.Name, m.Offset, m.ArrayStride, m.MatrixStride, m.RowMajor}
+	}
+	return verifyLayout(Std140, reflect.TypeOf(v), b.Name, b.Size, reported)
+}
+
+// Verify checks that v, or a value of its type, lays out in std430 at the
+// offsets and strides GL reports for the block, with column-major matrices.
+func (b *StorageBlock) Verify(v interface{}) error {
+	reported := make([]layoutReport, len(b.Members))
+	for i, m := range b.Members {
+		reported[i] = layoutReport{m.Name, m.Offset, m.ArrayStride, m.MatrixStride, m.RowMajor}
+	}
+	return verifyLayout(Std430, reflect.TypeOf(v), b.Name, b.Size, reported)
+}
+
+// UniformBuffer is a uniform buffer holding a T in std140 layout.
+type UniformBuffer[T any] struct {
+	ID      gl.Uint
+	Binding int
+	size    int
+}
+
+// NewUniformBuffer creates a buffer the size of a T and binds it to uniform
+// buffer binding point binding. T can't end in a slice.
+func NewUniformBuffer[T any](binding int) (*UniformBuffer[T], error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	l, err := layoutOf(Std140, t)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(l.fields); n > 0 && l.fields[n-1].layout.kind == layoutSlice {
+		return nil, fmt.Errorf("layout: %s ends in a slice, which a uniform block can't hold", t)
+	}
+	size := l.size
+	b := &UniformBuffer[T]{Binding: binding, size: size}
+	gl.GenBuffers(1, &b.ID)
+	gl.BindBuffer(gl.UNIFORM_BUFFER, b.ID)
+	gl.BufferData(gl.UNIFORM_BUFFER, gl.Sizeiptr(size), nil, gl.DYNAMIC_DRAW)
+	gl.BindBufferBase(gl.UNIFORM_BUFFER, gl.Uint(binding), b.ID)
+	return b, nil
+}
+
+// Set uploads v.
+func (b *UniformBuffer[T]) Set(v *T) error {
+	d, err := Encode(Std140, v)
+	if err != nil {
+		return err
+	}
+	if len(d) > b.size {
+		return fmt.Errorf("layout: %d bytes for a %d byte uniform buffer", len(d), b.size)
+	}
+	gl.BindBuffer(gl.UNIFORM_BUFFER, b.ID)
+	gl.BufferSubData(gl.UNIFORM_BUFFER, 0, gl.Sizeiptr(len(d)), ptr(d))
+	return nil
+}
+
+// Bind checks T against the uniform block called block of p and assigns the
+// block to the buffer's binding point.
+func (b *UniformBuffer[T]) Bind(p *Program, block string) error {
+	ub, ok := p.UniformBlock(block)
+	if !ok {
+		return fmt.Errorf("program: no uniform block %q", block)
+	}
+	if err := ub.Verify((*T)(nil)); err != nil {
+		return err
+	}
+	return p.BindUniformBlock(block, b.Binding)
+}
+
+// Delete deletes the buffer.
+func (b *UniformBuffer[T]) Delete() {
+	gl.DeleteBuffers(1, &b.ID)
+}
@@ -0,0 +1,191 @@
+// layout_test.go
+package utils
+
+import (
+	"encoding/binary"
+	"github.com/ginuerzh/math3d"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type light struct {
+	Position  [3]float32
+	Intensity float32
+	Color     math3d.Vector3
+}
+
+type scene struct {
+	Model   *math3d.Matrix4
+	Normal  [3][3]float32
+	Tint    [3]float32
+	Scale   float32
+	Weights [4]float32 `glsl:"weights,array"`
+	Lights  [2]light
+	Count   int32
+}
+
+func offsets(t *testing.T, rule Layout, v interface{}) map[string]layoutMember {
+	l, err := layoutOf(rule, reflect.Indirect(reflect.ValueOf(v)).Type())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := make(map[string]layoutMember)
+	l.members("", 0, m)
+	return m
+}
+
+func TestLayoutOffsets(t *testing.T) {
+	for _, c := range []struct {
+		rule Layout
+		want map[string]layoutMember
+		size int
+	}{
+		{Std140, map[string]layoutMember{
+			"model":              {0, 0, 16},
+			"normal":             {64, 0, 16},
+			"tint":               {112, 0, 0},
+			"scale":              {124, 0, 0},
+			"weights[0]":         {128, 16, 0},
+			"lights[0].position": {192, 0, 0},
+			"lights[0].color":    {208, 0, 0},
+			"lights[1].position": {224, 0, 0},
+			"count":              {256, 0, 0},
+		}, 272},
+		{Std430, map[string]layoutMember{
+			"model":              {0, 0, 16},
+			"normal":             {64, 0, 16},
+			"tint":               {112, 0, 0},
+			"scale":              {124, 0, 0},
+			"weights[0]":         {128, 4, 0},
+			"lights[0].position": {144, 0, 0},
+			"lights[0].color":    {160, 0, 0},
+			"lights[1].position": {176, 0, 0},
+			"count":              {208, 0, 0},
+		}, 224},
+	} {
+		got := offsets(t, c.rule, scene{})
+		for name, w := range c.want {
+			if g, ok := got[name]; !ok || g != w {
+				t.Errorf("%s %s: got %+v, want %+v", c.rule, name, g, w)
+			}
+		}
+		if size, _ := EncodedSize(c.rule, reflect.TypeOf(scene{})); size != c.size {
+			t.Errorf("%s size %d, want %d", c.rule, size, c.size)
+		}
+	}
+}
+
+func TestLayoutIntMatrix(t *testing.T) {
+	// GLSL has no integer matrices, so [2][3]int32 is ivec3[2].
+	got := offsets(t, Std430, struct {
+		M [2][3]int32
+		X int32
+	}{})
+	if m, ok := got["m[0]"]; !ok || m.arrayStride != 16 || got["x"].offset != 32 {
+		t.Errorf("members %+v", got)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	s := &scene{
+		Model:   math3d.Translate(1, 2, 3),
+		Normal:  [3][3]float32{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}},
+		Scale:   0.5,
+		Weights: [4]float32{1, 2, 3, 4},
+		Count:   -2,
+	}
+	s.Lights[1].Color = *math3d.NewVector3(1, 2, 3)
+	d, err := Encode(Std140, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := func(off int) float32 { return math.Float32frombits(binary.LittleEndian.Uint32(d[off:])) }
+
+	m := s.Model.ToArray32()
+	for i, v := range m {
+		if f(i*4) != v {
+			t.Errorf("model[%d] = %v, want %v", i, f(i*4), v)
+		}
+	}
+	for _, c := range []struct {
+		off  int
+		want float32
+	}{
+		{64, 1}, {72, 3}, {80, 4}, {96, 7}, {104, 9}, {124, 0.5},
+		{128, 1}, {144, 2}, {176, 4}, {240, 1}, {244, 2}, {248, 3},
+	} {
+		if g := f(c.off); g != c.want {
+			t.Errorf("offset %d = %v, want %v", c.off, g, c.want)
+		}
+	}
+	if n := int32(binary.LittleEndian.Uint32(d[256:])); n != -2 {
+		t.Errorf("count = %d", n)
+	}
+}
+
+func TestEncodeSlice(t *testing.T) {
+	type particles struct {
+		Count int32
+		Pos   [][3]float32
+	}
+	d, err := Encode(Std430, particles{Count: 2, Pos: [][3]float32{{1, 2, 3}, {4, 5, 6}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d) != 48 || math.Float32frombits(binary.LittleEndian.Uint32(d[32:])) != 4 {
+		t.Errorf("encoded %d bytes: %v", len(d), d)
+	}
+
+	if _, err := NewUniformBuffer[particles](0); err == nil {
+		t.Error("uniform buffer with a slice")
+	}
+
+	type bad struct {
+		Pos   [][3]float32
+		Count int32
+	}
+	if _, err := Encode(Std430, bad{}); err == nil || !strings.Contains(err.Error(), "last field") {
+		t.Errorf("slice not last: %v", err)
+	}
+	nested := &struct {
+		X float32
+		B struct{ Y []float32 }
+	}{}
+	if _, err := Encode(Std430, nested); err == nil || !strings.Contains(err.Error(), "last field") {
+		t.Errorf("nested slice: %v", err)
+	}
+	if _, err := Encode(Std140, struct{ S string }{}); err == nil {
+		t.Error("string field encoded")
+	}
+}
+
+func TestVerifyLayout(t *testing.T) {
+	b := &UniformBlock{Name: "Scene", Size: 272, Members: []Uniform{
+		{Name: "Scene.model", Offset: 0, MatrixStride: 16},
+		{Name: "Scene.weights[0]", Offset: 128, ArrayStride: 16},
+		{Name: "Scene.lights[1].color", Offset: 240},
+		{Name: "Scene.count", Offset: 256},
+	}}
+	if err := b.Verify(&scene{}); err != nil {
+		t.Error(err)
+	}
+
+	b.Members[1].ArrayStride = 4
+	b.Members = append(b.Members, Uniform{Name: "Scene.fog", Offset: 260})
+	err := b.Verify(scene{})
+	if err == nil || !strings.Contains(err.Error(), "weights[0] array stride 16, GL has 4") || !strings.Contains(err.Error(), "fog has no field") {
+		t.Errorf("mismatch: %v", err)
+	}
+
+	b.Members = []Uniform{{Name: "Scene.model", Offset: 0, MatrixStride: 16, RowMajor: true}}
+	if err := b.Verify(scene{}); err == nil || !strings.Contains(err.Error(), "model is row_major") {
+		t.Errorf("row_major: %v", err)
+	}
+
+	sb := &StorageBlock{Name: "Scene", Members: []BufferVariable{{Name: "weights[0]", Offset: 128, ArrayStride: 4}}}
+	if err := sb.Verify(scene{}); err != nil {
+		t.Error(err)
+	}
+}
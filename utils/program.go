@@ -0,0 +1,291 @@
+// program
+package utils
+
+import (
+	"fmt"
+	gl "github.com/chsc/gogl/gl42"
+	gl43 "github.com/chsc/gogl/gl43"
+	"strings"
+)
+
+type shaderSource struct {
+	stage gl.Enum
+	src   string
+	file  string
+	glsl  *GLSLSource
+}
+
+// ProgramBuilder collects shader stages, from source strings or files, and
+// links them into a program or a program pipeline. A program has either a
+// compute stage or any of the graphics stages, each at most once. Every
+// stage goes through a Preprocessor, and compile logs refer to the original
+// files and lines.
+type ProgramBuilder struct {
+	stages    []shaderSource
+	separable bool
+	pp        Preprocessor
+}
+
+func NewProgramBuilder() *ProgramBuilder {
+	return &ProgramBuilder{}
+}
+
+// Source adds a stage given as GLSL source.
+func (b *ProgramBuilder) Source(stage gl.Enum, src string) *ProgramBuilder {
+	b.stages = append(b.stages, shaderSource{stage: stage, src: src})
+	return b
+}
+
+// File adds a stage read from filename when the program is built.
+func (b *ProgramBuilder) File(stage gl.Enum, filename string) *ProgramBuilder {
+	b.stages = append(b.stages, shaderSource{stage: stage, file: filename})
+	return b
+}
+
+// Separable marks the program as usable in a program pipeline.
+func (b *ProgramBuilder) Separable(separable bool) *ProgramBuilder {
+	b.separable = separable
+	return b
+}
+
+// Define injects #define name value into every stage.
+func (b *ProgramBuilder) Define(name, value string) *ProgramBuilder {
+	if b.pp.Defines == nil {
+		b.pp.Defines = make(map[string]string)
+	}
+	b.pp.Defines[name] = value
+	return b
+}
+
+// IncludeDir adds a directory searched by #include.
+func (b *ProgramBuilder) IncludeDir(dir string) *ProgramBuilder {
+	b.pp.IncludeDirs = append(b.pp.IncludeDirs, dir)
+	return b
+}
+
+// StageBits returns the pipeline stage bits of the stages added so far.
+func (b *ProgramBuilder) StageBits() gl.Bitfield {
+	var bits gl.Bitfield
+	for _, s := range b.stages {
+		bits |= StageBit(s.stage)
+	}
+	return bits
+}
+
+// check rejects stage sets no program can be linked from.
+func (b *ProgramBuilder) check() error {
+	if len(b.stages) == 0 {
+		return fmt.Errorf("program: no shader stages")
+	}
+	seen := make(map[gl.Enum]bool)
+	for _, s := range b.stages {
+		if StageBit(s.stage) == 0 {
+			return fmt.Errorf("program: unknown shader stage %#x", uint32(s.stage))
+		}
+		if seen[s.stage] {
+			return fmt.Errorf("program: %s stage added twice", StageName(s.stage))
+		}
+		seen[s.stage] = true
+	}
+	if seen[gl43.COMPUTE_SHADER] && len(seen) > 1 {
+		return fmt.Errorf("program: compute stage mixed with graphics stages")
+	}
+	return nil
+}
+
+// sources returns every stage preprocessed, and the files read for them,
+// including those of a stage that failed.
+func (b *ProgramBuilder) sources() ([]shaderSource, []string, error) {
+	srcs := append([]shaderSource(nil), b.stages...)
+	var files []string
+	for i := range srcs {
+		var err error
+		if srcs[i].file != "" {
+			srcs[i].glsl, err = b.pp.File(srcs[i].file)
+		} else {
+			srcs[i].glsl, err = b.pp.Source(srcs[i].src, "<"+StageName(srcs[i].stage)+">")
+		}
+		if err != nil {
+			if srcs[i].file != "" {
+				files = append(files, srcs[i].file)
+			}
+			return nil, files, err
+		}
+		if srcs[i].file != "" {
+			files = append(files, srcs[i].glsl.Files...)
+		}
+	}
+	return srcs, files, nil
+}
+
+// Build compiles every stage and links the program. Compile failures are
+// reported together as ShaderCompileErrors, a link failure as
+// *ProgramLinkError.
+func (b *ProgramBuilder) Build() (gl.Uint, error) {
+	program, _, err := b.build()
+	return program, err
+}
+
+// build is Build, also returning every file the stages were read from.
+func (b *ProgramBuilder) build() (gl.Uint, []string, error) {
+	if err := b.check(); err != nil {
+		return 0, nil, err
+	}
+	srcs, files, err := b.sources()
+	if err != nil {
+		return 0, files, err
+	}
+
+	var shaders []gl.Uint
+	var errs ShaderCompileErrors
+	defer func() {
+		for _, s := range shaders {
+			gl.DeleteShader(s)
+		}
+	}()
+	for _, src := range srcs {
+		s, err := compileShader(src.stage, src.glsl.Source, src.file)
+		if err != nil {
+			e := err.(*ShaderCompileError)
+			e.Log = src.glsl.MapLog(e.Log)
+			errs = append(errs, e)
+			continue
+		}
+		shaders = append(shaders, s)
+	}
+	if len(errs) > 0 {
+		return 0, files, errs
+	}
+
+	program := gl.CreateProgram()
+	if b.separable {
+		gl.ProgramParameteri(program, gl.PROGRAM_SEPARABLE, gl.TRUE)
+	}
+	for _, s := range shaders {
+		gl.AttachShader(program, s)
+	}
+	gl.LinkProgram(program)
+	for _, s := range shaders {
+		gl.DetachShader(program, s)
+	}
+
+	var status gl.Int
+	gl.GetProgramiv(program, gl.LINK_STATUS, &status)
+	if status == gl.FALSE {
+		err := &ProgramLinkError{Log: programInfoLog(program)}
+		gl.DeleteProgram(program)
+		return 0, files, err
+	}
+	return program, files, nil
+}
+
+// BuildPipeline builds every stage as a separable program of its own and
+// puts them in a new program pipeline.
+func (b *ProgramBuilder) BuildPipeline() (*ProgramPipeline, error) {
+	if err := b.check(); err != nil {
+		return nil, err
+	}
+	p := NewProgramPipeline()
+	for _, s := range b.stages {
+		program, err := (&ProgramBuilder{stages: []shaderSource{s}, separable: true, pp: b.pp}).Build()
+		if err != nil {
+			p.Delete()
+			return nil, err
+		}
+		p.programs = append(p.programs, program)
+		p.UseStages(StageBit(s.stage), program)
+	}
+	return p, nil
+}
+
+// ProgramPipeline is a program pipeline object and the programs it owns.
+type ProgramPipeline struct {
+	ID       gl.Uint
+	programs []gl.Uint
+}
+
+func NewProgramPipeline() *ProgramPipeline {
+	p := &ProgramPipeline{}
+	gl.GenProgramPipelines(1, &p.ID)
+	return p
+}
+
+// UseStages makes the pipeline run the stages in bits from program, which
+// must be separable. The pipeline does not take ownership of program.
+func (p *ProgramPipeline) UseStages(bits gl.Bitfield, program gl.Uint) {
+	gl.UseProgramStages(p.ID, bits, program)
+}
+
+// Programs returns the programs created by BuildPipeline.
+func (p *ProgramPipeline) Programs() []gl.Uint {
+	return p.programs
+}
+
+// Bind binds the pipeline. A program made current by UseProgram takes
+// precedence over it.
+func (p *ProgramPipeline) Bind() {
+	gl.BindProgramPipeline(p.ID)
+}
+
+// Validate checks the pipeline can run with the current GL state, returning
+// a *PipelineValidateError if not.
+func (p *ProgramPipeline) Validate() error {
+	gl.ValidateProgramPipeline(p.ID)
+
+	var status gl.Int
+	gl.GetProgramPipelineiv(p.ID, gl.VALIDATE_STATUS, &status)
+	if status != gl.FALSE {
+		return nil
+	}
+
+	var length gl.Int
+	gl.GetProgramPipelineiv(p.ID, gl.INFO_LOG_LENGTH, &length)
+	var log string
+	if length > 0 {
+		info := gl.GLStringAlloc(gl.Sizei(length))
+		defer gl.GLStringFree(info)
+		gl.GetProgramPipelineInfoLog(p.ID, gl.Sizei(length), nil, info)
+		log = gl.GoString(info)
+	}
+	return &PipelineValidateError{Log: log}
+}
+
+// Delete deletes the pipeline and the programs it owns.
+func (p *ProgramPipeline) Delete() {
+	for _, program := range p.programs {
+		gl.DeleteProgram(program)
+	}
+	p.programs = nil
+	gl.DeleteProgramPipelines(1, &p.ID)
+}
+
+// ShaderCompileErrors lists every stage of a program that failed to compile.
+type ShaderCompileErrors []*ShaderCompileError
+
+func (e ShaderCompileErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// StageBit returns the program pipeline bit of a shader stage, 0 if stage is
+// not one.
+func StageBit(stage gl.Enum) gl.Bitfield {
+	switch stage {
+	case gl.VERTEX_SHADER:
+		return gl.VERTEX_SHADER_BIT
+	case gl.TESS_CONTROL_SHADER:
+		return gl.TESS_CONTROL_SHADER_BIT
+	case gl.TESS_EVALUATION_SHADER:
+		return gl.TESS_EVALUATION_SHADER_BIT
+	case gl.GEOMETRY_SHADER:
+		return gl.GEOMETRY_SHADER_BIT
+	case gl.FRAGMENT_SHADER:
+		return gl.FRAGMENT_SHADER_BIT
+	case gl43.COMPUTE_SHADER:
+		return gl43.COMPUTE_SHADER_BIT
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+// program_test.go
+package utils
+
+import (
+	gl "github.com/chsc/gogl/gl42"
+	gl43 "github.com/chsc/gogl/gl43"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProgramBuilderCheck(t *testing.T) {
+	cases := []struct {
+		name string
+		b    *ProgramBuilder
+		ok   bool
+	}{
+		{"empty", NewProgramBuilder(), false},
+		{"graphics", NewProgramBuilder().Source(gl.VERTEX_SHADER, "").Source(gl.GEOMETRY_SHADER, "").
+			Source(gl.TESS_CONTROL_SHADER, "").Source(gl.TESS_EVALUATION_SHADER, "").Source(gl.FRAGMENT_SHADER, ""), true},
+		{"compute", NewProgramBuilder().Source(gl43.COMPUTE_SHADER, ""), true},
+		{"twice", NewProgramBuilder().Source(gl.VERTEX_SHADER, "").File(gl.VERTEX_SHADER, "a.glsl"), false},
+		{"mixed", NewProgramBuilder().Source(gl.VERTEX_SHADER, "").Source(gl43.COMPUTE_SHADER, ""), false},
+		{"unknown", NewProgramBuilder().Source(gl.TEXTURE_2D, ""), false},
+	}
+	for _, c := range cases {
+		if err := c.b.check(); (err == nil) != c.ok {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+
+	bits := cases[1].b.StageBits()
+	if bits != gl.VERTEX_SHADER_BIT|gl.GEOMETRY_SHADER_BIT|gl.TESS_CONTROL_SHADER_BIT|
+		gl.TESS_EVALUATION_SHADER_BIT|gl.FRAGMENT_SHADER_BIT {
+		t.Errorf("stage bits %#x", bits)
+	}
+}
+
+func TestProgramBuilderFiles(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "a.glsl")
+	if err := ioutil.WriteFile(name, []byte("void main() {}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srcs, files, err := NewProgramBuilder().File(gl43.COMPUTE_SHADER, name).sources()
+	if err != nil || srcs[0].glsl.Source != "void main() {}" || srcs[0].file != name || len(files) != 1 {
+		t.Errorf("sources %+v: %v", srcs, err)
+	}
+
+	_, err = NewProgramBuilder().File(gl.VERTEX_SHADER, name+".missing").Build()
+	if !os.IsNotExist(err) {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestShaderCompileErrors(t *testing.T) {
+	err := ShaderCompileErrors{
+		{Stage: gl.VERTEX_SHADER, File: "a.vs", Log: "0:1: error"},
+		{Stage: gl43.COMPUTE_SHADER, Log: "0:2: error"},
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "vertex (a.vs) shader") || !strings.HasPrefix(lines[1], "compute shader") {
+		t.Errorf("message %q", err.Error())
+	}
+}
@@ -0,0 +1,430 @@
+// reflection
+package utils
+
+import (
+	"fmt"
+	gl "github.com/chsc/gogl/gl42"
+	gl43 "github.com/chsc/gogl/gl43"
+	"sort"
+	"strings"
+)
+
+// Attribute is an active vertex attribute.
+type Attribute struct {
+	Name     string
+	Type     gl.Enum
+	Size     int // array length, 1 for non-arrays
+	Location int
+}
+
+// Uniform is an active uniform, in the default block or in a uniform block.
+// Offset and the strides are in bytes and only set for block members,
+// Location only for the default block.
+type Uniform struct {
+	Name         string
+	Type         gl.Enum
+	Size         int // array length, 1 for non-arrays
+	Location     int // -1 in a block
+	Block        int // index into Program.UniformBlocks, -1 in the default block
+	Offset       int
+	ArrayStride  int
+	MatrixStride int
+	RowMajor     bool
+}
+
+// UniformBlock is an active uniform block.
+type UniformBlock struct {
+	Name    string
+	Index   int
+	Binding int
+	Size    int // bytes
+	Members []Uniform
+}
+
+// BufferVariable is a member of a shader storage block. For a member of an
+// array of structs TopLevelSize and TopLevelStride describe the outer array.
+type BufferVariable struct {
+	Name           string
+	Type           gl.Enum
+	Size           int // array length, 0 for an unsized array
+	Offset         int
+	ArrayStride    int
+	MatrixStride   int
+	RowMajor       bool
+	TopLevelSize   int
+	TopLevelStride int
+}
+
+// StorageBlock is an active shader storage block.
+type StorageBlock struct {
+	Name    string
+	Index   int
+	Binding int
+	Size    int // bytes, without the unsized array at the end
+	Members []BufferVariable
+}
+
+// Subroutine is a subroutine function of one stage.
+type Subroutine struct {
+	Stage gl.Enum
+	Name  string
+	Index int
+}
+
+// SubroutineUniform is a subroutine uniform of one stage and the
+// subroutines, by name, that can be assigned to it.
+type SubroutineUniform struct {
+	Stage      gl.Enum
+	Name       string
+	Location   int
+	Size       int
+	Compatible []string
+}
+
+// Program is a linked program and everything active in it, read once when
+// it is created.
+type Program struct {
+	ID                 gl.Uint
+	Attributes         []Attribute
+	Uniforms           []Uniform // sorted by name, block members included
+	UniformBlocks      []UniformBlock
+	StorageBlocks      []StorageBlock // needs OpenGL 4.3, empty otherwise
+	Subroutines        []Subroutine
+	SubroutineUniforms []SubroutineUniform
+
+	uniforms map[string]int
+	elements map[string]*Uniform // array elements looked up by Set
+}
+
+// NewProgram reflects the linked program id.
+func NewProgram(id gl.Uint) *Program {
+	p := &Program{ID: id}
+	p.reflectAttributes()
+	p.reflectUniforms()
+	p.reflectSubroutines()
+	if initGL43() == nil {
+		p.reflectStorageBlocks()
+	}
+	p.index()
+	return p
+}
+
+// BuildProgram is Build returning the reflected program.
+func (b *ProgramBuilder) BuildProgram() (*Program, error) {
+	id, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	return NewProgram(id), nil
+}
+
+// getName reads a name of at most n bytes, counting the terminator, through
+// get.
+func getName(n gl.Int, get func(size gl.Sizei, name *gl.Char)) string {
+	if n < 1 {
+		n = 1
+	}
+	buf := gl.GLStringAlloc(gl.Sizei(n))
+	defer gl.GLStringFree(buf)
+	get(gl.Sizei(n), buf)
+	return gl.GoString(buf)
+}
+
+func (p *Program) programiv(pname gl.Enum) gl.Int {
+	var v gl.Int
+	gl.GetProgramiv(p.ID, pname, &v)
+	return v
+}
+
+func (p *Program) reflectAttributes() {
+	n := p.programiv(gl.ACTIVE_ATTRIBUTES)
+	maxLen := p.programiv(gl.ACTIVE_ATTRIBUTE_MAX_LENGTH)
+	for i := gl.Uint(0); i < gl.Uint(n); i++ {
+		var size gl.Int
+		var typ gl.Enum
+		name := getName(maxLen, func(bufSize gl.Sizei, name *gl.Char) {
+			gl.GetActiveAttrib(p.ID, i, bufSize, nil, &size, &typ, name)
+		})
+		cname := gl.GLString(name)
+		loc := gl.GetAttribLocation(p.ID, cname)
+		gl.GLStringFree(cname)
+		p.Attributes = append(p.Attributes, Attribute{Name: name, Type: typ, Size: int(size), Location: int(loc)})
+	}
+	sort.Slice(p.Attributes, func(i, j int) bool { return p.Attributes[i].Location < p.Attributes[j].Location })
+}
+
+func (p *Program) reflectUniforms() {
+	n := p.programiv(gl.ACTIVE_UNIFORMS)
+	maxLen := p.programiv(gl.ACTIVE_UNIFORM_MAX_LENGTH)
+	for i := gl.Uint(0); i < gl.Uint(n); i++ {
+		var size gl.Int
+		var typ gl.Enum
+		name := getName(maxLen, func(bufSize gl.Sizei, name *gl.Char) {
+			gl.GetActiveUniform(p.ID, i, bufSize, nil, &size, &typ, name)
+		})
+		iv := func(pname gl.Enum) int {
+			var v gl.Int
+			gl.GetActiveUniformsiv(p.ID, 1, &i, pname, &v)
+			return int(v)
+		}
+		u := Uniform{
+			Name:         name,
+			Type:         typ,
+			Size:         int(size),
+			Location:     -1,
+			Block:        iv(gl.UNIFORM_BLOCK_INDEX),
+			Offset:       iv(gl.UNIFORM_OFFSET),
+			ArrayStride:  iv(gl.UNIFORM_ARRAY_STRIDE),
+			MatrixStride: iv(gl.UNIFORM_MATRIX_STRIDE),
+			RowMajor:     iv(gl.UNIFORM_IS_ROW_MAJOR) != 0,
+		}
+		if u.Block < 0 {
+			cname := gl.GLString(name)
+			u.Location = int(gl.GetUniformLocation(p.ID, cname))
+			gl.GLStringFree(cname)
+		}
+		p.Uniforms = append(p.Uniforms, u)
+	}
+	sort.Slice(p.Uniforms, func(i, j int) bool { return p.Uniforms[i].Name < p.Uniforms[j].Name })
+
+	n = p.programiv(gl.ACTIVE_UNIFORM_BLOCKS)
+	for i := gl.Uint(0); i < gl.Uint(n); i++ {
+		iv := func(pname gl.Enum) gl.Int {
+			var v gl.Int
+			gl.GetActiveUniformBlockiv(p.ID, i, pname, &v)
+			return v
+		}
+		b := UniformBlock{
+			Index:   int(i),
+			Binding: int(iv(gl.UNIFORM_BLOCK_BINDING)),
+			Size:    int(iv(gl.UNIFORM_BLOCK_DATA_SIZE)),
+		}
+		b.Name = getName(iv(gl.UNIFORM_BLOCK_NAME_LENGTH), func(bufSize gl.Sizei, name *gl.Char) {
+			gl.GetActiveUniformBlockName(p.ID, i, bufSize, nil, name)
+		})
+		for _, u := range p.Uniforms {
+			if u.Block == int(i) {
+				b.Members = append(b.Members, u)
+			}
+		}
+		sort.Slice(b.Members, func(i, j int) bool { return b.Members[i].Offset < b.Members[j].Offset })
+		p.UniformBlocks = append(p.UniformBlocks, b)
+	}
+}
+
+// programStages lists the stages that may have subroutines.
+var programStages = []gl.Enum{
+	gl.VERTEX_SHADER, gl.TESS_CONTROL_SHADER, gl.TESS_EVALUATION_SHADER,
+	gl.GEOMETRY_SHADER, gl.FRAGMENT_SHADER,
+}
+
+func (p *Program) reflectSubroutines() {
+	for _, stage := range programStages {
+		iv := func(pname gl.Enum) gl.Int {
+			var v gl.Int
+			gl.GetProgramStageiv(p.ID, stage, pname, &v)
+			return v
+		}
+
+		n, maxLen := iv(gl.ACTIVE_SUBROUTINES), iv(gl.ACTIVE_SUBROUTINE_MAX_LENGTH)
+		names := make([]string, n)
+		for i := gl.Uint(0); i < gl.Uint(n); i++ {
+			names[i] = getName(maxLen, func(bufSize gl.Sizei, name *gl.Char) {
+				gl.GetActiveSubroutineName(p.ID, stage, i, bufSize, nil, name)
+			})
+			p.Subroutines = append(p.Subroutines, Subroutine{Stage: stage, Name: names[i], Index: int(i)})
+		}
+
+		n, maxLen = iv(gl.ACTIVE_SUBROUTINE_UNIFORMS), iv(gl.ACTIVE_SUBROUTINE_UNIFORM_MAX_LENGTH)
+		for i := gl.Uint(0); i < gl.Uint(n); i++ {
+			u := SubroutineUniform{Stage: stage}
+			u.Name = getName(maxLen, func(bufSize gl.Sizei, name *gl.Char) {
+				gl.GetActiveSubroutineUniformName(p.ID, stage, i, bufSize, nil, name)
+			})
+			cname := gl.GLString(u.Name)
+			u.Location = int(gl.GetSubroutineUniformLocation(p.ID, stage, cname))
+			gl.GLStringFree(cname)
+
+			var size, count gl.Int
+			gl.GetActiveSubroutineUniformiv(p.ID, stage, i, gl.UNIFORM_SIZE, &size)
+			gl.GetActiveSubroutineUniformiv(p.ID, stage, i, gl.NUM_COMPATIBLE_SUBROUTINES, &count)
+			u.Size = int(size)
+			if count > 0 {
+				compatible := make([]gl.Int, count)
+				gl.GetActiveSubroutineUniformiv(p.ID, stage, i, gl.COMPATIBLE_SUBROUTINES, &compatible[0])
+				for _, c := range compatible {
+					if int(c) < len(names) {
+						u.Compatible = append(u.Compatible, names[c])
+					}
+				}
+			}
+			p.SubroutineUniforms = append(p.SubroutineUniforms, u)
+		}
+	}
+}
+
+func (p *Program) reflectStorageBlocks() {
+	id := gl43.Uint(p.ID)
+	resourceiv := func(iface gl43.Enum, i gl43.Uint, props ...gl43.Enum) []gl43.Int {
+		v := make([]gl43.Int, len(props))
+		gl43.GetProgramResourceiv(id, iface, i, gl43.Sizei(len(props)), &props[0], gl43.Sizei(len(v)), nil, &v[0])
+		return v
+	}
+	resourceName := func(iface gl43.Enum, i gl43.Uint, n gl43.Int) string {
+		return getName(gl.Int(n), func(bufSize gl.Sizei, name *gl.Char) {
+			gl43.GetProgramResourceName(id, iface, i, gl43.Sizei(bufSize), nil, (*gl43.Char)(name))
+		})
+	}
+
+	var n gl43.Int
+	gl43.GetProgramInterfaceiv(id, gl43.SHADER_STORAGE_BLOCK, gl43.ACTIVE_RESOURCES, &n)
+	for i := gl43.Uint(0); i < gl43.Uint(n); i++ {
+		v := resourceiv(gl43.SHADER_STORAGE_BLOCK, i,
+			gl43.NAME_LENGTH, gl43.BUFFER_BINDING, gl43.BUFFER_DATA_SIZE, gl43.NUM_ACTIVE_VARIABLES)
+		b := StorageBlock{
+			Name:    resourceName(gl43.SHADER_STORAGE_BLOCK, i, v[0]),
+			Index:   int(i),
+			Binding: int(v[1]),
+			Size:    int(v[2]),
+		}
+		if v[3] > 0 {
+			vars := make([]gl43.Int, v[3])
+			prop := gl43.Enum(gl43.ACTIVE_VARIABLES)
+			gl43.GetProgramResourceiv(id, gl43.SHADER_STORAGE_BLOCK, i, 1, &prop, gl43.Sizei(len(vars)), nil, &vars[0])
+			for _, vi := range vars {
+				m := resourceiv(gl43.BUFFER_VARIABLE, gl43.Uint(vi),
+					gl43.NAME_LENGTH, gl43.TYPE, gl43.ARRAY_SIZE, gl43.OFFSET, gl43.ARRAY_STRIDE,
+					gl43.MATRIX_STRIDE, gl43.IS_ROW_MAJOR, gl43.TOP_LEVEL_ARRAY_SIZE, gl43.TOP_LEVEL_ARRAY_STRIDE)
+				b.Members = append(b.Members, BufferVariable{
+					Name:           resourceName(gl43.BUFFER_VARIABLE, gl43.Uint(vi), m[0]),
+					Type:           gl.Enum(m[1]),
+					Size:           int(m[2]),
+					Offset:         int(m[3]),
+					ArrayStride:    int(m[4]),
+					MatrixStride:   int(m[5]),
+					RowMajor:       m[6] != 0,
+					TopLevelSize:   int(m[7]),
+					TopLevelStride: int(m[8]),
+				})
+			}
+		}
+		sort.Slice(b.Members, func(i, j int) bool { return b.Members[i].Offset < b.Members[j].Offset })
+		p.StorageBlocks = append(p.StorageBlocks, b)
+	}
+}
+
+// index maps uniform names, with and without the [0] GL reports for arrays,
+// to their position in Uniforms.
+func (p *Program) index() {
+	p.uniforms = make(map[string]int, len(p.Uniforms))
+	for i, u := range p.Uniforms {
+		p.uniforms[u.Name] = i
+		if strings.HasSuffix(u.Name, "[0]") {
+			p.uniforms[strings.TrimSuffix(u.Name, "[0]")] = i
+		}
+	}
+}
+
+// Uniform returns the active uniform called name; arrays may be named with
+// or without [0].
+func (p *Program) Uniform(name string) (*Uniform, bool) {
+	i, ok := p.uniforms[name]
+	if !ok {
+		return nil, false
+	}
+	return &p.Uniforms[i], true
+}
+
+// Location returns the location of a uniform in the default block, -1 if
+// there is none called name.
+func (p *Program) Location(name string) gl.Int {
+	if u, ok := p.Uniform(name); ok {
+		return gl.Int(u.Location)
+	}
+	return -1
+}
+
+// Attribute returns the active attribute called name.
+func (p *Program) Attribute(name string) (*Attribute, bool) {
+	for i := range p.Attributes {
+		if p.Attributes[i].Name == name {
+			return &p.Attributes[i], true
+		}
+	}
+	return nil, false
+}
+
+// UniformBlock returns the active uniform block called name.
+func (p *Program) UniformBlock(name string) (*UniformBlock, bool) {
+	for i := range p.UniformBlocks {
+		if p.UniformBlocks[i].Name == name {
+			return &p.UniformBlocks[i], true
+		}
+	}
+	return nil, false
+}
+
+// StorageBlock returns the active shader storage block called name.
+func (p *Program) StorageBlock(name string) (*StorageBlock, bool) {
+	for i := range p.StorageBlocks {
+		if p.StorageBlocks[i].Name == name {
+			return &p.StorageBlocks[i], true
+		}
+	}
+	return nil, false
+}
+
+// BindUniformBlock assigns the uniform block called name to binding.
+func (p *Program) BindUniformBlock(name string, binding int) error {
+	b, ok := p.UniformBlock(name)
+	if !ok {
+		return fmt.Errorf("program: no uniform block %q", name)
+	}
+	gl.UniformBlockBinding(p.ID, gl.Uint(b.Index), gl.Uint(binding))
+	b.Binding = binding
+	return nil
+}
+
+// Use makes the program current.
+func (p *Program) Use() {
+	gl.UseProgram(p.ID)
+}
+
+// Delete deletes the program.
+func (p *Program) Delete() {
+	gl.DeleteProgram(p.ID)
+}
+
+// glslTypes names the GLSL types of uniforms and attributes.
+var glslTypes = map[gl.Enum]string{
+	gl.FLOAT: "float", gl.FLOAT_VEC2: "vec2", gl.FLOAT_VEC3: "vec3", gl.FLOAT_VEC4: "vec4",
+	gl.DOUBLE: "double", gl.DOUBLE_VEC2: "dvec2", gl.DOUBLE_VEC3: "dvec3", gl.DOUBLE_VEC4: "dvec4",
+	gl.INT: "int", gl.INT_VEC2: "ivec2", gl.INT_VEC3: "ivec3", gl.INT_VEC4: "ivec4",
+	gl.UNSIGNED_INT: "uint", gl.UNSIGNED_INT_VEC2: "uvec2", gl.UNSIGNED_INT_VEC3: "uvec3", gl.UNSIGNED_INT_VEC4: "uvec4",
+	gl.BOOL: "bool", gl.BOOL_VEC2: "bvec2", gl.BOOL_VEC3: "bvec3", gl.BOOL_VEC4: "bvec4",
+	gl.FLOAT_MAT2: "mat2", gl.FLOAT_MAT3: "mat3", gl.FLOAT_MAT4: "mat4",
+	gl.FLOAT_MAT2x3: "mat2x3", gl.FLOAT_MAT2x4: "mat2x4", gl.FLOAT_MAT3x2: "mat3x2",
+	gl.FLOAT_MAT3x4: "mat3x4", gl.FLOAT_MAT4x2: "mat4x2", gl.FLOAT_MAT4x3: "mat4x3",
+	gl.DOUBLE_MAT2: "dmat2", gl.DOUBLE_MAT3: "dmat3", gl.DOUBLE_MAT4: "dmat4",
+	gl.SAMPLER_1D: "sampler1D", gl.SAMPLER_2D: "sampler2D", gl.SAMPLER_3D: "sampler3D",
+	gl.SAMPLER_CUBE: "samplerCube", gl.SAMPLER_2D_SHADOW: "sampler2DShadow",
+	gl.SAMPLER_CUBE_SHADOW: "samplerCubeShadow", gl.SAMPLER_1D_ARRAY: "sampler1DArray",
+	gl.SAMPLER_2D_ARRAY: "sampler2DArray", gl.SAMPLER_2D_ARRAY_SHADOW: "sampler2DArrayShadow",
+	gl.SAMPLER_CUBE_MAP_ARRAY: "samplerCubeArray", gl.SAMPLER_2D_MULTISAMPLE: "sampler2DMS",
+	gl.SAMPLER_BUFFER: "samplerBuffer", gl.SAMPLER_2D_RECT: "sampler2DRect",
+	gl.INT_SAMPLER_2D: "isampler2D", gl.INT_SAMPLER_3D: "isampler3D", gl.INT_SAMPLER_2D_ARRAY: "isampler2DArray",
+	gl.UNSIGNED_INT_SAMPLER_2D: "usampler2D", gl.UNSIGNED_INT_SAMPLER_3D: "usampler3D",
+	gl.UNSIGNED_INT_SAMPLER_2D_ARRAY: "usampler2DArray",
+	gl.IMAGE_1D:                      "image1D", gl.IMAGE_2D: "image2D", gl.IMAGE_3D: "image3D", gl.IMAGE_CUBE: "imageCube",
+	gl.IMAGE_2D_ARRAY: "image2DArray", gl.IMAGE_BUFFER: "imageBuffer",
+	gl.INT_IMAGE_2D: "iimage2D", gl.UNSIGNED_INT_IMAGE_2D: "uimage2D",
+	gl.UNSIGNED_INT_ATOMIC_COUNTER: "atomic_uint",
+}
+
+// TypeName returns the GLSL name of a uniform or attribute type.
+func TypeName(t gl.Enum) string {
+	if s, ok := glslTypes[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("type %#x", uint32(t))
+}
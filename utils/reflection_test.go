@@ -0,0 +1,51 @@
+// reflection_test.go
+package utils
+
+import (
+	gl "github.com/chsc/gogl/gl42"
+	"testing"
+)
+
+func TestProgramLookup(t *testing.T) {
+	p := &Program{
+		Attributes: []Attribute{{Name: "position", Type: gl.FLOAT_VEC4, Size: 1, Location: 0}},
+		Uniforms: []Uniform{
+			{Name: "lights[0]", Type: gl.FLOAT_VEC3, Size: 4, Location: 2, Block: -1},
+			{Name: "mvp", Type: gl.FLOAT_MAT4, Size: 1, Location: 0, Block: -1},
+			{Name: "tint", Type: gl.FLOAT_VEC4, Size: 1, Location: -1, Block: 0, Offset: 64},
+		},
+		UniformBlocks: []UniformBlock{{Name: "Material", Size: 80}},
+	}
+	p.index()
+
+	for name, loc := range map[string]gl.Int{"mvp": 0, "lights": 2, "lights[0]": 2, "tint": -1, "none": -1} {
+		if got := p.Location(name); got != loc {
+			t.Errorf("location of %s: %d, want %d", name, got, loc)
+		}
+	}
+	if u, ok := p.Uniform("tint"); !ok || u.Offset != 64 {
+		t.Errorf("tint %+v", u)
+	}
+	if a, ok := p.Attribute("position"); !ok || TypeName(a.Type) != "vec4" {
+		t.Errorf("position %+v", a)
+	}
+	if _, ok := p.UniformBlock("Material"); !ok {
+		t.Error("block missing")
+	}
+	if err := p.BindUniformBlock("Lights", 1); err == nil {
+		t.Error("binding a missing block should fail")
+	}
+}
+
+func TestTypeName(t *testing.T) {
+	for typ, name := range map[gl.Enum]string{
+		gl.FLOAT_MAT4x3:      "mat4x3",
+		gl.SAMPLER_2D_SHADOW: "sampler2DShadow",
+		gl.UNSIGNED_INT_VEC2: "uvec2",
+		gl.Enum(0x1234):      "type 0x1234",
+	} {
+		if got := TypeName(typ); got != name {
+			t.Errorf("TypeName(%#x) = %s, want %s", uint32(typ), got, name)
+		}
+	}
+}
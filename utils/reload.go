@@ -0,0 +1,167 @@
+// reload
+package utils
+
+import (
+	gl "github.com/chsc/gogl/gl42"
+	"log"
+	"os"
+	"time"
+)
+
+// DefaultReloadInterval is how often ShaderReloader.Update checks files when
+// Interval is 0.
+const DefaultReloadInterval = 500 * time.Millisecond
+
+// ReloadableProgram is a program that is rebuilt when one of its files,
+// includes among them, changes. A failed rebuild keeps the last good program.
+type ReloadableProgram struct {
+	// OnReload, if set, is called with the new program after a successful
+	// rebuild, to look up uniform locations again.
+	OnReload func(program gl.Uint)
+
+	b       *ProgramBuilder
+	program gl.Uint
+	err     error
+	mtimes  map[string]time.Time
+	deleted bool
+}
+
+// Program returns the current program.
+func (p *ReloadableProgram) Program() gl.Uint {
+	return p.program
+}
+
+// Err returns the error of the last rebuild, nil if it succeeded.
+func (p *ReloadableProgram) Err() error {
+	return p.err
+}
+
+// Delete deletes the program and stops watching its files.
+func (p *ReloadableProgram) Delete() {
+	gl.DeleteProgram(p.program)
+	p.program, p.deleted = 0, true
+}
+
+// watch records the modification times of files. Unless keep is false the
+// files already watched stay watched, so a build that stopped early still
+// notices a fix to a later stage.
+func (p *ReloadableProgram) watch(files []string, keep bool) {
+	if !keep || p.mtimes == nil {
+		p.mtimes = make(map[string]time.Time)
+	}
+	for _, f := range files {
+		p.mtimes[f] = time.Time{}
+	}
+	for f := range p.mtimes {
+		p.mtimes[f] = modTime(f)
+	}
+}
+
+// changed reports whether a watched file has changed since watch.
+func (p *ReloadableProgram) changed() bool {
+	for f, t := range p.mtimes {
+		if !modTime(f).Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// reload rebuilds the program, swapping it in if the build succeeds.
+func (p *ReloadableProgram) reload() {
+	program, files, err := p.b.build()
+	if err != nil {
+		p.watch(files, true)
+		p.err = err
+		log.Println("shader reload:", err)
+		return
+	}
+	p.watch(files, false)
+	gl.DeleteProgram(p.program)
+	p.program, p.err = program, nil
+	log.Println("shader reload: rebuilt program", program)
+	if p.OnReload != nil {
+		p.OnReload(program)
+	}
+}
+
+// modTime returns the modification time of f, zero if it can't be read.
+func modTime(f string) time.Time {
+	fi, err := os.Stat(f)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// ShaderReloader polls the files of the programs it watches and rebuilds
+// them on the GL thread.
+type ShaderReloader struct {
+	Interval time.Duration // between checks, DefaultReloadInterval if 0
+
+	programs []*ReloadableProgram
+	last     time.Time
+}
+
+// Watch builds a program from b and watches its files. The first build must
+// succeed.
+func (r *ShaderReloader) Watch(b *ProgramBuilder) (*ReloadableProgram, error) {
+	program, files, err := b.build()
+	if err != nil {
+		return nil, err
+	}
+	p := &ReloadableProgram{b: b, program: program}
+	p.watch(files, false)
+	r.programs = append(r.programs, p)
+	return p, nil
+}
+
+// Update rebuilds the programs whose files changed, at most once an
+// Interval. It must be called on the GL thread, which GlfwMainLoop does for
+// the programs of WatchShaders and WatchProgram.
+func (r *ShaderReloader) Update() {
+	interval := r.Interval
+	if interval == 0 {
+		interval = DefaultReloadInterval
+	}
+	if time.Since(r.last) < interval {
+		return
+	}
+	r.last = time.Now()
+
+	programs := r.programs[:0]
+	for _, p := range r.programs {
+		if p.deleted {
+			continue
+		}
+		if p.changed() {
+			p.reload()
+		}
+		programs = append(programs, p)
+	}
+	r.programs = programs
+}
+
+// Err returns the error of the first program whose last rebuild failed.
+func (r *ShaderReloader) Err() error {
+	for _, p := range r.programs {
+		if p.err != nil && !p.deleted {
+			return p.err
+		}
+	}
+	return nil
+}
+
+var shaderReloader ShaderReloader
+
+// WatchShaders is CompileShaders for files, returning a program that
+// GlfwMainLoop rebuilds when they change.
+func WatchShaders(vert, frag string) (*ReloadableProgram, error) {
+	return WatchProgram(NewProgramBuilder().File(gl.VERTEX_SHADER, vert).File(gl.FRAGMENT_SHADER, frag))
+}
+
+// WatchProgram builds a program from b that GlfwMainLoop rebuilds when its
+// files change.
+func WatchProgram(b *ProgramBuilder) (*ReloadableProgram, error) {
+	return shaderReloader.Watch(b)
+}
@@ -0,0 +1,65 @@
+// reload_test.go
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloadableProgramWatch(t *testing.T) {
+	dir := t.TempDir()
+	vs, inc := filepath.Join(dir, "a.vs"), filepath.Join(dir, "common.glsl")
+	for _, f := range []string{vs, inc} {
+		if err := ioutil.WriteFile(f, []byte("//"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := &ReloadableProgram{}
+	p.watch([]string{vs, inc}, false)
+	if p.changed() {
+		t.Fatal("changed before any write")
+	}
+
+	touch := func(f string, d time.Duration) {
+		mt := time.Now().Add(d)
+		if err := os.Chtimes(f, mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	touch(inc, time.Hour)
+	if !p.changed() {
+		t.Error("include change missed")
+	}
+
+	// A failed build that only got as far as vs keeps inc watched.
+	p.watch([]string{vs}, true)
+	if p.changed() || len(p.mtimes) != 2 {
+		t.Errorf("watching %v", p.mtimes)
+	}
+	os.Remove(inc)
+	if !p.changed() {
+		t.Error("removal missed")
+	}
+
+	p.watch([]string{vs}, false)
+	if len(p.mtimes) != 1 {
+		t.Errorf("watching %v", p.mtimes)
+	}
+}
+
+func TestShaderReloaderInterval(t *testing.T) {
+	r := &ShaderReloader{Interval: time.Hour}
+	p := &ReloadableProgram{mtimes: map[string]time.Time{"gone.glsl": time.Now()}, deleted: true}
+	r.programs = append(r.programs, p)
+	r.Update()
+	if len(r.programs) != 0 {
+		t.Error("deleted program still watched")
+	}
+	if r.last.IsZero() || r.Err() != nil {
+		t.Error("update not recorded")
+	}
+}
@@ -0,0 +1,185 @@
+// sampler
+package utils
+
+import (
+	gl "github.com/chsc/gogl/gl42"
+)
+
+// From EXT_texture_filter_anisotropic.
+const (
+	glTextureMaxAnisotropy = 0x84FE
+)
+
+// SamplerDesc describes how a texture is sampled. Zero fields take the
+// defaults noted, so a zero SamplerDesc gives trilinear filtering and
+// repeating coordinates.
+type SamplerDesc struct {
+	MinFilter     gl.Enum // LINEAR_MIPMAP_LINEAR if 0
+	MagFilter     gl.Enum // LINEAR if 0
+	WrapS         gl.Enum // REPEAT if 0
+	WrapT         gl.Enum // REPEAT if 0
+	WrapR         gl.Enum // REPEAT if 0
+	MaxAnisotropy float32 // needs EXT_texture_filter_anisotropic, off if 1 or less
+	LodBias       float32
+	CompareFunc   gl.Enum // depth comparison with this function, off if 0
+	BorderColor   [4]float32
+}
+
+// normalize fills in the defaults, so equal samplers compare equal.
+func (d SamplerDesc) normalize() SamplerDesc {
+	if d.MinFilter == 0 {
+		d.MinFilter = gl.LINEAR_MIPMAP_LINEAR
+	}
+	if d.MagFilter == 0 {
+		d.MagFilter = gl.LINEAR
+	}
+	for _, w := range []*gl.Enum{&d.WrapS, &d.WrapT, &d.WrapR} {
+		if *w == 0 {
+			*w = gl.REPEAT
+		}
+	}
+	if d.MaxAnisotropy < 1 {
+		d.MaxAnisotropy = 1
+	}
+	return d
+}
+
+type samplerParam struct {
+	pname gl.Enum
+	i     gl.Int
+	f     []gl.Float
+}
+
+// params lists the parameters that set up d. A texture with a single level
+// is given the non-mipmapped version of a mipmapped MinFilter, so it stays
+// complete; levels of 0 leaves the filter alone.
+func (d SamplerDesc) params(levels int) []samplerParam {
+	d = d.normalize()
+
+	min := d.MinFilter
+	if levels == 1 {
+		switch min {
+		case gl.NEAREST_MIPMAP_NEAREST, gl.NEAREST_MIPMAP_LINEAR:
+			min = gl.NEAREST
+		case gl.LINEAR_MIPMAP_NEAREST, gl.LINEAR_MIPMAP_LINEAR:
+			min = gl.LINEAR
+		}
+	}
+
+	p := []samplerParam{
+		{pname: gl.TEXTURE_MIN_FILTER, i: gl.Int(min)},
+		{pname: gl.TEXTURE_MAG_FILTER, i: gl.Int(d.MagFilter)},
+		{pname: gl.TEXTURE_WRAP_S, i: gl.Int(d.WrapS)},
+		{pname: gl.TEXTURE_WRAP_T, i: gl.Int(d.WrapT)},
+		{pname: gl.TEXTURE_WRAP_R, i: gl.Int(d.WrapR)},
+		{pname: gl.TEXTURE_LOD_BIAS, f: []gl.Float{gl.Float(d.LodBias)}},
+		{pname: gl.TEXTURE_BORDER_COLOR, f: ToGLFloat(d.BorderColor[:])},
+	}
+	if d.CompareFunc != 0 {
+		p = append(p,
+			samplerParam{pname: gl.TEXTURE_COMPARE_MODE, i: gl.COMPARE_REF_TO_TEXTURE},
+			samplerParam{pname: gl.TEXTURE_COMPARE_FUNC, i: gl.Int(d.CompareFunc)})
+	} else {
+		p = append(p, samplerParam{pname: gl.TEXTURE_COMPARE_MODE, i: gl.NONE})
+	}
+	// Left out unless asked for, GL without the extension rejects it.
+	if d.MaxAnisotropy > 1 {
+		p = append(p, samplerParam{pname: glTextureMaxAnisotropy, f: []gl.Float{gl.Float(d.MaxAnisotropy)}})
+	}
+	return p
+}
+
+// Apply sets d as the parameters of the texture bound to target.
+func (d SamplerDesc) Apply(target gl.Enum) {
+	d.apply(target, 0)
+}
+
+func (d SamplerDesc) apply(target gl.Enum, levels int) {
+	for _, p := range d.params(levels) {
+		if p.f == nil {
+			gl.TexParameteri(target, p.pname, p.i)
+		} else {
+			gl.TexParameterfv(target, p.pname, &p.f[0])
+		}
+	}
+}
+
+// NewSampler creates a sampler object set up as d.
+func NewSampler(d SamplerDesc) gl.Uint {
+	var s gl.Uint
+	gl.GenSamplers(1, &s)
+	for _, p := range d.params(0) {
+		if p.f == nil {
+			gl.SamplerParameteri(s, p.pname, p.i)
+		} else {
+			gl.SamplerParameterfv(s, p.pname, &p.f[0])
+		}
+	}
+	return s
+}
+
+// textureLevels returns the number of levels UploadKtx allocates for h.
+func textureLevels(h *KTXHeader) int {
+	if h.Miplevels == 0 && !h.IsCompressed() {
+		return fullMipCount(h)
+	}
+	return int(h.Mips())
+}
+
+// LoadKtxSampler loads a KTX file like LoadKtx and sets s as the parameters
+// of the texture. A nil s stands for the defaults, SamplerDesc{}.
+func LoadKtxSampler(filename string, tex gl.Uint, s *SamplerDesc) (gl.Uint, error) {
+	f, err := readKtx(filename)
+	if err != nil {
+		return 0, err
+	}
+	if tex, err = UploadKtx(f, tex); err != nil {
+		return 0, err
+	}
+	if s == nil {
+		s = &SamplerDesc{}
+	}
+	s.apply(f.Target, textureLevels(&f.Header))
+	return tex, nil
+}
+
+// SamplerCache shares one sampler object between equal descriptions.
+type SamplerCache struct {
+	samplers   map[SamplerDesc]gl.Uint
+	newSampler func(SamplerDesc) gl.Uint
+}
+
+func NewSamplerCache() *SamplerCache {
+	return &SamplerCache{samplers: make(map[SamplerDesc]gl.Uint), newSampler: NewSampler}
+}
+
+// Get returns the sampler object for d, creating it the first time.
+func (c *SamplerCache) Get(d SamplerDesc) gl.Uint {
+	d = d.normalize()
+	s, ok := c.samplers[d]
+	if !ok {
+		s = c.newSampler(d)
+		c.samplers[d] = s
+	}
+	return s
+}
+
+// Bind binds the sampler object for d to texture unit unit.
+func (c *SamplerCache) Bind(unit int, d SamplerDesc) gl.Uint {
+	s := c.Get(d)
+	gl.BindSampler(gl.Uint(unit), s)
+	return s
+}
+
+// Len returns the number of sampler objects in the cache.
+func (c *SamplerCache) Len() int {
+	return len(c.samplers)
+}
+
+// Delete deletes every sampler object in the cache.
+func (c *SamplerCache) Delete() {
+	for d, s := range c.samplers {
+		gl.DeleteSamplers(1, &s)
+		delete(c.samplers, d)
+	}
+}
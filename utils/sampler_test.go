@@ -0,0 +1,85 @@
+// sampler_test.go
+package utils
+
+import (
+	gl "github.com/chsc/gogl/gl42"
+	"testing"
+)
+
+func paramMap(p []samplerParam) map[gl.Enum]samplerParam {
+	m := make(map[gl.Enum]samplerParam)
+	for _, v := range p {
+		m[v.pname] = v
+	}
+	return m
+}
+
+func TestSamplerDefaults(t *testing.T) {
+	if (SamplerDesc{}).normalize() != (SamplerDesc{MinFilter: gl.LINEAR_MIPMAP_LINEAR, MagFilter: gl.LINEAR,
+		WrapS: gl.REPEAT, WrapT: gl.REPEAT, WrapR: gl.REPEAT, MaxAnisotropy: 1}).normalize() {
+		t.Error("zero and explicit defaults differ")
+	}
+
+	p := paramMap(SamplerDesc{}.params(0))
+	if p[gl.TEXTURE_MIN_FILTER].i != gl.LINEAR_MIPMAP_LINEAR || p[gl.TEXTURE_WRAP_R].i != gl.REPEAT {
+		t.Errorf("params %+v", p)
+	}
+	if p[gl.TEXTURE_COMPARE_MODE].i != gl.NONE {
+		t.Error("compare mode should be off")
+	}
+	if _, ok := p[glTextureMaxAnisotropy]; ok {
+		t.Error("anisotropy should be left out")
+	}
+}
+
+func TestSamplerParams(t *testing.T) {
+	d := SamplerDesc{
+		MinFilter:     gl.NEAREST_MIPMAP_NEAREST,
+		WrapS:         gl.CLAMP_TO_BORDER,
+		MaxAnisotropy: 8,
+		LodBias:       -0.5,
+		CompareFunc:   gl.LEQUAL,
+		BorderColor:   [4]float32{1, 0, 0, 1},
+	}
+	p := paramMap(d.params(1))
+	if p[gl.TEXTURE_MIN_FILTER].i != gl.NEAREST {
+		t.Errorf("single level min filter %#x", p[gl.TEXTURE_MIN_FILTER].i)
+	}
+	if p[gl.TEXTURE_COMPARE_MODE].i != gl.COMPARE_REF_TO_TEXTURE || p[gl.TEXTURE_COMPARE_FUNC].i != gl.LEQUAL {
+		t.Error("compare not set")
+	}
+	if a := p[glTextureMaxAnisotropy].f; len(a) != 1 || a[0] != 8 {
+		t.Errorf("anisotropy %v", a)
+	}
+	if b := p[gl.TEXTURE_BORDER_COLOR].f; len(b) != 4 || b[0] != 1 || b[1] != 0 {
+		t.Errorf("border %v", b)
+	}
+	if p[gl.TEXTURE_LOD_BIAS].f[0] != -0.5 {
+		t.Error("lod bias not set")
+	}
+
+	if m := paramMap(d.params(0)); m[gl.TEXTURE_MIN_FILTER].i != gl.NEAREST_MIPMAP_NEAREST {
+		t.Error("min filter changed without a level count")
+	}
+}
+
+func TestSamplerCache(t *testing.T) {
+	c := NewSamplerCache()
+	var created []SamplerDesc
+	c.newSampler = func(d SamplerDesc) gl.Uint {
+		created = append(created, d)
+		return gl.Uint(len(created))
+	}
+
+	a := c.Get(SamplerDesc{})
+	b := c.Get(SamplerDesc{MagFilter: gl.LINEAR, WrapT: gl.REPEAT})
+	if a != b || c.Len() != 1 {
+		t.Errorf("defaults not shared: %d, %d, %d samplers", a, b, c.Len())
+	}
+	if n := c.Get(SamplerDesc{MagFilter: gl.NEAREST}); n == a || c.Len() != 2 {
+		t.Errorf("distinct description got sampler %d, %d samplers", n, c.Len())
+	}
+	if len(created) != 2 || created[0] != (SamplerDesc{}).normalize() {
+		t.Errorf("created %+v", created)
+	}
+}
@@ -0,0 +1,389 @@
+// texloader
+package utils
+
+import (
+	"errors"
+	gl "github.com/chsc/gogl/gl42"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultUploadBudget is how many bytes TextureLoader.Update uploads when
+// Budget is 0.
+const DefaultUploadBudget = 4 << 20
+
+var errLoaderClosed = errors.New("texture loader closed")
+
+// AsyncTexture is a texture being loaded by a TextureLoader.
+type AsyncTexture struct {
+	mu          sync.Mutex
+	tex         gl.Uint
+	target      gl.Enum
+	ready       bool
+	err         error
+	placeholder gl.Uint
+}
+
+// Ready reports whether every image has been uploaded.
+func (t *AsyncTexture) Ready() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ready
+}
+
+// Err returns the error that stopped the load, if any.
+func (t *AsyncTexture) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Texture returns the texture once it is ready, and the loader's 2D
+// placeholder until then or if the load failed.
+func (t *AsyncTexture) Texture() gl.Uint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.ready {
+		return t.placeholder
+	}
+	return t.tex
+}
+
+// Target returns the target of the texture, gl.NONE until it is ready.
+func (t *AsyncTexture) Target() gl.Enum {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.target
+}
+
+func (t *AsyncTexture) finish(tex gl.Uint, target gl.Enum, err error) {
+	t.mu.Lock()
+	t.tex, t.target, t.ready, t.err = tex, target, err == nil, err
+	t.mu.Unlock()
+}
+
+// uploadSlice is a run of rows that go up with one kind of call: a 1D
+// level, a 2D level or cube face, or one layer or depth slice of a 3D call.
+// For block compressed formats a row is a row of blocks.
+type uploadSlice struct {
+	target    gl.Enum
+	level     int
+	dims      int
+	z         uint32
+	width     uint32
+	height    uint32
+	rowBytes  uint64
+	rowTexels uint32
+	data      []byte
+}
+
+func (s *uploadSlice) rows() uint32 {
+	return (s.height + s.rowTexels - 1) / s.rowTexels
+}
+
+// uploadSlices splits f, which has passed checkUpload, into slices.
+func uploadSlices(f *KTXFile) []uploadSlice {
+	h := &f.Header
+	align := max1(uint32(f.Alignment))
+	layers := h.Layers() * h.FaceCount()
+
+	var slices []uploadSlice
+	for i, level := range f.Levels {
+		s := uploadSlice{
+			target:    f.Target,
+			level:     i,
+			dims:      2,
+			width:     level.Width,
+			height:    level.Height,
+			rowBytes:  calcStride(h, level.Width, align),
+			rowTexels: 1,
+			data:      level.Data,
+		}
+		if c, ok := compressedFormat(h); ok {
+			s.rowBytes = uint64((level.Width+c.blockWidth-1)/c.blockWidth) * uint64(c.blockSize)
+			s.rowTexels = c.blockHeight
+		}
+
+		switch f.Target {
+		case gl.TEXTURE_1D:
+			s.dims, s.height = 1, 1
+			slices = append(slices, s)
+		case gl.TEXTURE_1D_ARRAY:
+			// Layers are the rows of a 2D call.
+			s.height = layers
+			slices = append(slices, s)
+		case gl.TEXTURE_2D:
+			slices = append(slices, s)
+		case gl.TEXTURE_CUBE_MAP:
+			for face, img := range level.Images {
+				s.target = gl.Enum(gl.TEXTURE_CUBE_MAP_POSITIVE_X + face)
+				s.data = img.Data
+				slices = append(slices, s)
+			}
+		case gl.TEXTURE_3D:
+			s.dims = 3
+			size := uint64(s.rows()) * s.rowBytes
+			for z := uint32(0); z < level.Depth; z++ {
+				s.z = z
+				s.data = level.Data[uint64(z)*size : uint64(z+1)*size]
+				slices = append(slices, s)
+			}
+		case gl.TEXTURE_2D_ARRAY, gl.TEXTURE_CUBE_MAP_ARRAY:
+			s.dims = 3
+			for z, img := range level.Images {
+				s.z = uint32(z)
+				s.data = img.Data
+				slices = append(slices, s)
+			}
+		}
+	}
+	return slices
+}
+
+type asyncJob struct {
+	tex *AsyncTexture
+	f   *KTXFile
+	err error
+
+	// Upload state, only touched on the GL thread.
+	name     gl.Uint
+	generate bool
+	u        *ktxUploader
+	slices   []uploadSlice
+	slice    int
+	row      uint32
+}
+
+// TextureLoader decodes textures on worker goroutines and uploads them
+// through a pixel buffer object a bounded number of bytes at a time. Create
+// it, call Update once a frame and Close it on the GL thread; Load may be
+// called from anywhere.
+type TextureLoader struct {
+	Budget  int          // bytes uploaded per Update, DefaultUploadBudget if 0
+	Sampler *SamplerDesc // set on each texture as it completes if not nil
+
+	mu      sync.Mutex
+	inbox   []*asyncJob
+	pending int
+	closed  bool
+
+	workers     chan struct{}
+	wg          sync.WaitGroup
+	queue       []*asyncJob
+	pbo         gl.Uint
+	placeholder gl.Uint
+}
+
+// NewTextureLoader creates a loader decoding at most workers files at once,
+// along with its PBO and a grey 1x1 placeholder texture.
+func NewTextureLoader(workers int) *TextureLoader {
+	if workers < 1 {
+		workers = 1
+	}
+	l := &TextureLoader{workers: make(chan struct{}, workers)}
+
+	gl.GenBuffers(1, &l.pbo)
+
+	grey := []byte{128, 128, 128, 255}
+	gl.GenTextures(1, &l.placeholder)
+	gl.BindTexture(gl.TEXTURE_2D, l.placeholder)
+	gl.TexStorage2D(gl.TEXTURE_2D, 1, gl.RGBA8, 1, 1)
+	gl.TexSubImage2D(gl.TEXTURE_2D, 0, 0, 0, 1, 1, gl.RGBA, gl.UNSIGNED_BYTE, gl.Pointer(&grey[0]))
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+
+	return l
+}
+
+// Placeholder returns the texture AsyncTexture.Texture falls back to.
+func (l *TextureLoader) Placeholder() gl.Uint {
+	return l.placeholder
+}
+
+// Load starts loading a KTX, DDS, Radiance HDR, OpenEXR (as half floats) or
+// PNG, JPEG or GIF file, picked by extension, the way the synchronous
+// loaders read them.
+func (l *TextureLoader) Load(filename string) *AsyncTexture {
+	return l.LoadFunc(func() (*KTXFile, error) {
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".ktx", ".ktx2":
+			return readKtx(filename)
+		case ".dds":
+			return readDds(filename)
+		case ".hdr", ".pic", ".rgbe", ".exr":
+			return readFloatKtx(filename, true)
+		}
+		return readImageKtx(filename, nil)
+	})
+}
+
+// LoadFunc starts loading the texture read returns. read runs on a worker
+// goroutine and must not touch GL.
+func (l *TextureLoader) LoadFunc(read func() (*KTXFile, error)) *AsyncTexture {
+	t := &AsyncTexture{placeholder: l.placeholder}
+	j := &asyncJob{tex: t}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		t.finish(0, gl.NONE, errLoaderClosed)
+		return t
+	}
+	l.pending++
+	l.wg.Add(1)
+
+	go func() {
+		defer l.wg.Done()
+
+		l.workers <- struct{}{}
+		f, err := read()
+		if err == nil {
+			err = checkUpload(f)
+		}
+		<-l.workers
+
+		j.f, j.err = f, err
+		l.mu.Lock()
+		l.inbox = append(l.inbox, j)
+		l.mu.Unlock()
+	}()
+	return t
+}
+
+// Update uploads decoded textures, at least one row and at most Budget bytes
+// unless a single row is larger, and returns the number of loads still in
+// flight. It must be called on the GL thread.
+func (l *TextureLoader) Update() int {
+	l.mu.Lock()
+	l.queue = append(l.queue, l.inbox...)
+	l.inbox = nil
+	l.mu.Unlock()
+
+	budget := uint64(l.Budget)
+	if budget == 0 {
+		budget = DefaultUploadBudget
+	}
+
+	done := 0
+	sent := false
+	for len(l.queue) > 0 {
+		j := l.queue[0]
+		if j.err == nil {
+			if !l.upload(j, &budget, &sent) {
+				break
+			}
+			if j.generate {
+				gl.GenerateMipmap(j.f.Target)
+			}
+			if l.Sampler != nil {
+				l.Sampler.apply(j.f.Target, textureLevels(&j.f.Header))
+			}
+			j.tex.finish(j.name, j.f.Target, nil)
+		} else {
+			j.tex.finish(0, gl.NONE, j.err)
+		}
+		l.queue[0] = nil
+		l.queue = l.queue[1:]
+		done++
+	}
+	gl.BindBuffer(gl.PIXEL_UNPACK_BUFFER, 0)
+
+	l.mu.Lock()
+	l.pending -= done
+	pending := l.pending
+	l.mu.Unlock()
+	return pending
+}
+
+// upload sends the rows of j that fit in budget and reports whether j is
+// complete.
+func (l *TextureLoader) upload(j *asyncJob, budget *uint64, sent *bool) bool {
+	if j.u == nil {
+		j.name, j.generate = allocKtx(j.f, 0)
+		j.u = newKtxUploader(&j.f.Header)
+		j.slices = uploadSlices(j.f)
+	} else {
+		gl.BindTexture(j.f.Target, j.name)
+	}
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, gl.Int(max1(uint32(j.f.Alignment))))
+
+	for j.slice < len(j.slices) {
+		s := &j.slices[j.slice]
+		rows := s.rows() - j.row
+		if fit := *budget / s.rowBytes; uint64(rows) > fit {
+			rows = uint32(fit)
+		}
+		if rows == 0 {
+			if *sent {
+				return false
+			}
+			rows = 1
+		}
+
+		data := s.data[uint64(j.row)*s.rowBytes : uint64(j.row+rows)*s.rowBytes]
+		ptr := l.stage(data)
+		y := j.row * s.rowTexels
+		height := rows * s.rowTexels
+		if y+height > s.height {
+			height = s.height - y
+		}
+		switch s.dims {
+		case 1:
+			j.u.subImage1D(s.target, s.level, s.width, len(data), ptr)
+		case 2:
+			j.u.subImage2D(s.target, s.level, y, s.width, height, len(data), ptr)
+		case 3:
+			j.u.subImage3D(s.target, s.level, y, s.z, s.width, height, 1, len(data), ptr)
+		}
+
+		*sent = true
+		if uint64(len(data)) < *budget {
+			*budget -= uint64(len(data))
+		} else {
+			*budget = 0
+		}
+		if j.row += rows; j.row == s.rows() {
+			j.slice++
+			j.row = 0
+		}
+	}
+	return true
+}
+
+// stage copies data into the PBO, orphaning its previous contents, and
+// returns the pointer to pass to GL: offset 0 of the bound PBO, or data
+// itself if the buffer can't be mapped.
+func (l *TextureLoader) stage(data []byte) gl.Pointer {
+	gl.BindBuffer(gl.PIXEL_UNPACK_BUFFER, l.pbo)
+	gl.BufferData(gl.PIXEL_UNPACK_BUFFER, gl.Sizeiptr(len(data)), nil, gl.STREAM_DRAW)
+	ptr := gl.MapBufferRange(gl.PIXEL_UNPACK_BUFFER, 0, gl.Sizeiptr(len(data)),
+		gl.MAP_WRITE_BIT|gl.MAP_INVALIDATE_BUFFER_BIT)
+	if ptr != nil {
+		copy((*[1 << 30]byte)(ptr)[:len(data):len(data)], data)
+		if gl.UnmapBuffer(gl.PIXEL_UNPACK_BUFFER) != gl.FALSE {
+			return nil
+		}
+	}
+	gl.BindBuffer(gl.PIXEL_UNPACK_BUFFER, 0)
+	return gl.Pointer(&data[0])
+}
+
+// Close waits for the workers, fails the loads that haven't completed and
+// deletes the PBO and the placeholder. It must be called on the GL thread.
+func (l *TextureLoader) Close() {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	l.wg.Wait()
+
+	for _, j := range append(l.queue, l.inbox...) {
+		if j.name != 0 {
+			gl.DeleteTextures(1, &j.name)
+		}
+		j.tex.finish(0, gl.NONE, errLoaderClosed)
+	}
+	l.queue, l.inbox, l.pending = nil, nil, 0
+
+	gl.DeleteBuffers(1, &l.pbo)
+	gl.DeleteTextures(1, &l.placeholder)
+}
@@ -0,0 +1,56 @@
+// texloader_test.go
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	gl "github.com/chsc/gogl/gl42"
+	"testing"
+)
+
+func TestUploadSlices(t *testing.T) {
+	want := map[string]int{
+		"1d": 3, "1d-array-rgb": 2, "2d-rgb-odd": 2, "2d-r16": 2, "2d-r32f": 2, "2d-rgb565": 1, "3d": 7,
+		"2d-array": 8, "cube": 12, "cube-array": 24, "2d-bc1": 3, "cube-bc1": 6,
+	}
+
+	for _, c := range ktxCorpus() {
+		f, err := ParseKtx(bytes.NewReader(specKtx(c, binary.LittleEndian)))
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if err := checkUpload(f); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		slices := uploadSlices(f)
+		if len(slices) != want[c.name] {
+			t.Errorf("%s: %d slices, want %d", c.name, len(slices), want[c.name])
+		}
+		for i, s := range slices {
+			// Every row the calls ask for is backed by data.
+			if uint64(len(s.data)) < uint64(s.rows())*s.rowBytes {
+				t.Errorf("%s: slice %d holds %d bytes for %d rows of %d", c.name, i, len(s.data), s.rows(), s.rowBytes)
+			}
+		}
+	}
+}
+
+func TestUploadSlicesCompressed(t *testing.T) {
+	var c *ktxCase
+	for _, k := range ktxCorpus() {
+		if k.name == "2d-bc1" {
+			c = k
+		}
+	}
+	f, err := ParseKtx(bytes.NewReader(specKtx(c, binary.LittleEndian)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 10x6 DXT1 is 3x2 blocks of 8 bytes, uploaded a block row at a time.
+	s := uploadSlices(f)[0]
+	if s.target != gl.TEXTURE_2D || s.rowBytes != 24 || s.rowTexels != 4 || s.rows() != 2 {
+		t.Errorf("slice %+v", s)
+	}
+}
@@ -0,0 +1,309 @@
+// uniform
+package utils
+
+import (
+	"fmt"
+	gl "github.com/chsc/gogl/gl42"
+	"github.com/ginuerzh/math3d"
+	"strconv"
+	"strings"
+)
+
+// uniformType is the shape of a GLSL type: base 'f' float, 'd' double, 'i'
+// int, 'u' uint, 'b' bool or 's' sampler or image, and cols columns of rows
+// components.
+type uniformType struct {
+	base       byte
+	cols, rows int
+}
+
+var uniformTypes = map[gl.Enum]uniformType{
+	gl.FLOAT: {'f', 1, 1}, gl.FLOAT_VEC2: {'f', 1, 2}, gl.FLOAT_VEC3: {'f', 1, 3}, gl.FLOAT_VEC4: {'f', 1, 4},
+	gl.DOUBLE: {'d', 1, 1}, gl.DOUBLE_VEC2: {'d', 1, 2}, gl.DOUBLE_VEC3: {'d', 1, 3}, gl.DOUBLE_VEC4: {'d', 1, 4},
+	gl.INT: {'i', 1, 1}, gl.INT_VEC2: {'i', 1, 2}, gl.INT_VEC3: {'i', 1, 3}, gl.INT_VEC4: {'i', 1, 4},
+	gl.UNSIGNED_INT: {'u', 1, 1}, gl.UNSIGNED_INT_VEC2: {'u', 1, 2}, gl.UNSIGNED_INT_VEC3: {'u', 1, 3}, gl.UNSIGNED_INT_VEC4: {'u', 1, 4},
+	gl.BOOL: {'b', 1, 1}, gl.BOOL_VEC2: {'b', 1, 2}, gl.BOOL_VEC3: {'b', 1, 3}, gl.BOOL_VEC4: {'b', 1, 4},
+	gl.FLOAT_MAT2: {'f', 2, 2}, gl.FLOAT_MAT3: {'f', 3, 3}, gl.FLOAT_MAT4: {'f', 4, 4},
+	gl.FLOAT_MAT2x3: {'f', 2, 3}, gl.FLOAT_MAT2x4: {'f', 2, 4}, gl.FLOAT_MAT3x2: {'f', 3, 2},
+	gl.FLOAT_MAT3x4: {'f', 3, 4}, gl.FLOAT_MAT4x2: {'f', 4, 2}, gl.FLOAT_MAT4x3: {'f', 4, 3},
+}
+
+// typeOf returns the shape of t; samplers, images and atomic counters are
+// single ints.
+func typeOf(t gl.Enum) (uniformType, bool) {
+	if s, ok := uniformTypes[t]; ok {
+		return s, true
+	}
+	if name := glslTypes[t]; strings.Contains(name, "sampler") || strings.Contains(name, "image") || name == "atomic_uint" {
+		return uniformType{'s', 1, 1}, true
+	}
+	return uniformType{}, false
+}
+
+// uniformValue is a Go value converted for upload. comps is the number of
+// components of one element, 0 for slices whose shape comes from the
+// uniform.
+type uniformValue struct {
+	base  byte
+	comps int
+	data  []float64
+}
+
+func floats(base byte, comps int, v ...float32) uniformValue {
+	d := make([]float64, len(v))
+	for i, f := range v {
+		d[i] = float64(f)
+	}
+	return uniformValue{base, comps, d}
+}
+
+func ints(base byte, comps int, v ...int64) uniformValue {
+	d := make([]float64, len(v))
+	for i, n := range v {
+		d[i] = float64(n)
+	}
+	return uniformValue{base, comps, d}
+}
+
+// toUniform converts v, which may be a Go scalar, a float32, int32 or uint32
+// array of 2 to 4 elements, a [9]float32 or [16]float32 matrix, a
+// math3d.Matrix4, or a slice of floats, ints, uints or matrices.
+func toUniform(v interface{}) (uniformValue, error) {
+	switch v := v.(type) {
+	case float32:
+		return floats('f', 1, v), nil
+	case float64:
+		return uniformValue{'f', 1, []float64{v}}, nil
+	case int:
+		return ints('i', 1, int64(v)), nil
+	case int32:
+		return ints('i', 1, int64(v)), nil
+	case uint:
+		return ints('u', 1, int64(v)), nil
+	case uint32:
+		return ints('u', 1, int64(v)), nil
+	case bool:
+		if v {
+			return ints('b', 1, 1), nil
+		}
+		return ints('b', 1, 0), nil
+	case [2]float32:
+		return floats('f', 2, v[:]...), nil
+	case [3]float32:
+		return floats('f', 3, v[:]...), nil
+	case [4]float32:
+		return floats('f', 4, v[:]...), nil
+	case [9]float32:
+		return floats('f', 9, v[:]...), nil
+	case [16]float32:
+		return floats('f', 16, v[:]...), nil
+	case [2]int32:
+		return ints('i', 2, int64(v[0]), int64(v[1])), nil
+	case [3]int32:
+		return ints('i', 3, int64(v[0]), int64(v[1]), int64(v[2])), nil
+	case [4]int32:
+		return ints('i', 4, int64(v[0]), int64(v[1]), int64(v[2]), int64(v[3])), nil
+	case [2]uint32:
+		return ints('u', 2, int64(v[0]), int64(v[1])), nil
+	case [3]uint32:
+		return ints('u', 3, int64(v[0]), int64(v[1]), int64(v[2])), nil
+	case [4]uint32:
+		return ints('u', 4, int64(v[0]), int64(v[1]), int64(v[2]), int64(v[3])), nil
+	case *math3d.Matrix4:
+		a := v.ToArray32()
+		return floats('f', 16, a[:]...), nil
+	case math3d.Matrix4:
+		a := v.ToArray32()
+		return floats('f', 16, a[:]...), nil
+	case []float32:
+		return floats('f', 0, v...), nil
+	case []int32:
+		d := make([]int64, len(v))
+		for i, n := range v {
+			d[i] = int64(n)
+		}
+		return ints('i', 0, d...), nil
+	case []uint32:
+		d := make([]int64, len(v))
+		for i, n := range v {
+			d[i] = int64(n)
+		}
+		return ints('u', 0, d...), nil
+	case []*math3d.Matrix4:
+		var d []float32
+		for _, m := range v {
+			a := m.ToArray32()
+			d = append(d, a[:]...)
+		}
+		return floats('f', 0, d...), nil
+	}
+	return uniformValue{}, fmt.Errorf("unsupported Go type %T", v)
+}
+
+// checkUniform reports whether val fits u, returning why not.
+func checkUniform(u *Uniform, val uniformValue) error {
+	t, ok := typeOf(u.Type)
+	if !ok {
+		return fmt.Errorf("unsupported GLSL type %s", TypeName(u.Type))
+	}
+	fits := val.base == t.base
+	switch t.base {
+	case 'd':
+		fits = val.base == 'f'
+	case 's':
+		fits = val.base == 'i'
+	case 'b':
+		fits = true
+	}
+	if !fits {
+		return fmt.Errorf("%s value for a %s", baseName(val.base), TypeName(u.Type))
+	}
+
+	comps := t.cols * t.rows
+	if val.comps != 0 {
+		if val.comps != comps {
+			return fmt.Errorf("%d components for a %s", val.comps, TypeName(u.Type))
+		}
+		return nil
+	}
+	if len(val.data) == 0 || len(val.data)%comps != 0 {
+		return fmt.Errorf("%d values is not a whole number of %s", len(val.data), TypeName(u.Type))
+	}
+	if n := len(val.data) / comps; n > u.Size {
+		return fmt.Errorf("%d elements for %s[%d]", n, TypeName(u.Type), u.Size)
+	}
+	return nil
+}
+
+func baseName(b byte) string {
+	switch b {
+	case 'f':
+		return "float"
+	case 'i':
+		return "int"
+	case 'u':
+		return "uint"
+	}
+	return "bool"
+}
+
+// Set sets the uniform called name of the program, which must be current,
+// to v: a Go scalar, a float32, int32 or uint32 array of 2 to 4 elements,
+// a [9]float32 or [16]float32 column-major matrix, a *math3d.Matrix4, or a
+// slice of floats, ints, uints or matrices for arrays. Elements of arrays
+// may be named as name[i].
+//
+// Built with the debug tag, Set checks v against the reflected GLSL type
+// and reports a mismatch or a name that is not an active uniform as an error.
+// Otherwise only Go types Set can't handle are errors.
+func (p *Program) Set(name string, v interface{}) error {
+	val, err := toUniform(v)
+	if err != nil {
+		return &UniformError{Name: name, Msg: err.Error()}
+	}
+	u, ok := p.lookup(name)
+	if !ok || u.Location < 0 {
+		if debugUniforms {
+			return &UniformError{Name: name, Msg: "not an active uniform of the default block"}
+		}
+		return nil
+	}
+	if debugUniforms {
+		if err := checkUniform(u, val); err != nil {
+			return &UniformError{Name: name, Msg: err.Error()}
+		}
+	}
+	uploadUniform(u, val)
+	return nil
+}
+
+// lookup finds a uniform by name, adding array elements named name[i] to
+// the cache the first time.
+func (p *Program) lookup(name string) (*Uniform, bool) {
+	if u, ok := p.Uniform(name); ok {
+		return u, true
+	}
+	if p.elements == nil {
+		p.elements = make(map[string]*Uniform)
+	}
+	if u, ok := p.elements[name]; ok {
+		return u, u != nil
+	}
+
+	var elem *Uniform
+	if i := strings.LastIndex(name, "["); i > 0 && strings.HasSuffix(name, "]") {
+		n, err := strconv.Atoi(name[i+1 : len(name)-1])
+		if base, ok := p.Uniform(name[:i]); ok && err == nil && n > 0 && n < base.Size && base.Location >= 0 {
+			e := *base
+			e.Name, e.Size = name, base.Size-n
+			e.Location = uniformLocation(p.ID, name)
+			elem = &e
+		}
+	}
+	p.elements[name] = elem
+	return elem, elem != nil
+}
+
+// uniformLocation and uploadUniform are the GL calls of Set, variables so
+// tests can run without a context.
+var (
+	uniformLocation = func(program gl.Uint, name string) int {
+		cname := gl.GLString(name)
+		defer gl.GLStringFree(cname)
+		return int(gl.GetUniformLocation(program, cname))
+	}
+	uploadUniform = setUniform
+)
+
+// setUniform uploads val, converted to the type of u.
+func setUniform(u *Uniform, val uniformValue) {
+	t, ok := typeOf(u.Type)
+	if !ok {
+		return
+	}
+	comps := t.cols * t.rows
+	count := gl.Sizei(len(val.data) / comps)
+	if count == 0 {
+		return
+	}
+	loc := gl.Int(u.Location)
+
+	switch t.base {
+	case 'f':
+		d := make([]gl.Float, len(val.data))
+		for i, f := range val.data {
+			d[i] = gl.Float(f)
+		}
+		if t.cols == 1 {
+			[]func(gl.Int, gl.Sizei, *gl.Float){gl.Uniform1fv, gl.Uniform2fv, gl.Uniform3fv, gl.Uniform4fv}[t.rows-1](loc, count, &d[0])
+			return
+		}
+		matrix := map[[2]int]func(gl.Int, gl.Sizei, gl.Boolean, *gl.Float){
+			{2, 2}: gl.UniformMatrix2fv, {3, 3}: gl.UniformMatrix3fv, {4, 4}: gl.UniformMatrix4fv,
+			{2, 3}: gl.UniformMatrix2x3fv, {2, 4}: gl.UniformMatrix2x4fv, {3, 2}: gl.UniformMatrix3x2fv,
+			{3, 4}: gl.UniformMatrix3x4fv, {4, 2}: gl.UniformMatrix4x2fv, {4, 3}: gl.UniformMatrix4x3fv,
+		}[[2]int{t.cols, t.rows}]
+		matrix(loc, count, gl.FALSE, &d[0])
+	case 'd':
+		d := make([]gl.Double, len(val.data))
+		for i, f := range val.data {
+			d[i] = gl.Double(f)
+		}
+		[]func(gl.Int, gl.Sizei, *gl.Double){gl.Uniform1dv, gl.Uniform2dv, gl.Uniform3dv, gl.Uniform4dv}[t.rows-1](loc, count, &d[0])
+	case 'u':
+		d := make([]gl.Uint, len(val.data))
+		for i, f := range val.data {
+			d[i] = gl.Uint(f)
+		}
+		[]func(gl.Int, gl.Sizei, *gl.Uint){gl.Uniform1uiv, gl.Uniform2uiv, gl.Uniform3uiv, gl.Uniform4uiv}[t.rows-1](loc, count, &d[0])
+	default:
+		d := make([]gl.Int, len(val.data))
+		for i, f := range val.data {
+			if t.base == 'b' && f != 0 {
+				f = 1
+			}
+			d[i] = gl.Int(f)
+		}
+		[]func(gl.Int, gl.Sizei, *gl.Int){gl.Uniform1iv, gl.Uniform2iv, gl.Uniform3iv, gl.Uniform4iv}[t.rows-1](loc, count, &d[0])
+	}
+}
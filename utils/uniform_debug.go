@@ -0,0 +1,8 @@
+// uniform_debug
+//go:build debug
+// +build debug
+
+package utils
+
+// debugUniforms makes Program.Set check values against the reflected types.
+const debugUniforms = true
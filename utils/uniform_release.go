@@ -0,0 +1,7 @@
+// uniform_release
+//go:build !debug
+// +build !debug
+
+package utils
+
+const debugUniforms = false
@@ -0,0 +1,103 @@
+// uniform_test.go
+package utils
+
+import (
+	gl "github.com/chsc/gogl/gl42"
+	"github.com/ginuerzh/math3d"
+	"strings"
+	"testing"
+)
+
+func TestCheckUniform(t *testing.T) {
+	mvp := &Uniform{Name: "mvp", Type: gl.FLOAT_MAT4, Size: 1}
+	lights := &Uniform{Name: "lights[0]", Type: gl.FLOAT_VEC3, Size: 4}
+	tex := &Uniform{Name: "tex", Type: gl.SAMPLER_2D, Size: 1}
+	flag := &Uniform{Name: "flag", Type: gl.BOOL, Size: 1}
+	dmat := &Uniform{Name: "dmat", Type: gl.DOUBLE_MAT4, Size: 1}
+
+	for _, c := range []struct {
+		u  *Uniform
+		v  interface{}
+		ok bool
+	}{
+		{mvp, math3d.Perspective(60, 1, 0.1, 100), true},
+		{mvp, [16]float32{}, true},
+		{mvp, [9]float32{}, false},
+		{mvp, 1.0, false},
+		{mvp, []*math3d.Matrix4{{}}, true},
+		{mvp, []*math3d.Matrix4{{}, {}}, false},
+		{lights, make([]float32, 12), true},
+		{lights, make([]float32, 10), false},
+		{lights, make([]float32, 15), false},
+		{lights, [3]float32{}, true},
+		{lights, [3]int32{}, false},
+		{tex, 2, true},
+		{tex, float32(2), false},
+		{tex, uint32(2), false},
+		{flag, true, true},
+		{flag, float32(1), true},
+		{dmat, [16]float32{}, false},
+	} {
+		val, err := toUniform(c.v)
+		if err == nil {
+			err = checkUniform(c.u, val)
+		}
+		if (err == nil) != c.ok {
+			t.Errorf("%s = %T: %v", c.u.Name, c.v, err)
+		}
+	}
+
+	if _, err := toUniform("mvp"); err == nil {
+		t.Error("string should be rejected")
+	}
+}
+
+func TestProgramSetLookup(t *testing.T) {
+	var uploaded []string
+	defer func(l func(gl.Uint, string) int, u func(*Uniform, uniformValue)) {
+		uniformLocation, uploadUniform = l, u
+	}(uniformLocation, uploadUniform)
+	uniformLocation = func(program gl.Uint, name string) int { return 5 }
+	uploadUniform = func(u *Uniform, val uniformValue) { uploaded = append(uploaded, u.Name) }
+
+	p := &Program{Uniforms: []Uniform{
+		{Name: "lights[0]", Type: gl.FLOAT_VEC3, Size: 4, Location: 3, Block: -1},
+		{Name: "tint", Type: gl.FLOAT_VEC4, Size: 1, Location: -1, Block: 0},
+	}}
+	p.index()
+
+	if u, ok := p.lookup("lights[2]"); !ok || u.Size != 2 || u.Name != "lights[2]" || u.Location != 5 {
+		t.Errorf("lights[2] %+v", u)
+	}
+	for _, name := range []string{"lights[4]", "lights[x]", "tint[1]", "none"} {
+		if _, ok := p.lookup(name); ok {
+			t.Errorf("%s found", name)
+		}
+	}
+	if len(p.elements) != 5 {
+		t.Errorf("%d cached elements", len(p.elements))
+	}
+
+	// Unknown names and block members are only errors in debug builds.
+	for _, name := range []string{"none", "tint"} {
+		if err := p.Set(name, [4]float32{}); (err != nil) != debugUniforms {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if err := p.Set("lights", "red"); err == nil {
+		t.Error("unsupported Go type should fail")
+	}
+	if err := p.Set("lights", [2]float32{}); (err != nil) != debugUniforms {
+		t.Errorf("vec2 for vec3: %v", err)
+	}
+	if err := p.Set("lights[2]", make([]float32, 6)); err != nil {
+		t.Error(err)
+	}
+	want := "lights[2]"
+	if !debugUniforms {
+		want = "lights[0],lights[2]"
+	}
+	if got := strings.Join(uploaded, ","); got != want {
+		t.Errorf("uploaded %s, want %s", got, want)
+	}
+}
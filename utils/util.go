@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"encoding/binary"
 	gl "github.com/chsc/gogl/gl42"
+	"math"
 )
 
 func ToGLFloat(s []float32) []gl.Float {
@@ -11,3 +13,77 @@ func ToGLFloat(s []float32) []gl.Float {
 	}
 	return data
 }
+
+// Float32Bytes returns the little-endian bytes of s, e.g. for SaveKtxData.
+func Float32Bytes(s []float32) []byte {
+	data := make([]byte, len(s)*4)
+	for i, v := range s {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+	}
+	return data
+}
+
+// HalfToFloat32 converts an IEEE 754 half precision value.
+func HalfToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h) & 0x3ff
+
+	switch {
+	case exp == 0 && mant == 0:
+		return math.Float32frombits(sign)
+	case exp == 0:
+		// Subnormal, normalize it.
+		for mant&0x400 == 0 {
+			mant <<= 1
+			exp--
+		}
+		exp++
+		mant &= 0x3ff
+	case exp == 0x1f:
+		return math.Float32frombits(sign | 0xff<<23 | mant<<13)
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
+
+// Float32ToHalf converts f to IEEE 754 half precision, rounding to nearest
+// and saturating to infinity.
+func Float32ToHalf(f float32) uint16 {
+	b := math.Float32bits(f)
+	sign := uint16(b>>16) & 0x8000
+	exp := int32(b>>23&0xff) - 127 + 15
+	mant := b & 0x7fffff
+
+	switch {
+	case b&0x7fffffff > 0x7f800000:
+		return sign | 0x7e00 // NaN
+	case exp >= 0x1f:
+		return sign | 0x7c00
+	case exp <= 0:
+		if exp < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint32(14 - exp)
+		half := uint16(mant >> shift)
+		if mant>>(shift-1)&1 != 0 {
+			half++
+		}
+		return sign | half
+	}
+
+	half := sign | uint16(exp)<<10 | uint16(mant>>13)
+	if mant&0x1000 != 0 {
+		half++ // may carry into the exponent, which is still correct
+	}
+	return half
+}
+
+// HalfBytes returns the little-endian half precision bytes of s.
+func HalfBytes(s []float32) []byte {
+	data := make([]byte, len(s)*2)
+	for i, v := range s {
+		binary.LittleEndian.PutUint16(data[i*2:], Float32ToHalf(v))
+	}
+	return data
+}